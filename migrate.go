@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"iam-kevin/linkr/migrations"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const migrateUsage = "usage: linkr migrate up | down | status"
+
+// opens the database at `url`. postgres urls (`postgres://`, `postgresql://`)
+// go through lib/pq, libsql urls (`libsql://`, `https://`, `file:`...)
+// through libsql, while plain paths are sqlite files
+func openDatabase(url string) (*sqlx.DB, error) {
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		return sqlx.Open("postgres", url)
+	}
+
+	if url != "" && !strings.Contains(url, ":") {
+		return openSQLite("sqlite3", url)
+	}
+
+	return openSQLite("libsql", url)
+}
+
+// opens a sqlite database through `driverName`, with the foreign keys
+// enabled. sqlite leaves them off on every new connection, which
+// would leave the `ON DELETE` actions of the schema undone
+func openSQLite(driverName string, url string) (*sqlx.DB, error) {
+	// the driver registered as `driverName`
+	db, err := sql.Open(driverName, url)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	db.Close()
+
+	var connector driver.Connector = dsnConnector{dsn: url, driver: drv}
+	if withConnector, ok := drv.(driver.DriverContext); ok {
+		connector, err = withConnector.OpenConnector(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sqlx.NewDb(sql.OpenDB(foreignKeysConnector{connector}), driverName), nil
+}
+
+// connects drivers that don't open connectors of their own
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// enables the foreign keys of the connections it makes
+type foreignKeysConnector struct {
+	driver.Connector
+}
+
+func (c foreignKeysConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := execConn(ctx, conn, "PRAGMA foreign_keys = ON"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't enable the foreign keys: %w", err)
+	}
+
+	return conn, nil
+}
+
+// runs `query` on a connection of the driver
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, nil)
+		return err
+	}
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(nil)
+	return err
+}
+
+// runs the `migrate` subcommand
+func runMigrate(ctx context.Context, db *sqlx.DB, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "no migration to apply")
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+
+		if reverted == nil {
+			fmt.Fprintln(out, "no migration to revert")
+		} else {
+			fmt.Fprintf(out, "reverted %04d_%s\n", reverted.Version, reverted.Name)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestOpenDatabaseEnablesForeignKeys(t *testing.T) {
+	for _, url := range []string{filepath.Join(t.TempDir(), "linkr.db"), "file:" + filepath.Join(t.TempDir(), "linkr.db")} {
+		db, err := openDatabase(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// each connection enables them, so two are held at once
+		for _, conn := range []*sql.Conn{openConn(t, db), openConn(t, db)} {
+			enabled := 0
+			if err := conn.QueryRowContext(context.Background(), `PRAGMA foreign_keys`).Scan(&enabled); err != nil || enabled != 1 {
+				t.Errorf("%s: expected the foreign keys to be enabled, got %d %v", url, enabled, err)
+			}
+			conn.Close()
+		}
+
+		db.Close()
+	}
+}
+
+func openConn(t *testing.T, db *sqlx.DB) *sql.Conn {
+	t.Helper()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
@@ -0,0 +1,339 @@
+// Versioned changes of the schema of the database, embedded in the binary.
+//
+// Migrations are pairs of files named `<version>_<name>.up.sql` and
+// `<version>_<name>.down.sql`, applied in the order of their version.
+// Each dialect keeps its own files, in a directory named after it,
+// with the same versions. The versions applied are recorded in the
+// `schema_migrations` table
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var embedded embed.FS
+
+// table recording the migrations applied
+const Table = "schema_migrations"
+
+const (
+	// sqlite, and libsql
+	DialectSQLite   = "sqlite"
+	DialectPostgres = "postgres"
+)
+
+// dialect of the sql understood by the driver `driverName`
+func Dialect(driverName string) string {
+	if driverName == "postgres" || driverName == "pgx" {
+		return DialectPostgres
+	}
+
+	return DialectSQLite
+}
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+
+	// statements applying the migration
+	Up string
+	// statements reverting the migration
+	Down string
+}
+
+// migration, along with when it was applied
+type Status struct {
+	Migration
+
+	// nil when the migration is pending
+	AppliedAt *time.Time
+}
+
+// Loads the migrations from the files of `fsys`, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration '%s' isn't named <version>_<name>.(up|down).sql", entry.Name())
+		}
+
+		// already matched as digits
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read migration '%s': %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations '%s' and '%s' share version %d", m.Name, match[2], version)
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s doesn't have an up file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Applies and reverts the migrations on a database
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// migrations embedded in the binary, written for `dialect`
+func Embedded(dialect string) ([]Migration, error) {
+	fsys, err := fs.Sub(embedded, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return Load(fsys)
+}
+
+// Migrator of the migrations embedded in the binary,
+// in the dialect of the driver of `db`
+func New(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := Embedded(Dialect(db.DriverName()))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMigrator(db, migrations), nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	timestamp := "DATETIME"
+	if Dialect(m.db.DriverName()) == DialectPostgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS "`+Table+`" (
+		"version" INTEGER NOT NULL PRIMARY KEY,
+		"name" TEXT NOT NULL,
+		"applied_at" `+timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("couldn't create the %s table: %w", Table, err)
+	}
+
+	return nil
+}
+
+// when each applied version was applied
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows := []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}{}
+	if err := m.db.SelectContext(ctx, &rows, `SELECT version, applied_at FROM "`+Table+`"`); err != nil {
+		return nil, fmt.Errorf("couldn't list the applied migrations: %w", err)
+	}
+
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+
+	return applied, nil
+}
+
+// Lists the migrations, and when they were applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if at, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Applies the pending migrations in order, stopping at the first
+// that fails. Responds with the migrations applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := m.run(ctx, migration.Up, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, tx.Rebind(`INSERT INTO "`+Table+`" (version, name, applied_at) VALUES (?, ?, ?)`), migration.Version, migration.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("couldn't apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Reverts the last migration applied. Responds with nil when
+// no migration was applied
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if strings.TrimSpace(migration.Down) == "" {
+			return nil, fmt.Errorf("migration %d_%s can't be reverted", migration.Version, migration.Name)
+		}
+
+		err := m.run(ctx, migration.Down, func(tx *sqlx.Tx) error {
+			_, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM "`+Table+`" WHERE version = ?`), migration.Version)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't revert migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		return &migration, nil
+	}
+
+	return nil, nil
+}
+
+// runs the statements of `script` and `record` in a single transaction
+func (m *Migrator) run(ctx context.Context, script string, record func(tx *sqlx.Tx) error) error {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// executed one by one, as not every driver runs several
+	// statements at once
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%w\n%s", err, stmt)
+		}
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// splits the script on the `;` ending its statements, leaving out
+// the `--` comments. `;` within quotes don't end statements
+func splitStatements(script string) []string {
+	statements := []string{}
+	current := strings.Builder{}
+
+	var quote rune
+	inComment := false
+	runes := []rune(script)
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+				current.WriteRune(c)
+			}
+		case quote != 0:
+			current.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteRune(c)
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			inComment = true
+		case c == ';':
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+
+	return statements
+}
@@ -0,0 +1,287 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each connection to :memory: is a different database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func tableExists(t *testing.T, db *sqlx.DB, table string) bool {
+	t.Helper()
+
+	count := 0
+	if err := db.Get(&count, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table); err != nil {
+		t.Fatal(err)
+	}
+
+	return count > 0
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) == 0 || applied[0].Version != 1 {
+		t.Fatalf("expected the migrations to be applied from version 1, got %v", applied)
+	}
+
+	for _, table := range []string{"ApiClient", "Namespace", "Link", Table} {
+		if !tableExists(t, db, table) {
+			t.Errorf("expected table %s to exist", table)
+		}
+	}
+
+	tag := ""
+	if err := db.Get(&tag, `SELECT unique_tag FROM "Namespace"`); err != nil || tag != "-" {
+		t.Errorf("expected the global namespace to be seeded, got '%s' %v", tag, err)
+	}
+
+	if again, err := m.Up(ctx); err != nil || len(again) != 0 {
+		t.Errorf("expected nothing left to apply, got %v %v", again, err)
+	}
+
+	// reverting every migration leaves only the migrations table
+	for {
+		reverted, err := m.Down(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reverted == nil {
+			break
+		}
+	}
+
+	if tableExists(t, db, "Namespace") {
+		t.Error("expected the tables to be dropped")
+	}
+}
+
+// the schema `prisma db push` created before the schema was versioned
+const baselineSchema = `
+CREATE TABLE "ApiClient" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "username" TEXT NOT NULL,
+    "description" TEXT,
+    "scope" TEXT NOT NULL,
+    "signing_key" TEXT NOT NULL,
+    "created_at" DATETIME NOT NULL,
+    "updated_at" DATETIME NOT NULL
+);
+CREATE UNIQUE INDEX "ApiClient_username_key" ON "ApiClient"("username");
+CREATE TABLE "Namespace" (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "unique_tag" TEXT NOT NULL,
+    "desc" TEXT
+);
+CREATE UNIQUE INDEX "Namespace_unique_tag_key" ON "Namespace"("unique_tag");
+CREATE TABLE "Link" (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "identifier" TEXT NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "destination_url" TEXT NOT NULL,
+    "expires_in" INTEGER,
+    "expires_at" DATETIME,
+    "headers" TEXT,
+    CONSTRAINT "Link_namespace_id_fkey" FOREIGN KEY ("namespace_id") REFERENCES "Namespace" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
+);
+CREATE INDEX "Link_identifier_idx" ON "Link"("identifier");
+CREATE UNIQUE INDEX "Link_identifier_namespace_id_key" ON "Link"("identifier", "namespace_id");
+INSERT INTO "ApiClient" VALUES ('api_1', 'bot', NULL, 'admin', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
+INSERT INTO "Namespace" ("unique_tag") VALUES ('-');
+INSERT INTO "Link" ("identifier", "namespace_id", "destination_url") VALUES ('abc', 1, 'https://dest.example');
+`
+
+func TestUpgradeBaselineDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	db.MustExec(baselineSchema)
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the columns added since are there, and the records are kept
+	link := struct {
+		Identifier  string  `db:"identifier"`
+		ForwardMode *string `db:"forward_mode"`
+		CreatedAt   string  `db:"created_at"`
+	}{}
+	if err := db.Get(&link, `SELECT identifier, forward_mode, created_at FROM "Link"`); err != nil || link.Identifier != "abc" || link.CreatedAt == "" {
+		t.Errorf("expected the link to be kept, got %+v %v", link, err)
+	}
+
+	algorithm := ""
+	if err := db.Get(&algorithm, `SELECT algorithm FROM "ApiClient" WHERE disabled_at IS NULL AND last_used_at IS NULL`); err != nil || algorithm != "HS256" {
+		t.Errorf("expected the client to be kept, got '%s' %v", algorithm, err)
+	}
+
+	if _, err := db.Exec(`SELECT expired_url, id_strategy, allow_hosts, deny_hosts, owner_id, archived_at FROM "Namespace"`); err != nil {
+		t.Errorf("expected the namespace columns to be added: %v", err)
+	}
+
+	// links are still unique in their namespace once the table is rebuilt
+	if _, err := db.Exec(`INSERT INTO "Link" ("identifier", "namespace_id", "destination_url") VALUES ('abc', 1, 'https://other.example')`); err == nil {
+		t.Error("expected the unique index of the links to be kept")
+	}
+
+	// the clicks of deleted links cascade with the foreign keys on
+	db.MustExec(`INSERT INTO "Click" (link_id, namespace_id, clicked_at) VALUES (1, 1, CURRENT_TIMESTAMP)`)
+	db.MustExec(`DELETE FROM "Link"`)
+
+	clicks := 0
+	if err := db.Get(&clicks, `SELECT COUNT(*) FROM "Click"`); err != nil || clicks != 0 {
+		t.Errorf("expected the clicks to be deleted with their link, got %d %v", clicks, err)
+	}
+}
+
+func TestDialectsShareVersions(t *testing.T) {
+	sqlite, err := Embedded(DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postgres, err := Embedded(DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(migrations []Migration) []string {
+		res := []string{}
+		for _, m := range migrations {
+			res = append(res, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
+		return res
+	}
+
+	if !reflect.DeepEqual(names(sqlite), names(postgres)) {
+		t.Errorf("expected the dialects to have the same migrations, got %v and %v", names(sqlite), names(postgres))
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	migrations, err := Load(fstest.MapFS{
+		"0002_add_notes.up.sql":   {Data: []byte(`ALTER TABLE "Thing" ADD COLUMN "notes" TEXT; -- free text`)},
+		"0002_add_notes.down.sql": {Data: []byte(`ALTER TABLE "Thing" DROP COLUMN "notes";`)},
+		"0001_init.up.sql":        {Data: []byte("CREATE TABLE \"Thing\" (\"id\" INTEGER NOT NULL PRIMARY KEY);\nINSERT INTO \"Thing\" (id) VALUES (1);")},
+		"0001_init.down.sql":      {Data: []byte(`DROP TABLE "Thing";`)},
+		"0003_broken.up.sql":      {Data: []byte(`INSERT INTO "Thing" (id) VALUES (2); INSERT INTO "Nothing" (id) VALUES (1);`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []int{}
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+
+	if !reflect.DeepEqual(versions, []int{1, 2, 3}) {
+		t.Fatalf("expected migrations ordered by version, got %v", versions)
+	}
+
+	m := NewMigrator(db, migrations)
+
+	applied, err := m.Up(ctx)
+	if err == nil || len(applied) != 2 {
+		t.Fatalf("expected the broken migration to stop the others, got %d applied: %v", len(applied), err)
+	}
+
+	// the broken migration is rolled back as a whole
+	count := 0
+	db.Get(&count, `SELECT COUNT(*) FROM "Thing"`)
+	if count != 1 {
+		t.Errorf("expected the broken migration to be rolled back, got %d things", count)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range statuses {
+		if pending := status.AppliedAt == nil; pending != (status.Version == 3) {
+			t.Errorf("migration %d: pending %v", status.Version, pending)
+		}
+	}
+
+	reverted, err := m.Down(ctx)
+	if err != nil || reverted == nil || reverted.Version != 2 {
+		t.Fatalf("expected migration 2 to be reverted, got %v %v", reverted, err)
+	}
+
+	if _, err := db.Exec(`SELECT notes FROM "Thing"`); err == nil {
+		t.Error("expected the notes column to be dropped")
+	}
+
+	if statuses, _ := m.Status(ctx); statuses[1].AppliedAt != nil {
+		t.Error("expected migration 2 to be pending once reverted")
+	}
+}
+
+func TestLoadInvalidMigrations(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"unknown file":     {"init.sql": {Data: []byte(`SELECT 1;`)}},
+		"missing up":       {"0001_init.down.sql": {Data: []byte(`SELECT 1;`)}},
+		"version conflict": {"0001_init.up.sql": {Data: []byte(`SELECT 1;`)}, "0001_other.up.sql": {Data: []byte(`SELECT 1;`)}},
+	} {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: expected migrations to be rejected", name)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+-- leading comment; with a semicolon
+CREATE TABLE "a" ("b" TEXT DEFAULT ';'); -- trailing
+INSERT INTO "a" VALUES ('it''s');
+
+`
+
+	got := splitStatements(script)
+	want := []string{`CREATE TABLE "a" ("b" TEXT DEFAULT ';')`, `INSERT INTO "a" VALUES ('it''s')`}
+
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
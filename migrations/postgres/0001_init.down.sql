@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "Link";
+DROP TABLE IF EXISTS "Namespace";
+DROP TABLE IF EXISTS "ApiClient";
@@ -0,0 +1,36 @@
+-- schema of the service before it was versioned, for postgres.
+-- kept in step with `sqlite/0001_init.up.sql`
+
+CREATE TABLE IF NOT EXISTS "ApiClient" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "username" TEXT NOT NULL,
+    "description" TEXT,
+    "scope" TEXT NOT NULL,
+    "signing_key" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    "updated_at" TIMESTAMPTZ NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "ApiClient_username_key" ON "ApiClient"("username");
+
+CREATE TABLE IF NOT EXISTS "Namespace" (
+    "id" SERIAL NOT NULL PRIMARY KEY,
+    "unique_tag" TEXT NOT NULL,
+    "desc" TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "Namespace_unique_tag_key" ON "Namespace"("unique_tag");
+
+CREATE TABLE IF NOT EXISTS "Link" (
+    "id" SERIAL NOT NULL PRIMARY KEY,
+    "identifier" TEXT NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "destination_url" TEXT NOT NULL,
+    "expires_in" INTEGER,
+    "expires_at" TIMESTAMPTZ,
+    "headers" TEXT,
+    CONSTRAINT "Link_namespace_id_fkey" FOREIGN KEY ("namespace_id") REFERENCES "Namespace" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "Link_identifier_idx" ON "Link"("identifier");
+CREATE UNIQUE INDEX IF NOT EXISTS "Link_identifier_namespace_id_key" ON "Link"("identifier", "namespace_id");
+
+-- links without a namespace belong to the global namespace
+INSERT INTO "Namespace" ("unique_tag") VALUES ('-') ON CONFLICT ("unique_tag") DO NOTHING;
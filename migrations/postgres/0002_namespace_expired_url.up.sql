@@ -0,0 +1,2 @@
+-- where visitors of expired links of the namespace are sent
+ALTER TABLE "Namespace" ADD COLUMN "expired_url" TEXT;
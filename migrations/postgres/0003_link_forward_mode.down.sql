@@ -0,0 +1 @@
+ALTER TABLE "Link" DROP COLUMN "forward_mode";
@@ -0,0 +1,3 @@
+-- when the link was created. existing links are taken
+-- as created when the migration runs
+ALTER TABLE "Link" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP TABLE "IdCounter";
+ALTER TABLE "Namespace" DROP COLUMN "id_strategy";
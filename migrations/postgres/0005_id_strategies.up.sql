@@ -0,0 +1,9 @@
+-- how identifiers of links in the namespace are generated
+ALTER TABLE "Namespace" ADD COLUMN "id_strategy" TEXT;
+
+-- counter of the base62 identifiers, per namespace
+CREATE TABLE "IdCounter" (
+    "namespace_id" INTEGER NOT NULL PRIMARY KEY,
+    "value" BIGINT NOT NULL,
+    CONSTRAINT "IdCounter_namespace_id_fkey" FOREIGN KEY ("namespace_id") REFERENCES "Namespace" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
+);
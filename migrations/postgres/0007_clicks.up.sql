@@ -0,0 +1,13 @@
+-- visits of the links
+CREATE TABLE "Click" (
+    "id" BIGSERIAL NOT NULL PRIMARY KEY,
+    "link_id" INTEGER NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "clicked_at" TIMESTAMPTZ NOT NULL,
+    "referrer" TEXT,
+    "user_agent" TEXT,
+    "ip_hash" TEXT,
+    CONSTRAINT "Click_link_id_fkey" FOREIGN KEY ("link_id") REFERENCES "Link" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "Click_link_id_clicked_at_idx" ON "Click"("link_id", "clicked_at");
+CREATE INDEX "Click_namespace_id_clicked_at_idx" ON "Click"("namespace_id", "clicked_at");
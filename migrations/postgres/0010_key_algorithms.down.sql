@@ -0,0 +1,2 @@
+ALTER TABLE "ClientKey" DROP COLUMN "algorithm";
+ALTER TABLE "ApiClient" DROP COLUMN "algorithm";
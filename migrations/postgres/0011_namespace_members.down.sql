@@ -0,0 +1,2 @@
+DROP TABLE "NamespaceMember";
+ALTER TABLE "Namespace" DROP COLUMN "owner_id";
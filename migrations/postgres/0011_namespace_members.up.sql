@@ -0,0 +1,14 @@
+-- client the namespace belongs to
+ALTER TABLE "Namespace" ADD COLUMN "owner_id" TEXT REFERENCES "ApiClient" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- clients granted a role in namespaces they don't own
+CREATE TABLE "NamespaceMember" (
+    "namespace_id" INTEGER NOT NULL,
+    "client_id" TEXT NOT NULL,
+    "role" TEXT NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY ("namespace_id", "client_id"),
+    CONSTRAINT "NamespaceMember_namespace_id_fkey" FOREIGN KEY ("namespace_id") REFERENCES "Namespace" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT "NamespaceMember_client_id_fkey" FOREIGN KEY ("client_id") REFERENCES "ApiClient" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "NamespaceMember_client_id_idx" ON "NamespaceMember"("client_id");
@@ -0,0 +1 @@
+ALTER TABLE "Namespace" DROP COLUMN "archived_at";
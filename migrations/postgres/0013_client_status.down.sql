@@ -0,0 +1,2 @@
+ALTER TABLE "ApiClient" DROP COLUMN "last_used_at";
+ALTER TABLE "ApiClient" DROP COLUMN "disabled_at";
@@ -0,0 +1,3 @@
+-- when the client was disabled, and last authenticated
+ALTER TABLE "ApiClient" ADD COLUMN "disabled_at" TIMESTAMPTZ;
+ALTER TABLE "ApiClient" ADD COLUMN "last_used_at" TIMESTAMPTZ;
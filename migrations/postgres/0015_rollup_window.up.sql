@@ -0,0 +1,5 @@
+-- the rollup follows the time of the clicks, lagging behind now, as click
+-- ids aren't handed out in the order the clicks are committed.
+-- clicks already rolled up are the ones up to the last click id
+ALTER TABLE "RollupState" ADD COLUMN "rolled_up_until" TIMESTAMPTZ;
+UPDATE "RollupState" SET "rolled_up_until" = (SELECT MAX("clicked_at") FROM "Click" WHERE "id" <= "RollupState"."last_click_id");
@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// Connects to the postgres database of `LINKR_TEST_POSTGRES_URL`, within
+// a schema created for the test. Skips the test when it isn't defined
+func newPostgresTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv("LINKR_TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("LINKR_TEST_POSTGRES_URL not defined")
+	}
+
+	admin, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("linkr_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	db, err := sqlx.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := newPostgresTestDB(t)
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied, err := m.Up(ctx); err != nil || len(applied) == 0 {
+		t.Fatalf("expected the migrations to be applied, got %v %v", applied, err)
+	}
+
+	tag := ""
+	if err := db.Get(&tag, `SELECT unique_tag FROM "Namespace"`); err != nil || tag != "-" {
+		t.Errorf("expected the global namespace to be seeded, got '%s' %v", tag, err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("expected migration %d to be applied", status.Version)
+		}
+	}
+
+	for {
+		reverted, err := m.Down(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reverted == nil {
+			break
+		}
+	}
+
+	count := 0
+	if err := db.Get(&count, `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'Namespace'`); err != nil || count != 0 {
+		t.Errorf("expected the tables to be dropped, got %d %v", count, err)
+	}
+}
@@ -0,0 +1,38 @@
+-- schema of the service before it was versioned, as `prisma db push`
+-- created it. tables are only created when they don't exist, so those
+-- databases are taken as they are, and brought up to date by the
+-- migrations that follow
+
+CREATE TABLE IF NOT EXISTS "ApiClient" (
+    "id" TEXT NOT NULL PRIMARY KEY,
+    "username" TEXT NOT NULL,
+    "description" TEXT,
+    "scope" TEXT NOT NULL,
+    "signing_key" TEXT NOT NULL,
+    "created_at" DATETIME NOT NULL,
+    "updated_at" DATETIME NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "ApiClient_username_key" ON "ApiClient"("username");
+
+CREATE TABLE IF NOT EXISTS "Namespace" (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "unique_tag" TEXT NOT NULL,
+    "desc" TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "Namespace_unique_tag_key" ON "Namespace"("unique_tag");
+
+CREATE TABLE IF NOT EXISTS "Link" (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "identifier" TEXT NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "destination_url" TEXT NOT NULL,
+    "expires_in" INTEGER,
+    "expires_at" DATETIME,
+    "headers" TEXT,
+    CONSTRAINT "Link_namespace_id_fkey" FOREIGN KEY ("namespace_id") REFERENCES "Namespace" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "Link_identifier_idx" ON "Link"("identifier");
+CREATE UNIQUE INDEX IF NOT EXISTS "Link_identifier_namespace_id_key" ON "Link"("identifier", "namespace_id");
+
+-- links without a namespace belong to the global namespace
+INSERT OR IGNORE INTO "Namespace" ("unique_tag") VALUES ('-');
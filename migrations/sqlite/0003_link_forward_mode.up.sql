@@ -0,0 +1,2 @@
+-- how the forwarded headers of the link reach its destination
+ALTER TABLE "Link" ADD COLUMN "forward_mode" TEXT;
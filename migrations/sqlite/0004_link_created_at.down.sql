@@ -0,0 +1,17 @@
+CREATE TABLE "new_Link" (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "identifier" TEXT NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "destination_url" TEXT NOT NULL,
+    "expires_in" INTEGER,
+    "expires_at" DATETIME,
+    "headers" TEXT,
+    "forward_mode" TEXT,
+    CONSTRAINT "Link_namespace_id_fkey" FOREIGN KEY ("namespace_id") REFERENCES "Namespace" ("id") ON DELETE RESTRICT ON UPDATE CASCADE
+);
+INSERT INTO "new_Link" ("id", "identifier", "namespace_id", "destination_url", "expires_in", "expires_at", "headers", "forward_mode")
+    SELECT "id", "identifier", "namespace_id", "destination_url", "expires_in", "expires_at", "headers", "forward_mode" FROM "Link";
+DROP TABLE "Link";
+ALTER TABLE "new_Link" RENAME TO "Link";
+CREATE INDEX "Link_identifier_idx" ON "Link"("identifier");
+CREATE UNIQUE INDEX "Link_identifier_namespace_id_key" ON "Link"("identifier", "namespace_id");
@@ -0,0 +1,2 @@
+ALTER TABLE "Namespace" DROP COLUMN "deny_hosts";
+ALTER TABLE "Namespace" DROP COLUMN "allow_hosts";
@@ -0,0 +1,3 @@
+-- comma separated hosts links of the namespace can, and can't, point to
+ALTER TABLE "Namespace" ADD COLUMN "allow_hosts" TEXT;
+ALTER TABLE "Namespace" ADD COLUMN "deny_hosts" TEXT;
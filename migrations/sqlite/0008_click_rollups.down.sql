@@ -0,0 +1,5 @@
+DROP TABLE "RollupState";
+DROP TABLE "ClickAgentDaily";
+DROP TABLE "ClickReferrerDaily";
+DROP TABLE "ClickVisitorDaily";
+DROP TABLE "ClickHourly";
@@ -0,0 +1,48 @@
+-- aggregates of the clicks, filled from `Click` by the rollup job
+CREATE TABLE "ClickHourly" (
+    "link_id" INTEGER NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "bucket_start" DATETIME NOT NULL,
+    "clicks" INTEGER NOT NULL,
+    PRIMARY KEY ("link_id", "bucket_start"),
+    CONSTRAINT "ClickHourly_link_id_fkey" FOREIGN KEY ("link_id") REFERENCES "Link" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "ClickHourly_namespace_id_bucket_start_idx" ON "ClickHourly"("namespace_id", "bucket_start");
+
+CREATE TABLE "ClickVisitorDaily" (
+    "link_id" INTEGER NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "day" DATETIME NOT NULL,
+    "ip_hash" TEXT NOT NULL,
+    PRIMARY KEY ("link_id", "day", "ip_hash"),
+    CONSTRAINT "ClickVisitorDaily_link_id_fkey" FOREIGN KEY ("link_id") REFERENCES "Link" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "ClickVisitorDaily_namespace_id_day_idx" ON "ClickVisitorDaily"("namespace_id", "day");
+
+CREATE TABLE "ClickReferrerDaily" (
+    "link_id" INTEGER NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "day" DATETIME NOT NULL,
+    "referrer_host" TEXT NOT NULL,
+    "clicks" INTEGER NOT NULL,
+    PRIMARY KEY ("link_id", "day", "referrer_host"),
+    CONSTRAINT "ClickReferrerDaily_link_id_fkey" FOREIGN KEY ("link_id") REFERENCES "Link" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "ClickReferrerDaily_namespace_id_day_idx" ON "ClickReferrerDaily"("namespace_id", "day");
+
+CREATE TABLE "ClickAgentDaily" (
+    "link_id" INTEGER NOT NULL,
+    "namespace_id" INTEGER NOT NULL,
+    "day" DATETIME NOT NULL,
+    "agent_family" TEXT NOT NULL,
+    "clicks" INTEGER NOT NULL,
+    PRIMARY KEY ("link_id", "day", "agent_family"),
+    CONSTRAINT "ClickAgentDaily_link_id_fkey" FOREIGN KEY ("link_id") REFERENCES "Link" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "ClickAgentDaily_namespace_id_day_idx" ON "ClickAgentDaily"("namespace_id", "day");
+
+-- how far the rollup job went
+CREATE TABLE "RollupState" (
+    "name" TEXT NOT NULL PRIMARY KEY,
+    "last_click_id" INTEGER NOT NULL
+);
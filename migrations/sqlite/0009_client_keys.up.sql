@@ -0,0 +1,10 @@
+-- rotated keys of the clients, working until they expire
+CREATE TABLE "ClientKey" (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "client_id" TEXT NOT NULL,
+    "signing_key" TEXT NOT NULL,
+    "created_at" DATETIME NOT NULL,
+    "expires_at" DATETIME NOT NULL,
+    CONSTRAINT "ClientKey_client_id_fkey" FOREIGN KEY ("client_id") REFERENCES "ApiClient" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX "ClientKey_client_id_expires_at_idx" ON "ClientKey"("client_id", "expires_at");
@@ -0,0 +1,3 @@
+-- algorithm the keys of the clients sign with
+ALTER TABLE "ApiClient" ADD COLUMN "algorithm" TEXT NOT NULL DEFAULT 'HS256';
+ALTER TABLE "ClientKey" ADD COLUMN "algorithm" TEXT NOT NULL DEFAULT 'HS256';
@@ -0,0 +1,2 @@
+-- when the namespace was archived
+ALTER TABLE "Namespace" ADD COLUMN "archived_at" DATETIME;
@@ -0,0 +1,2 @@
+ALTER TABLE "ApiClient" DROP COLUMN "deny_hosts";
+ALTER TABLE "ApiClient" DROP COLUMN "allow_hosts";
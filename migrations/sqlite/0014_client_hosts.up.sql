@@ -0,0 +1,3 @@
+-- comma separated hosts the links of the client can, and can't, point to
+ALTER TABLE "ApiClient" ADD COLUMN "allow_hosts" TEXT;
+ALTER TABLE "ApiClient" ADD COLUMN "deny_hosts" TEXT;
@@ -0,0 +1 @@
+ALTER TABLE "RollupState" DROP COLUMN "rolled_up_until";
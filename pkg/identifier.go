@@ -0,0 +1,152 @@
+package linkr
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// shortest custom identifier accepted
+	MinIdentifierLength = 3
+	// longest custom identifier accepted
+	MaxIdentifierLength = 64
+	// longest namespace tag accepted, so shortened urls stay short
+	MaxNamespaceTagLength = 4
+
+	// shortest username of a client accepted
+	MinUsernameLength = 3
+	// longest username of a client accepted
+	MaxUsernameLength = 32
+)
+
+var (
+	identifierPattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	namespaceTagPattern = regexp.MustCompile(`^\w+$`)
+	usernamePattern     = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]*$`)
+)
+
+// Retrieve the list of words that can't be used as identifiers,
+// since they clash with the routes served by linkr
+func ReservedIdentifiers() []string {
+	return []string{ReservedGlobalChar, "v1", "api", "health", "metrics"}
+}
+
+// check if the identifier is one of the reserved words
+func IsReservedIdentifier(identifier string) bool {
+	for _, reserved := range ReservedIdentifiers() {
+		if strings.EqualFold(identifier, reserved) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks that a custom identifier can be used in a shortened url.
+// Identifiers must be [MinIdentifierLength, MaxIdentifierLength] long,
+// made of letters, digits, `-` or `_`, start with a letter or digit
+// and not be a reserved word
+func ValidateIdentifier(identifier string) error {
+	if len(identifier) < MinIdentifierLength || len(identifier) > MaxIdentifierLength {
+		return fmt.Errorf("identifier must be between %d and %d characters long", MinIdentifierLength, MaxIdentifierLength)
+	}
+
+	if !identifierPattern.MatchString(identifier) {
+		return fmt.Errorf("identifier can only contain letters, digits, '-' or '_' and must start with a letter or digit")
+	}
+
+	if IsReservedIdentifier(identifier) {
+		return fmt.Errorf("identifier '%s' is reserved", identifier)
+	}
+
+	return nil
+}
+
+// Checks that a tag can be used for a namespace. Tags are 1 to
+// MaxNamespaceTagLength letters, digits or `_` long, and can't be a reserved word
+func ValidateNamespaceTag(tag string) error {
+	if len(tag) == 0 || len(tag) > MaxNamespaceTagLength {
+		return fmt.Errorf("tag must be between 1 and %d characters long", MaxNamespaceTagLength)
+	}
+
+	if !namespaceTagPattern.MatchString(tag) {
+		return fmt.Errorf("tag can only contain letters, digits or '_'")
+	}
+
+	if IsReservedIdentifier(tag) {
+		return fmt.Errorf("tag '%s' is reserved", tag)
+	}
+
+	return nil
+}
+
+// Checks that a username can be given to a client. Usernames are
+// [MinUsernameLength, MaxUsernameLength] long, made of letters, digits,
+// `.`, `-` or `_`, and start with a letter
+func ValidateUsername(username string) error {
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters long", MinUsernameLength, MaxUsernameLength)
+	}
+
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username can only contain letters, digits, '.', '-' or '_' and must start with a letter")
+	}
+
+	return nil
+}
+
+const suggestionAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// Suggests `count` identifiers resembling `identifier`, to use when
+// it's already taken. Suggestions are valid identifiers, but might be taken too
+func SuggestIdentifiers(identifier string, count int) []string {
+	// leave room for the longest suffix
+	base := identifier
+	if len(base) > MaxIdentifierLength-4 {
+		base = base[:MaxIdentifierLength-4]
+	}
+
+	candidates := []string{
+		fmt.Sprintf("%s%s", base, time.Now().Format("06")),
+	}
+
+	for n := 2; len(candidates) < count*2; n++ {
+		candidates = append(candidates, fmt.Sprintf("%s-%d", base, n))
+		candidates = append(candidates, fmt.Sprintf("%s-%s", base, randomString(suggestionAlphabet, 3)))
+	}
+
+	suggestions := []string{}
+	for _, candidate := range candidates {
+		if len(suggestions) == count {
+			break
+		}
+
+		if candidate != identifier && ValidateIdentifier(candidate) == nil && !includes(suggestions, candidate) {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+
+	return suggestions
+}
+
+func randomString(alphabet string, length int) string {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+
+	return string(b)
+}
+
+func includes[T comparable](haystack []T, needle T) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package linkr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateIdentifier(t *testing.T) {
+	tests := []struct {
+		identifier string
+		valid      bool
+	}{
+		{"launch2026", true},
+		{"Launch_2026-v2", true},
+		{"ab", false},
+		{strings.Repeat("a", MaxIdentifierLength), true},
+		{strings.Repeat("a", MaxIdentifierLength+1), false},
+		{"-launch", false},
+		{"launch/2026", false},
+		{"laün", false},
+		{"api", false},
+		{"V1x", true},
+		{"Health", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateIdentifier(tt.identifier)
+		if tt.valid && err != nil {
+			t.Errorf("expected '%s' to be valid: %s", tt.identifier, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("expected '%s' to be invalid", tt.identifier)
+		}
+	}
+
+	if err := ValidateIdentifier("v1"); err == nil {
+		t.Error("expected reserved 'v1' to be invalid")
+	}
+}
+
+func TestSuggestIdentifiers(t *testing.T) {
+	for _, identifier := range []string{"launch2026", strings.Repeat("a", MaxIdentifierLength)} {
+		suggestions := SuggestIdentifiers(identifier, 3)
+		if len(suggestions) != 3 {
+			t.Fatalf("expected 3 suggestions, got %v", suggestions)
+		}
+
+		for _, s := range suggestions {
+			if s == identifier {
+				t.Errorf("suggested the taken identifier '%s'", s)
+			}
+
+			if err := ValidateIdentifier(s); err != nil {
+				t.Errorf("suggested invalid identifier '%s': %s", s, err)
+			}
+		}
+	}
+}
+
+func TestValidateNamespaceTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		valid bool
+	}{
+		{"d", true},
+		{"docs", true},
+		{"d_2", true},
+		{"", false},
+		{"notes", false},
+		{"-", false},
+		{"a-b", false},
+		{"ü", false},
+		{"v1", false},
+		{"API", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateNamespaceTag(tt.tag)
+		if tt.valid && err != nil {
+			t.Errorf("expected '%s' to be valid: %s", tt.tag, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("expected '%s' to be invalid", tt.tag)
+		}
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"bot", true},
+		{"Marketing.Bot_2-x", true},
+		{"ab", false},
+		{strings.Repeat("a", MaxUsernameLength), true},
+		{strings.Repeat("a", MaxUsernameLength+1), false},
+		{"2fast", false},
+		{"_bot", false},
+		{"my bot", false},
+		{"bot@examp.le", false},
+		{"bøt", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateUsername(tt.username)
+		if tt.valid && err != nil {
+			t.Errorf("expected '%s' to be valid: %s", tt.username, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("expected '%s' to be invalid", tt.username)
+		}
+	}
+}
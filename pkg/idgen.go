@@ -0,0 +1,227 @@
+package linkr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/lucsky/cuid"
+)
+
+const (
+	// cuid slugs. used when the namespace doesn't pick a strategy
+	IdStrategyCuid = "cuid"
+	// base62 encoded counter, shortest identifiers
+	IdStrategyBase62 = "base62"
+	// random characters of a fixed length
+	IdStrategyRandom = "random"
+	// human readable word pairs, like `brave-otter`
+	IdStrategyWords = "words"
+	// derived from the destination url, so links to the
+	// same url share the identifier
+	IdStrategyHash = "hash"
+)
+
+// Retrieve the list of supported identifier generation strategies
+func SupportedIdStrategies() []string {
+	return []string{IdStrategyCuid, IdStrategyBase62, IdStrategyRandom, IdStrategyWords, IdStrategyHash}
+}
+
+// Generates identifiers of shortened links
+type IdGenerator interface {
+	// generates the identifier of a link to `url`. `attempt` counts the
+	// identifiers that collided with existing links, starting at 0
+	Generate(url string, attempt int) (string, error)
+}
+
+// Implemented by generators whose identifiers are derived from the url,
+// meaning a collision could be with a link to the same url
+type DeduplicatingIdGenerator interface {
+	IdGenerator
+	Deduplicates() bool
+}
+
+// check if the identifiers of `g` are derived from the url
+func Deduplicates(g IdGenerator) bool {
+	d, ok := g.(DeduplicatingIdGenerator)
+	return ok && d.Deduplicates()
+}
+
+const (
+	AlphabetBase62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// base62 without the characters that are easily confused (0, O, 1, l, I)
+	AlphabetUnambiguous = "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// encodes `n` with the characters of AlphabetBase62
+func EncodeBase62(n uint64) string {
+	if n == 0 {
+		return AlphabetBase62[:1]
+	}
+
+	b := []byte{}
+	for n > 0 {
+		b = append([]byte{AlphabetBase62[n%62]}, b...)
+		n /= 62
+	}
+
+	return string(b)
+}
+
+// Generates cuid slugs
+type CuidGenerator struct{}
+
+func (CuidGenerator) Generate(url string, attempt int) (string, error) {
+	return cuid.Slug(), nil
+}
+
+// Generates identifiers by base62 encoding the values of a counter
+type Base62CounterGenerator struct {
+	next func() (uint64, error)
+}
+
+// `next` increments the counter, returning its new value
+func NewBase62CounterGenerator(next func() (uint64, error)) *Base62CounterGenerator {
+	return &Base62CounterGenerator{
+		next: next,
+	}
+}
+
+func (g *Base62CounterGenerator) Generate(url string, attempt int) (string, error) {
+	n, err := g.next()
+	if err != nil {
+		return "", fmt.Errorf("couldn't increment the counter: %w", err)
+	}
+
+	return EncodeBase62(n), nil
+}
+
+// Generates identifiers of `length` random characters from `alphabet`
+type RandomGenerator struct {
+	alphabet string
+	length   int
+}
+
+func NewRandomGenerator(alphabet string, length int) *RandomGenerator {
+	return &RandomGenerator{
+		alphabet: alphabet,
+		length:   length,
+	}
+}
+
+func (g *RandomGenerator) Generate(url string, attempt int) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	b := make([]byte, g.length)
+	for i := range b {
+		ix, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+
+		b[i] = g.alphabet[ix.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// Generates identifiers made of an adjective and a noun, like `brave-otter`.
+// After a few collisions, a number is added to widen the pool
+type WordPairGenerator struct {
+	adjectives []string
+	nouns      []string
+}
+
+func NewWordPairGenerator() *WordPairGenerator {
+	return &WordPairGenerator{
+		adjectives: adjectives,
+		nouns:      nouns,
+	}
+}
+
+func (g *WordPairGenerator) Generate(url string, attempt int) (string, error) {
+	pick := func(words []string) (string, error) {
+		ix, err := rand.Int(rand.Reader, big.NewInt(int64(len(words))))
+		if err != nil {
+			return "", err
+		}
+
+		return words[ix.Int64()], nil
+	}
+
+	adjective, err := pick(g.adjectives)
+	if err != nil {
+		return "", err
+	}
+
+	noun, err := pick(g.nouns)
+	if err != nil {
+		return "", err
+	}
+
+	if attempt < 3 {
+		return fmt.Sprintf("%s-%s", adjective, noun), nil
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(100))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s-%d", adjective, noun, n.Int64()), nil
+}
+
+// Generates identifiers from the sha256 hash of the url, so the same url
+// gets the same identifier. Collisions with links to other urls are
+// resolved by salting the hash with the attempt
+type HashGenerator struct {
+	length int
+}
+
+func NewHashGenerator(length int) *HashGenerator {
+	return &HashGenerator{
+		length: length,
+	}
+}
+
+func (g *HashGenerator) Generate(url string, attempt int) (string, error) {
+	input := url
+	if attempt > 0 {
+		input = url + "#" + strconv.Itoa(attempt)
+	}
+
+	sum := sha256.Sum256([]byte(input))
+	encoded := new(big.Int).SetBytes(sum[:]).Text(62)
+	if len(encoded) > g.length {
+		encoded = encoded[:g.length]
+	}
+
+	return encoded, nil
+}
+
+func (g *HashGenerator) Deduplicates() bool {
+	return true
+}
+
+var adjectives = []string{
+	"able", "amber", "bold", "brave", "brief", "bright", "calm", "clever",
+	"cosmic", "crisp", "curious", "daring", "deep", "eager", "early", "easy",
+	"fair", "fancy", "fast", "fierce", "fresh", "gentle", "glad", "golden",
+	"grand", "happy", "hidden", "honest", "jolly", "keen", "kind", "lively",
+	"lucky", "mellow", "mighty", "modest", "neat", "noble", "odd", "patient",
+	"plain", "polite", "proud", "quick", "quiet", "rapid", "rare", "ready",
+	"rosy", "royal", "shiny", "silent", "simple", "sleek", "smart", "sunny",
+	"swift", "tidy", "vivid", "warm", "wild", "wise", "witty", "young",
+}
+
+var nouns = []string{
+	"acorn", "badger", "beacon", "breeze", "brook", "canyon", "cedar", "comet",
+	"coral", "crane", "delta", "dune", "eagle", "ember", "falcon", "fern",
+	"field", "finch", "forest", "fox", "glacier", "grove", "harbor", "hawk",
+	"heron", "island", "lagoon", "lark", "lynx", "maple", "meadow", "meteor",
+	"moose", "nebula", "oak", "orbit", "otter", "owl", "panda", "pebble",
+	"pine", "planet", "prairie", "quartz", "raven", "reef", "river", "robin",
+	"sparrow", "spruce", "stone", "summit", "thunder", "tiger", "tulip", "valley",
+	"walrus", "willow", "wolf", "wren", "yak", "zebra", "zenith", "lotus",
+}
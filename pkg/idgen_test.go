@@ -0,0 +1,78 @@
+package linkr
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestEncodeBase62(t *testing.T) {
+	tests := map[uint64]string{
+		0:  "0",
+		61: "z",
+		62: "10",
+		// 62^3 - 1
+		238327: "zzz",
+	}
+
+	for n, want := range tests {
+		if got := EncodeBase62(n); got != want {
+			t.Errorf("EncodeBase62(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestIdGenerators(t *testing.T) {
+	var counter uint64 = 61
+	generators := map[string]IdGenerator{
+		IdStrategyCuid: CuidGenerator{},
+		IdStrategyBase62: NewBase62CounterGenerator(func() (uint64, error) {
+			counter++
+			return counter, nil
+		}),
+		IdStrategyRandom: NewRandomGenerator(AlphabetUnambiguous, 7),
+		IdStrategyWords:  NewWordPairGenerator(),
+		IdStrategyHash:   NewHashGenerator(7),
+	}
+
+	for name, g := range generators {
+		for attempt := 0; attempt < 5; attempt++ {
+			id, err := g.Generate("https://examp.le", attempt)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+
+			if !identifierPattern.MatchString(id) {
+				t.Errorf("%s generated unusable identifier '%s'", name, id)
+			}
+		}
+	}
+
+	if id, _ := generators[IdStrategyBase62].Generate("", 0); id != "15" {
+		t.Errorf("expected counter to continue at 15, got %s", id)
+	}
+
+	if id, _ := generators[IdStrategyRandom].Generate("", 0); !regexp.MustCompile(`^[` + AlphabetUnambiguous + `]{7}$`).MatchString(id) {
+		t.Errorf("random identifier '%s' doesn't match alphabet and length", id)
+	}
+}
+
+func TestHashGeneratorDeduplicates(t *testing.T) {
+	g := NewHashGenerator(7)
+
+	first, _ := g.Generate("https://examp.le", 0)
+	second, _ := g.Generate("https://examp.le", 0)
+	other, _ := g.Generate("https://examp.le/other", 0)
+	salted, _ := g.Generate("https://examp.le", 1)
+
+	if first != second {
+		t.Errorf("same url got different identifiers %s and %s", first, second)
+	}
+
+	if first == other || first == salted {
+		t.Errorf("expected different identifiers, got %s, %s and %s", first, other, salted)
+	}
+
+	if !Deduplicates(g) || Deduplicates(CuidGenerator{}) {
+		t.Error("only the hash generator deduplicates")
+	}
+}
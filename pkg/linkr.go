@@ -13,9 +13,12 @@ const (
 )
 
 type Client struct {
-	Id         string `json:"client_id"`
-	SigningKey string `json:"client_signing_key"`
-	Scope      string
+	Id string `json:"client_id"`
+	// left out for clients signing with their own private key
+	SigningKey string `json:"client_signing_key,omitempty"`
+	// algorithm the requests are signed with
+	Algorithm string `json:"algorithm"`
+	Scope     string
 }
 
 const Version = 1
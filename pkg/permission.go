@@ -0,0 +1,112 @@
+package linkr
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// actions a client can be allowed to take
+const (
+	ActionLinksCreate      = "links:create"
+	ActionLinksRead        = "links:read"
+	ActionLinksDelete      = "links:delete"
+	ActionClientsManage    = "clients:manage"
+	ActionNamespacesManage = "namespaces:manage"
+	ActionStatsRead        = "stats:read"
+)
+
+// Retrieve the list of supported actions
+func SupportedActions() []string {
+	return []string{
+		ActionLinksCreate,
+		ActionLinksRead,
+		ActionLinksDelete,
+		ActionClientsManage,
+		ActionNamespacesManage,
+		ActionStatsRead,
+	}
+}
+
+// check if the input is an action
+func IsAction(maybeAction string) bool {
+	return includes(SupportedActions(), maybeAction)
+}
+
+// Retrieve the actions bundled by the role, and whether it's a role
+func RoleActions(role string) ([]string, bool) {
+	switch role {
+	case RoleAdmin:
+		return SupportedActions(), true
+	case RoleReadWrite:
+		return []string{ActionLinksCreate, ActionLinksRead, ActionLinksDelete, ActionStatsRead}, true
+	case RoleReadOnly:
+		return []string{ActionLinksRead, ActionStatsRead}, true
+	case RoleWriteOnly:
+		return []string{ActionLinksCreate, ActionLinksDelete}, true
+	}
+
+	return nil, false
+}
+
+// Parses the scope of a client, a comma separated list of actions and
+// roles (like `read-only,namespaces:manage`). Roles are replaced by the
+// actions they bundle, and the actions are returned sorted, without duplicates
+func ParseScope(scope string) ([]string, error) {
+	actions := []string{}
+	for _, entry := range strings.Split(scope, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if bundled, ok := RoleActions(entry); ok {
+			actions = append(actions, bundled...)
+			continue
+		}
+
+		if !IsAction(entry) {
+			return nil, fmt.Errorf("unknown action '%s'. only support %v, or the roles %v", entry, SupportedActions(), SupportedListOfRoles())
+		}
+
+		actions = append(actions, entry)
+	}
+
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("scope must have at least one action")
+	}
+
+	slices.Sort(actions)
+	return slices.Compact(actions), nil
+}
+
+// Writes the actions as a scope, the way it's stored
+func FormatScope(actions []string) string {
+	return strings.Join(actions, ",")
+}
+
+// Retrieve the role bundling exactly the actions of the scope, if any
+func RoleOfScope(actions []string) (string, bool) {
+	for _, role := range SupportedListOfRoles() {
+		bundled, _ := RoleActions(role)
+		bundled = slices.Clone(bundled)
+		slices.Sort(bundled)
+
+		if slices.Equal(bundled, actions) {
+			return role, true
+		}
+	}
+
+	return "", false
+}
+
+// check if the actions of a scope include all of the `required` ones
+func ScopeAllows(actions []string, required ...string) bool {
+	for _, action := range required {
+		if !includes(actions, action) {
+			return false
+		}
+	}
+
+	return true
+}
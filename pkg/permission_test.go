@@ -0,0 +1,60 @@
+package linkr
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		scope   string
+		actions []string
+		valid   bool
+	}{
+		{"read-only", []string{ActionLinksRead, ActionStatsRead}, true},
+		{"links:read, stats:read", []string{ActionLinksRead, ActionStatsRead}, true},
+		{"write-only,links:read", []string{ActionLinksCreate, ActionLinksDelete, ActionLinksRead}, true},
+		{"admin,links:read", []string{ActionClientsManage, ActionLinksCreate, ActionLinksDelete, ActionLinksRead, ActionNamespacesManage, ActionStatsRead}, true},
+		{"links:write", nil, false},
+		{"superuser", nil, false},
+		{" , ", nil, false},
+		{"", nil, false},
+	}
+
+	for _, tt := range tests {
+		actions, err := ParseScope(tt.scope)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("expected '%s' to be invalid", tt.scope)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expected '%s' to be valid: %s", tt.scope, err)
+			continue
+		}
+
+		if !slices.Equal(actions, tt.actions) {
+			t.Errorf("expected '%s' to have actions %v, got %v", tt.scope, tt.actions, actions)
+		}
+	}
+}
+
+func TestRoleOfScope(t *testing.T) {
+	for _, role := range SupportedListOfRoles() {
+		actions, _ := ParseScope(role)
+		if got, ok := RoleOfScope(actions); !ok || got != role {
+			t.Errorf("expected the actions of '%s' to be the role, got '%s'", role, got)
+		}
+	}
+
+	if _, ok := RoleOfScope([]string{ActionLinksRead}); ok {
+		t.Error("expected a scope that isn't bundled by a role to have no role")
+	}
+
+	actions, _ := ParseScope("read-only")
+	if !ScopeAllows(actions, ActionLinksRead, ActionStatsRead) || ScopeAllows(actions, ActionLinksCreate) {
+		t.Errorf("unexpected actions allowed by %v", actions)
+	}
+}
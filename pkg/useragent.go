@@ -0,0 +1,50 @@
+package linkr
+
+import "strings"
+
+// user agent families, as reported by the stats
+const (
+	AgentBot     = "Bot"
+	AgentChrome  = "Chrome"
+	AgentCurl    = "curl"
+	AgentEdge    = "Edge"
+	AgentFirefox = "Firefox"
+	AgentOpera   = "Opera"
+	AgentSafari  = "Safari"
+	AgentOther   = "Other"
+	AgentUnknown = "Unknown"
+)
+
+var botMarkers = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "preview"}
+
+// Classifies the user agent into a coarse family, like `Firefox` or `Bot`
+func UserAgentFamily(userAgent string) string {
+	if strings.TrimSpace(userAgent) == "" {
+		return AgentUnknown
+	}
+
+	lower := strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if strings.Contains(lower, marker) {
+			return AgentBot
+		}
+	}
+
+	// order matters, since most browsers claim to be others too
+	switch {
+	case strings.HasPrefix(lower, "curl/"):
+		return AgentCurl
+	case strings.Contains(userAgent, "Edg/"), strings.Contains(userAgent, "Edge/"):
+		return AgentEdge
+	case strings.Contains(userAgent, "OPR/"), strings.Contains(userAgent, "Opera"):
+		return AgentOpera
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		return AgentFirefox
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		return AgentChrome
+	case strings.Contains(userAgent, "Safari/"):
+		return AgentSafari
+	}
+
+	return AgentOther
+}
@@ -0,0 +1,23 @@
+package linkr
+
+import "testing"
+
+func TestUserAgentFamily(t *testing.T) {
+	tests := map[string]string{
+		"":           AgentUnknown,
+		"curl/8.4.0": AgentCurl,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                      AgentBot,
+		"Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0":                                                        AgentFirefox,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36":               AgentChrome,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0": AgentEdge,
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15":         AgentSafari,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/109.0.0.0": AgentOpera,
+		"Go-http-client/1.1": AgentOther,
+	}
+
+	for ua, want := range tests {
+		if got := UserAgentFamily(ua); got != want {
+			t.Errorf("UserAgentFamily(%q) = %s, want %s", ua, got, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package linkr
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -45,21 +46,30 @@ func ConvertStringDurationToSeconds(strDuration string) (time.Duration, error) {
 	switch durlen {
 	case "s":
 		{
-			return time.Duration(durValue) * time.Second, nil
+			return scaleDuration(durValue, time.Second)
 		}
 	case "h":
 		{
-			return time.Duration(durValue) * time.Hour, nil
+			return scaleDuration(durValue, time.Hour)
 		}
 	case "m":
 		{
-			return time.Duration(durValue) * time.Minute, nil
+			return scaleDuration(durValue, time.Minute)
 		}
 	case "d":
 		{
-			return time.Duration(durValue) * time.Hour * 24, nil
+			return scaleDuration(durValue, 24*time.Hour)
 		}
 	}
 
 	return 0, fmt.Errorf("unsupported duration lenght '%s'", durlen)
 }
+
+// `value` times `unit`, unless it's too long to be represented
+func scaleDuration(value int, unit time.Duration) (time.Duration, error) {
+	if int64(value) > math.MaxInt64/int64(unit) {
+		return 0, fmt.Errorf("duration is too long")
+	}
+
+	return time.Duration(value) * unit, nil
+}
@@ -18,3 +18,9 @@ func TestCovertStringDurationToSeconds(t *testing.T) {
 		t.Errorf("failed to convert string '%s' to proper seconds. got %v, want %v", input, got, want)
 	}
 }
+
+func TestConvertStringDurationOverflow(t *testing.T) {
+	if _, err := ConvertStringDurationToSeconds("9999999999999d"); err == nil {
+		t.Error("expected a duration too long to be represented to be rejected")
+	}
+}
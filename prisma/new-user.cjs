@@ -1,4 +1,4 @@
-const { randomBytes, createHash } = require("crypto");
+const { randomBytes } = require("crypto");
 const sqlite = require("@libsql/client");
 const cuid = require("@paralleldrive/cuid2");
 
@@ -60,8 +60,8 @@ async function seed(sqlite, userroles) {
 			const date = `${d.getDate() + 1}`.padStart(2, "0");
 			id = `api_${id}-${d.getFullYear()}${month}${date}`;
 
-			// create
-			const key = createHash("sha256").digest("base64");
+			// random signing key, as generated by the api
+			const key = randomBytes(32).toString("base64");
 
 			created.push({
 				id,
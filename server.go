@@ -2,98 +2,333 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"iam-kevin/linkr/migrations"
 	linkr "iam-kevin/linkr/pkg"
 	"iam-kevin/linkr/service"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"golang.org/x/exp/slog"
+	"github.com/redis/go-redis/v9"
 
-	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
 func main() {
+	logger, err := service.NewLoggerFromEnv(os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	slog.SetDefault(logger)
+
 	r := chi.NewMux()
 
+	r.Use(middleware.RequestID)
+	r.Use(service.MiddlewareRequestLogger)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Heartbeat("/v1/health"))
 
+	// counts, and times, the requests by route. the health checks are left out
+	metrics := service.NewMetrics()
+	r.Use(metrics.Middleware)
+
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		AllowCredentials: false,
 	}))
 
-	db, err := sqlx.Open("libsql", os.Getenv("DATABASE_URL"))
+	db, err := openDatabase(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal(fmt.Errorf("unable to create connection to db: %s", err.Error()))
 		return
 	}
 
-	// NOTE: might want to move this aside
-	db.MustExec(`INSERT OR IGNORE INTO "Namespace" VALUES (NULL, ?, NULL)`, linkr.ReservedGlobalChar)
+	// `linkr migrate up | down | status` manages the schema, and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), db, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// brings the schema up to date, which creates the default namespace
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatalf("couldn't load the migrations: %s", err)
+		return
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		log.Fatalf("couldn't migrate the database: %s", err)
+		return
+	}
+
+	for _, m := range applied {
+		slog.Info(fmt.Sprintf("applied migration %04d_%s", m.Version, m.Name))
+	}
 
 	// pull default namespace
-	dfNamespace := new(service.LinkrNamespace)
-	err = db.Get(dfNamespace, `SELECT * FROM "Namespace" where unique_tag = ?`, linkr.ReservedGlobalChar)
+	// the redirects look the namespaces and links up from a cache,
+	// which the changes made through the api are dropped from
+	cacheConfig, err := service.NewLookupCacheConfigFromEnv()
+	if err != nil {
+		log.Fatalf("couldn't configure the cache: %s", err)
+		return
+	}
+
+	cache := service.NewLookupCache(cacheConfig)
+	stores := cache.Wrap(metrics.Wrap(service.NewSQLStores(db)))
+	metrics.ObserveCache(cache)
+
+	dfNamespace, err := stores.Namespaces.GetByTag(context.Background(), linkr.ReservedGlobalChar)
 	if err != nil {
 		log.Fatalf("couldn't initialize the default namespace: %s", err)
 		return
 	}
 
+	// clients created with a role are given the actions of the role
+	if migrated, err := service.MigrateClientScopes(context.Background(), db); err != nil {
+		log.Fatalf("couldn't migrate the scopes of the clients: %s", err)
+		return
+	} else if migrated > 0 {
+		slog.Info(fmt.Sprintf("migrated the scopes of %d clients", migrated))
+	}
+
+	// clients created before keys were generated all shared the same key
+	if rotated, err := service.RotateLegacySigningKeys(context.Background(), stores.Clients); err != nil {
+		log.Fatalf("couldn't rotate the legacy signing keys: %s", err)
+		return
+	} else if len(rotated) > 0 {
+		slog.Warn("clients signing with the legacy shared key were given a new key. rotate their key through the api to retrieve it", "client_ids", rotated)
+	}
+
 	shortenerBaseUrl := os.Getenv("LINKR_BASE_URL")
 	if shortenerBaseUrl == "" {
 		log.Fatal(fmt.Errorf("LINKR_BASE_URL not defined"))
 		return
 	}
 
-	commander := service.NewCommandCenter(db)
+	policy, err := service.NewURLPolicyFromEnv(shortenerBaseUrl)
+	if err != nil {
+		log.Fatalf("couldn't create the url policy: %s", err)
+		return
+	}
+
+	// salt of the hashed visitor addresses. must stay the same
+	// across restarts for unique visitors to be counted right
+	clickSalt := []byte(os.Getenv("LINKR_CLICK_SALT"))
+	if len(clickSalt) == 0 {
+		slog.Warn("LINKR_CLICK_SALT not defined. using a random salt until the next restart")
+		clickSalt = make([]byte, 32)
+		rand.Read(clickSalt)
+	}
+
+	// proxies whose X-Forwarded-For is believed
+	proxies, err := service.NewTrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatalf("invalid LINKR_TRUSTED_PROXIES: %s", err)
+		return
+	}
+
+	clicks := service.NewClickRecorder(stores.Stats, service.DefaultClickBufferSize, service.DefaultClickBatchSize, service.DefaultClickFlushInterval, clickSalt, proxies)
+	metrics.ObserveClicks(clicks)
+
+	// keeps the stats up to date with the recorded clicks
+	rollupCtx, stopRollup := context.WithCancel(context.Background())
+	go service.NewClickRollup(stores.Stats, service.DefaultRollupInterval, service.DefaultRollupBatchSize).Run(rollupCtx)
+
+	// how long the previous key of a client keeps working after it's rotated
+	keyGrace := service.DefaultKeyRotationGrace
+	if value := os.Getenv("LINKR_KEY_ROTATION_GRACE"); value != "" {
+		keyGrace, err = linkr.ConvertStringDurationToSeconds(value)
+		if err != nil {
+			log.Fatalf("invalid LINKR_KEY_ROTATION_GRACE: %s", err)
+			return
+		}
+	}
+
+	// how old the digest of a signed request can be
+	digestMaxAge := service.DefaultDigestMaxAge
+	if value := os.Getenv("LINKR_DIGEST_MAX_AGE"); value != "" {
+		digestMaxAge, err = linkr.ConvertStringDurationToSeconds(value)
+		if err != nil {
+			log.Fatalf("invalid LINKR_DIGEST_MAX_AGE: %s", err)
+			return
+		}
+	}
+
+	// secret the access tokens are signed with. must stay the same
+	// across restarts, and instances, for the tokens to keep working
+	tokenSecret := []byte(os.Getenv("LINKR_TOKEN_SECRET"))
+	if len(tokenSecret) == 0 {
+		slog.Warn("LINKR_TOKEN_SECRET not defined. using a random secret until the next restart")
+		tokenSecret = make([]byte, 32)
+		rand.Read(tokenSecret)
+	}
+
+	tokenTTL := service.DefaultAccessTokenTTL
+	if value := os.Getenv("LINKR_TOKEN_TTL"); value != "" {
+		tokenTTL, err = linkr.ConvertStringDurationToSeconds(value)
+		if err != nil {
+			log.Fatalf("invalid LINKR_TOKEN_TTL: %s", err)
+			return
+		}
+	}
+
+	// notes when the clients were last used
+	usage := service.NewClientUsageTracker(stores.Clients, service.DefaultClientUsageFlushInterval)
+	usageCtx, stopUsage := context.WithCancel(context.Background())
+	go usage.Run(usageCtx)
+
+	// nonces and limits are shared between instances when redis is available
+	var nonceStore service.NonceStore = service.NewMemoryNonceStore()
+	var rateLimitStore service.RateLimitStore = service.NewMemoryRateLimitStore()
+	if redisUrl := os.Getenv("LINKR_REDIS_URL"); redisUrl != "" {
+		opts, err := redis.ParseURL(redisUrl)
+		if err != nil {
+			log.Fatalf("invalid LINKR_REDIS_URL: %s", err)
+			return
+		}
+
+		redisClient := redis.NewClient(opts)
+		nonceStore = service.NewRedisNonceStore(redisClient, "linkr:nonce:")
+		rateLimitStore = service.NewRedisRateLimitStore(redisClient, "linkr:ratelimit:")
+	}
+
+	commander := service.NewCommandCenter(stores.Clients, nonceStore, digestMaxAge, service.NewTokenIssuer(tokenSecret, tokenTTL), usage, metrics)
+
+	rateLimits, err := service.NewRateLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("couldn't configure the rate limits: %s", err)
+		return
+	}
+
+	limiter := service.NewRateLimiter(rateLimitStore, rateLimits, proxies)
 
 	r.Route("/v1/api", func(r chi.Router) {
-		apiHandler := service.NewApiHandler(db, linkr.NewShortner(shortenerBaseUrl), dfNamespace)
+		apiHandler := service.NewApiHandler(stores, linkr.NewShortner(shortenerBaseUrl), dfNamespace, policy, keyGrace)
 
-		// TODO: add ratelimiting in this route group
+		// limit by address before authenticating, so keys can't be guessed at will
+		r.Use(limiter.MiddlewareByIP)
 
 		// set role within this group
 		// admin can do anything. (NOTE: might want to think about this)
 		r.Use(commander.MiddlewareGated)
+		r.Use(limiter.MiddlewareByClient)
+
+		// exchanges a signed request for an access token
+		r.Post("/token", commander.HandleIssueToken)
 
 		r.Group(func(r chi.Router) {
 			// in this group, set permission for those who
 			// can create links
-			r.Use(commander.MiddlewareWithRoles(linkr.RoleReadWrite, linkr.RoleWriteOnly, linkr.RoleAdmin))
+			r.Use(commander.MiddlewareRequire(linkr.ActionLinksCreate))
 
 			// creates a link
 			r.Post("/create", apiHandler.HandleCreateLink)
+
+			// manages existing links
+			r.Patch("/links/{namespace}/{id}", apiHandler.HandleUpdateLink)
 		})
 
+		r.With(commander.MiddlewareRequire(linkr.ActionLinksDelete)).Delete("/links/{namespace}/{id}", apiHandler.HandleDeleteLink)
+
 		r.Group(func(r chi.Router) {
-			// creates a client
-			r.Use(commander.MiddlewareWithRoles(linkr.RoleAdmin))
+			// in this group, set permission for those who
+			// can read links
+			r.Use(commander.MiddlewareRequire(linkr.ActionLinksRead))
+
+			r.Get("/links", apiHandler.HandleListLinks)
+			r.Get("/links/{namespace}/{id}", apiHandler.HandleGetLink)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(commander.MiddlewareRequire(linkr.ActionStatsRead))
+
+			r.Get("/links/{namespace}/{id}/stats", apiHandler.HandleLinkStats)
+			r.Get("/namespaces/{namespace}/stats", apiHandler.HandleNamespaceStats)
+		})
+
+		r.With(commander.MiddlewareRequire(linkr.ActionNamespacesManage)).Post("/namespaces", apiHandler.HandleCreateNamespace)
+
+		// namespaces, and their members, are managed by the admins of the namespace
+		r.Get("/namespaces", apiHandler.HandleListNamespaces)
+		r.Get("/namespaces/{namespace}", apiHandler.HandleGetNamespace)
+		r.Patch("/namespaces/{namespace}", apiHandler.HandleUpdateNamespace)
+		r.Delete("/namespaces/{namespace}", apiHandler.HandleDeleteNamespace)
+		r.Get("/namespaces/{namespace}/members", apiHandler.HandleListNamespaceMembers)
+		r.Put("/namespaces/{namespace}/members/{client}", apiHandler.HandlePutNamespaceMember)
+		r.Delete("/namespaces/{namespace}/members/{client}", apiHandler.HandleDeleteNamespaceMember)
+
+		r.Group(func(r chi.Router) {
+			// manages clients
+			r.Use(commander.MiddlewareRequire(linkr.ActionClientsManage))
 			r.Post("/client/create", apiHandler.HandleCreateClient)
+			r.Get("/client", apiHandler.HandleListClients)
+			r.Get("/client/{id}", apiHandler.HandleGetClient)
+			r.Patch("/client/{id}", apiHandler.HandleUpdateClient)
+			r.Delete("/client/{id}", apiHandler.HandleDeleteClient)
+			r.Post("/client/{id}/disable", apiHandler.HandleDisableClient)
+			r.Post("/client/{id}/enable", apiHandler.HandleEnableClient)
+			r.Post("/client/{id}/rotate-key", apiHandler.HandleRotateClientKey)
 		})
 	})
 
 	r.Route("/", func(r chi.Router) {
 		r.Use(middleware.StripSlashes)
-		linkHandler := service.NewLinkHandler(db, dfNamespace)
+		linkHandler := service.NewLinkHandler(stores, dfNamespace, clicks, metrics, policy)
 
 		r.Get("/{namespace}/{id}", linkHandler.HandleRedirectShortenedLinkWithNamespace)
 		r.Get("/{id}", linkHandler.HandleRedirectShortenedLink)
 	})
 
+	// the metrics are kept off the public port, on their own listener.
+	// it's bound to loopback unless told otherwise, so only what runs
+	// on the host can scrape them
+	metricsAddr := os.Getenv("LINKR_METRICS_ADDR")
+	if metricsPort := os.Getenv("LINKR_METRICS_PORT"); metricsAddr == "" && metricsPort != "" {
+		metricsAddr = fmt.Sprintf(":%s", metricsPort)
+	}
+
+	if metricsAddr == "" {
+		metricsAddr = service.DefaultMetricsAddr
+	}
+
+	var metricsServer *http.Server
+	if metricsAddr != "off" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:              metricsAddr,
+			ReadHeaderTimeout: 3 * time.Second,
+			Handler:           mux,
+		}
+
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	port := os.Getenv("APP_PORT")
 	if port == "" {
 		port = "8080"
@@ -112,5 +347,42 @@ func main() {
 		},
 	}
 
-	log.Fatal(server.ListenAndServe())
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait to be stopped, then let the in-flight
+	// requests and buffered clicks finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error(fmt.Sprintf("couldn't shut the server down: %s", err))
+	}
+
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+
+	if err := clicks.Close(ctx); err != nil {
+		slog.Error(err.Error())
+	}
+
+	stopRollup()
+
+	stopUsage()
+	if _, err := usage.Flush(ctx); err != nil {
+		slog.Error(fmt.Sprintf("couldn't write the last use of the clients: %s", err))
+	}
+
+	stats := cache.Stats()
+	slog.Info("lookup cache",
+		"namespace_hits", stats.Namespaces.Hits, "namespace_misses", stats.Namespaces.Misses,
+		"link_hits", stats.Links.Hits, "link_misses", stats.Links.Misses)
 }
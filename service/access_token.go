@@ -0,0 +1,99 @@
+// Short-lived access tokens, an alternative to signing every request
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/lucsky/cuid"
+)
+
+const (
+	// how long access tokens last when the ttl isn't configured
+	DefaultAccessTokenTTL = 15 * time.Minute
+
+	accessTokenIssuer   = "linkr"
+	accessTokenAudience = "linkr-api"
+)
+
+var (
+	ErrAccessTokenExpired = errors.New("access token expired")
+	ErrAccessTokenInvalid = errors.New("invalid access token")
+)
+
+// Claims of an access token
+//
+//	{"iss": "linkr", "aud": "linkr-api", "sub": "<client id>", "exp": <unix time>, "iat": <unix time>, "jti": "<id>", "scope": "links:read,stats:read"}
+type AccessToken struct {
+	jwt.Payload
+	// scope of the client when the token was issued
+	Scope string `json:"scope"`
+}
+
+// Issues and verifies the access tokens, signed with a secret only linkr knows
+type TokenIssuer struct {
+	m   *jwt.HMACSHA
+	ttl time.Duration
+	now func() time.Time
+}
+
+func NewTokenIssuer(secret []byte, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		m:   jwt.NewHS256(secret),
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// Issues a token for the client, returning it along with when it expires
+func (t *TokenIssuer) Issue(client *LinkrClient) (string, time.Time, error) {
+	now := t.now()
+	expiresAt := now.Add(t.ttl)
+
+	token := AccessToken{
+		Payload: jwt.Payload{
+			Issuer:         accessTokenIssuer,
+			Audience:       jwt.Audience{accessTokenAudience},
+			Subject:        client.Id,
+			ExpirationTime: jwt.NumericDate(expiresAt),
+			IssuedAt:       jwt.NumericDate(now),
+			JWTID:          cuid.New(),
+		},
+		Scope: client.Scope,
+	}
+
+	signed, err := jwt.Sign(token, t.m)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return string(signed), expiresAt, nil
+}
+
+// Verifies the token was issued by linkr and hasn't expired
+func (t *TokenIssuer) Verify(raw string) (*AccessToken, error) {
+	token := new(AccessToken)
+	_, err := jwt.Verify(
+		[]byte(raw),
+		t.m,
+		token,
+		jwt.ValidateHeader,
+		jwt.ValidatePayload(
+			&token.Payload,
+			jwt.IssuerValidator(accessTokenIssuer),
+			jwt.AudienceValidator(jwt.Audience{accessTokenAudience}),
+			jwt.ExpirationTimeValidator(t.now()),
+		),
+	)
+
+	if errors.Is(err, jwt.ErrExpValidation) {
+		return nil, ErrAccessTokenExpired
+	}
+
+	if err != nil || token.Subject == "" {
+		return nil, ErrAccessTokenInvalid
+	}
+
+	return token, nil
+}
@@ -0,0 +1,202 @@
+// Errors returned by the api, and how they're rendered
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeValidationFailed = "validation_failed"
+	ErrCodeUnauthenticated  = "unauthenticated"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeConflict         = "conflict"
+	ErrCodeGone             = "gone"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodePayloadTooLarge  = "payload_too_large"
+	ErrCodeBadGateway       = "bad_gateway"
+	ErrCodeInternal         = "internal_error"
+)
+
+// Error of a single field of the request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error responded by the api, rendered as
+//
+//	{"error": {"code": "...", "message": "...", "fields": [...]}}
+type ApiError struct {
+	// http status of the response
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	// additional information about the error
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+type ResponseError struct {
+	Error *ApiError `json:"error"`
+}
+
+func ErrBadRequest(message string) *ApiError {
+	return &ApiError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: message}
+}
+
+// request is well formed, but the values of `fields` can't be used
+func ErrValidation(fields ...FieldError) *ApiError {
+	return &ApiError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidationFailed, Message: "request has invalid fields", Fields: fields}
+}
+
+func ErrUnauthenticated(message string) *ApiError {
+	return &ApiError{Status: http.StatusUnauthorized, Code: ErrCodeUnauthenticated, Message: message}
+}
+
+func ErrForbidden(message string) *ApiError {
+	return &ApiError{Status: http.StatusForbidden, Code: ErrCodeForbidden, Message: message}
+}
+
+func ErrNotFound(message string) *ApiError {
+	return &ApiError{Status: http.StatusNotFound, Code: ErrCodeNotFound, Message: message}
+}
+
+func ErrConflict(message string) *ApiError {
+	return &ApiError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: message}
+}
+
+func ErrGone(message string) *ApiError {
+	return &ApiError{Status: http.StatusGone, Code: ErrCodeGone, Message: message}
+}
+
+func ErrPayloadTooLarge(message string) *ApiError {
+	return &ApiError{Status: http.StatusRequestEntityTooLarge, Code: ErrCodePayloadTooLarge, Message: message}
+}
+
+func ErrRateLimited(message string) *ApiError {
+	return &ApiError{Status: http.StatusTooManyRequests, Code: ErrCodeRateLimited, Message: message}
+}
+
+func ErrBadGateway(message string) *ApiError {
+	return &ApiError{Status: http.StatusBadGateway, Code: ErrCodeBadGateway, Message: message}
+}
+
+func ErrInternal() *ApiError {
+	return &ApiError{Status: http.StatusInternalServerError, Code: ErrCodeInternal, Message: "something went wrong. please try again later"}
+}
+
+// Translates errors of database calls into api errors.
+// `resource` names what was looked up, as in "link not found"
+func dbError(err error, resource string) error {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrRecordNotFound) {
+		return ErrNotFound(fmt.Sprintf("%s not found", resource))
+	}
+
+	if errors.Is(err, ErrRecordExists) || isUniqueViolation(err) {
+		return ErrConflict(fmt.Sprintf("%s already exists", resource))
+	}
+
+	return err
+}
+
+// responds with `v` encoded as json
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Responds with the error envelope. Errors that aren't `ApiError`s are
+// logged with the logger of the request and hidden behind an internal error
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := new(ApiError)
+	if !errors.As(err, &apiErr) {
+		RequestLogger(r).Error(err.Error())
+		apiErr = ErrInternal()
+	}
+
+	writeJSON(w, apiErr.Status, ResponseError{Error: apiErr})
+}
+
+// implemented by requests with checks beyond the `validate` tags
+type validatable interface {
+	Validate() []FieldError
+}
+
+// Decodes the json body of the request into `v`, then validates it.
+//
+// Fields tagged `validate:"required"` must not be empty. When `v`
+// implements `Validate`, its field errors are included
+func decodeRequest(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return ErrBadRequest("request body is required")
+	}
+
+	typeErr := new(json.UnmarshalTypeError)
+	if errors.As(err, &typeErr) {
+		return ErrValidation(FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type.String()),
+		})
+	}
+
+	if err != nil {
+		return ErrBadRequest(fmt.Sprintf("malformed request body: %s", err.Error()))
+	}
+
+	fields := validateRequired(v)
+	if vv, ok := v.(validatable); ok {
+		fields = append(fields, vv.Validate()...)
+	}
+
+	if len(fields) > 0 {
+		return ErrValidation(fields...)
+	}
+
+	return nil
+}
+
+// checks the fields of the struct `v` tagged `validate:"required"` aren't empty
+func validateRequired(v interface{}) []FieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := []FieldError{}
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if !includes(strings.Split(field.Tag.Get("validate"), ","), "required") {
+			continue
+		}
+
+		if rv.Field(i).IsZero() {
+			fields = append(fields, FieldError{Field: jsonFieldName(field), Message: "is required"})
+		}
+	}
+
+	return fields
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
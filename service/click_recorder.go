@@ -0,0 +1,234 @@
+// Recording of the clicks on shortened links
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// clicks waiting to be written before new ones are dropped
+	DefaultClickBufferSize = 4096
+	// most clicks written in a single transaction
+	DefaultClickBatchSize = 256
+	// longest a click waits in the buffer before it's written
+	DefaultClickFlushInterval = time.Second
+
+	// longest referrer or user agent stored
+	maxClickFieldLength = 512
+)
+
+// A visit of a shortened link
+type ClickEvent struct {
+	LinkId      int
+	NamespaceId int64
+	ClickedAt   time.Time
+	Referrer    string
+	UserAgent   string
+	// hash of the network the visitor came from.
+	// see `ClickRecorder.HashIP`
+	IpHash string
+}
+
+type ClickRecorderStats struct {
+	// clicks accepted into the buffer
+	Recorded uint64
+	// clicks written to the database
+	Written uint64
+	// clicks dropped because the buffer was full
+	Dropped uint64
+	// clicks lost to failed writes
+	Failed uint64
+	// clicks waiting in the buffer
+	QueueDepth int
+}
+
+// Writes clicks to the database in batches, away from the request,
+// so recording a click doesn't slow the redirect down
+type ClickRecorder struct {
+	stats         StatsStore
+	events        chan ClickEvent
+	batchSize     int
+	flushInterval time.Duration
+	// mixed into the hashed ip addresses
+	salt []byte
+	// proxies the address of the visitor is taken from. optional
+	proxies *TrustedProxies
+
+	// guards sending to `events` while it's being closed
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	recorded atomic.Uint64
+	written  atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// Creates the recorder and starts writing clicks in the background.
+// `Close` must be called to write the clicks left in the buffer
+func NewClickRecorder(stats StatsStore, bufferSize int, batchSize int, flushInterval time.Duration, salt []byte, proxies *TrustedProxies) *ClickRecorder {
+	c := newClickRecorder(stats, bufferSize, batchSize, flushInterval, salt, proxies)
+	go c.run()
+	return c
+}
+
+func newClickRecorder(stats StatsStore, bufferSize int, batchSize int, flushInterval time.Duration, salt []byte, proxies *TrustedProxies) *ClickRecorder {
+	return &ClickRecorder{
+		stats:         stats,
+		events:        make(chan ClickEvent, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		salt:          salt,
+		proxies:       proxies,
+		done:          make(chan struct{}),
+	}
+}
+
+// Queues the click to be written, without blocking.
+// Returns false when the click was dropped
+func (c *ClickRecorder) Record(ev ClickEvent) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		c.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case c.events <- ev:
+		c.recorded.Add(1)
+		return true
+	default:
+		c.dropped.Add(1)
+		return false
+	}
+}
+
+// Builds the click of a visitor of the link from their request
+func (c *ClickRecorder) EventFromRequest(r *http.Request, link *Link, at time.Time) ClickEvent {
+	return ClickEvent{
+		LinkId:      link.Id,
+		NamespaceId: int64(link.NamespaceId),
+		ClickedAt:   at.UTC(),
+		Referrer:    truncate(r.Referer(), maxClickFieldLength),
+		UserAgent:   truncate(r.UserAgent(), maxClickFieldLength),
+		IpHash:      c.HashIP(c.proxies.clientIP(r)),
+	}
+}
+
+// Hashes the network of the ip address (/24 for IPv4, /48 for IPv6)
+// so visitors can be told apart without storing their address
+func (c *ClickRecorder) HashIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		parsed = v4.Mask(net.CIDRMask(24, 32))
+	} else {
+		parsed = parsed.Mask(net.CIDRMask(48, 128))
+	}
+
+	h := sha256.New()
+	h.Write(c.salt)
+	h.Write(parsed)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (c *ClickRecorder) Stats() ClickRecorderStats {
+	return ClickRecorderStats{
+		Recorded:   c.recorded.Load(),
+		Written:    c.written.Load(),
+		Dropped:    c.dropped.Load(),
+		Failed:     c.failed.Load(),
+		QueueDepth: len(c.events),
+	}
+}
+
+// Stops accepting clicks and waits for the buffered ones to be written
+func (c *ClickRecorder) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.events)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("clicks left unwritten: %w", ctx.Err())
+	}
+}
+
+func (c *ClickRecorder) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]ClickEvent, 0, c.batchSize)
+	for {
+		select {
+		case ev, ok := <-c.events:
+			if !ok {
+				c.flush(batch)
+				return
+			}
+
+			batch = append(batch, ev)
+			if len(batch) >= c.batchSize {
+				c.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			c.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// writes the clicks in a single transaction
+func (c *ClickRecorder) flush(batch []ClickEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := c.stats.AddClicks(context.Background(), batch)
+	if err != nil {
+		c.failed.Add(uint64(len(batch)))
+		slog.Error(fmt.Sprintf("couldn't write %d clicks: %s", len(batch), err.Error()))
+		return
+	}
+
+	c.written.Add(uint64(len(batch)))
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+
+	return s[:length]
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClickRecorderWritesRedirects(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_at) VALUES ('abc', 'https://dest.example', ?, NULL)`, dfNs.Id)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_at) VALUES ('old', 'https://dest.example', ?, ?)`, dfNs.Id, time.Now().Add(-time.Hour))
+
+	clicks := NewClickRecorder(NewSQLStatsStore(db), 16, 2, time.Hour, []byte("salt"), nil)
+	router := newTestLinkRouter(NewLinkHandler(NewSQLStores(db), dfNs, clicks, nil, nil))
+
+	for _, path := range []string{"/abc", "/abc", "/abc", "/old", "/unknown"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.7:5123"
+		req.Header.Set("Referer", "https://news.example/post")
+		req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/126.0")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if err := clicks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := clicks.Stats()
+	if stats.Recorded != 3 || stats.Written != 3 || stats.Dropped != 0 || stats.QueueDepth != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	rows := []struct {
+		LinkId    int    `db:"link_id"`
+		Referrer  string `db:"referrer"`
+		UserAgent string `db:"user_agent"`
+		IpHash    string `db:"ip_hash"`
+	}{}
+
+	if err := db.Select(&rows, `SELECT link_id, referrer, user_agent, ip_hash FROM "Click"`); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected only the redirects of the active link to be written, got %d", len(rows))
+	}
+
+	if rows[0].Referrer != "https://news.example/post" || rows[0].UserAgent != "Mozilla/5.0 Firefox/126.0" || rows[0].IpHash != clicks.HashIP("203.0.113.99") {
+		t.Errorf("unexpected click %+v", rows[0])
+	}
+}
+
+func TestClickRecorderDropsWhenFull(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	// not started, so nothing drains the buffer
+	clicks := newClickRecorder(NewSQLStatsStore(db), 2, 10, time.Hour, nil, nil)
+	for i := 0; i < 5; i++ {
+		clicks.Record(ClickEvent{LinkId: 1, ClickedAt: time.Now()})
+	}
+
+	stats := clicks.Stats()
+	if stats.Recorded != 2 || stats.Dropped != 3 || stats.QueueDepth != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestClickRecorderHashIP(t *testing.T) {
+	clicks := newClickRecorder(nil, 0, 0, time.Hour, []byte("salt"), nil)
+	other := newClickRecorder(nil, 0, 0, time.Hour, []byte("pepper"), nil)
+
+	if clicks.HashIP("198.51.100.1") != clicks.HashIP("198.51.100.200") {
+		t.Error("addresses of the same /24 should share the hash")
+	}
+
+	if clicks.HashIP("198.51.100.1") == clicks.HashIP("198.51.101.1") {
+		t.Error("addresses of different /24s should not share the hash")
+	}
+
+	if clicks.HashIP("2001:db8:1:2::1") != clicks.HashIP("2001:db8:1:ffff::1") {
+		t.Error("addresses of the same /48 should share the hash")
+	}
+
+	if clicks.HashIP("198.51.100.1") == other.HashIP("198.51.100.1") {
+		t.Error("hash should depend on the salt")
+	}
+
+	if clicks.HashIP("not an ip") != "" {
+		t.Error("invalid addresses should not be hashed")
+	}
+}
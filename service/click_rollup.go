@@ -0,0 +1,169 @@
+// Aggregation of the recorded clicks into the tables the stats are served from
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+const (
+	// how often new clicks are rolled up
+	DefaultRollupInterval = time.Minute
+	// most clicks rolled up in a single transaction
+	DefaultRollupBatchSize = 5000
+	// how far behind now the rollup stays, so the clicks still being
+	// written, by any instance, are committed by the time it gets to them
+	DefaultRollupLag = time.Minute
+
+	// name of the watermark of the click rollup, in "RollupState"
+	clickRollupName = "clicks"
+	// referrer of visits without one
+	directReferrer = "(direct)"
+)
+
+// Rolls the clicks up into hourly counts, and daily visitors, referrers
+// and user agent families, so stats don't scan the raw clicks.
+//
+// Progress is tracked with the time, and id, of the last click rolled up,
+// so each click is counted once. Ids aren't committed in order when several
+// instances write clicks, so only the clicks older than the lag are rolled up
+type ClickRollup struct {
+	stats     StatsStore
+	interval  time.Duration
+	batchSize int
+
+	// see `DefaultRollupLag`
+	lag time.Duration
+	now func() time.Time
+}
+
+func NewClickRollup(stats StatsStore, interval time.Duration, batchSize int) *ClickRollup {
+	return &ClickRollup{
+		stats:     stats,
+		interval:  interval,
+		batchSize: batchSize,
+		lag:       DefaultRollupLag,
+		now:       time.Now,
+	}
+}
+
+// Rolls clicks up every interval, until `ctx` is done
+func (c *ClickRollup) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.RollupPending(ctx); err != nil {
+			slog.Error(fmt.Sprintf("couldn't roll clicks up: %s", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Rolls up every click older than the lag that wasn't, returning how many were
+func (c *ClickRollup) RollupPending(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := c.stats.RollupClicks(ctx, c.now().Add(-c.lag).UTC(), c.batchSize)
+		total += n
+		if err != nil || n < c.batchSize {
+			return total, err
+		}
+	}
+}
+
+type rollupClick struct {
+	Id          int            `db:"id"`
+	LinkId      int            `db:"link_id"`
+	NamespaceId int64          `db:"namespace_id"`
+	ClickedAt   time.Time      `db:"clicked_at"`
+	Referrer    sql.NullString `db:"referrer"`
+	UserAgent   sql.NullString `db:"user_agent"`
+	IpHash      sql.NullString `db:"ip_hash"`
+}
+
+// key of a count in the rollup tables
+type rollupKey struct {
+	linkId      int
+	namespaceId int64
+	bucket      time.Time
+	// referrer host, agent family or ip hash
+	value string
+}
+
+var errRollupRaced = errors.New("clicks were rolled up by someone else")
+
+// counts of the clicks, as they're added to the rollup tables
+type clickCounts struct {
+	hourly    map[rollupKey]int64
+	referrers map[rollupKey]int64
+	agents    map[rollupKey]int64
+	visitors  map[rollupKey]bool
+}
+
+func newClickCounts() *clickCounts {
+	return &clickCounts{
+		hourly:    map[rollupKey]int64{},
+		referrers: map[rollupKey]int64{},
+		agents:    map[rollupKey]int64{},
+		visitors:  map[rollupKey]bool{},
+	}
+}
+
+func (c *clickCounts) add(click rollupClick) {
+	at := click.ClickedAt.UTC()
+	hour := rollupKey{linkId: click.LinkId, namespaceId: click.NamespaceId, bucket: at.Truncate(time.Hour)}
+	day := rollupKey{linkId: click.LinkId, namespaceId: click.NamespaceId, bucket: truncateDay(at)}
+
+	c.hourly[hour]++
+
+	day.value = referrerHost(click.Referrer.String)
+	c.referrers[day]++
+
+	day.value = linkr.UserAgentFamily(click.UserAgent.String)
+	c.agents[day]++
+
+	if click.IpHash.Valid && click.IpHash.String != "" {
+		day.value = click.IpHash.String
+		c.visitors[day] = true
+	}
+}
+
+// host of the referrer, `(direct)` for visits without one
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return directReferrer
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// start of the ISO week (monday) of `t`
+func truncateWeek(t time.Time) time.Time {
+	day := truncateDay(t)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClickRollupCountsClicksOnce(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('abc', 'https://dest.example', ?)`, dfNs.Id)
+
+	at := time.Date(2024, 5, 6, 10, 15, 0, 0, time.UTC)
+	insert := `INSERT INTO "Click" (link_id, namespace_id, clicked_at, referrer, user_agent, ip_hash) VALUES (1, ?, ?, ?, ?, ?)`
+	db.MustExec(insert, dfNs.Id, at, "https://www.news.example/post", "Mozilla/5.0 Firefox/126.0", "aaaa")
+	db.MustExec(insert, dfNs.Id, at.Add(10*time.Minute), nil, "curl/8.4.0", "aaaa")
+	db.MustExec(insert, dfNs.Id, at.Add(2*time.Hour), nil, nil, "bbbb")
+
+	// batches smaller than the clicks, to roll up in several transactions
+	rollup := NewClickRollup(NewSQLStatsStore(db), time.Hour, 2)
+	if n, err := rollup.RollupPending(context.Background()); err != nil || n != 3 {
+		t.Fatalf("expected 3 clicks rolled up, got %d (%v)", n, err)
+	}
+
+	// clicks arriving later are added to the existing counts
+	db.MustExec(insert, dfNs.Id, at.Add(2*time.Hour+20*time.Minute), nil, nil, "cccc")
+	if n, err := rollup.RollupPending(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected only the new click rolled up, got %d (%v)", n, err)
+	}
+
+	if n, err := rollup.RollupPending(context.Background()); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to roll up, got %d (%v)", n, err)
+	}
+
+	hourly := []struct {
+		Hour   string `db:"hour"`
+		Clicks int    `db:"clicks"`
+	}{}
+	if err := db.Select(&hourly, `SELECT datetime(bucket_start) AS hour, clicks FROM "ClickHourly" ORDER BY hour`); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hourly) != 2 || hourly[0].Hour != "2024-05-06 10:00:00" || hourly[0].Clicks != 2 || hourly[1].Clicks != 2 {
+		t.Errorf("unexpected hourly counts %+v", hourly)
+	}
+
+	var visitors int
+	db.Get(&visitors, `SELECT COUNT(*) FROM "ClickVisitorDaily"`)
+	if visitors != 3 {
+		t.Errorf("expected 3 visitors, got %d", visitors)
+	}
+
+	var direct, news int
+	db.Get(&direct, `SELECT clicks FROM "ClickReferrerDaily" WHERE referrer_host = '(direct)'`)
+	db.Get(&news, `SELECT clicks FROM "ClickReferrerDaily" WHERE referrer_host = 'news.example'`)
+	if direct != 3 || news != 1 {
+		t.Errorf("unexpected referrer counts: direct %d, news.example %d", direct, news)
+	}
+
+	var curl int
+	db.Get(&curl, `SELECT clicks FROM "ClickAgentDaily" WHERE agent_family = 'curl'`)
+	if curl != 1 {
+		t.Errorf("expected 1 click from curl, got %d", curl)
+	}
+}
+
+func TestClickRollupLagsBehindNow(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('abc', 'https://dest.example', ?)`, dfNs.Id)
+
+	now := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	rollup := NewClickRollup(NewSQLStatsStore(db), time.Hour, 10)
+	rollup.now = func() time.Time { return now }
+
+	insert := `INSERT INTO "Click" (link_id, namespace_id, clicked_at) VALUES (1, ?, ?)`
+	db.MustExec(insert, dfNs.Id, now.Add(-10*time.Second))
+	// written after the click above, by a slower instance
+	db.MustExec(insert, dfNs.Id, now.Add(-2*DefaultRollupLag))
+
+	if n, err := rollup.RollupPending(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected only the click older than the lag rolled up, got %d (%v)", n, err)
+	}
+
+	now = now.Add(DefaultRollupLag)
+	if n, err := rollup.RollupPending(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected the click with the lower id rolled up once the lag passed, got %d (%v)", n, err)
+	}
+
+	clicks := 0
+	db.Get(&clicks, `SELECT SUM(clicks) FROM "ClickHourly"`)
+	if clicks != 2 {
+		t.Errorf("expected both clicks counted, got %d", clicks)
+	}
+}
+
+func TestTruncateWeek(t *testing.T) {
+	// a sunday
+	at := time.Date(2024, 5, 12, 23, 59, 0, 0, time.UTC)
+	if week := truncateWeek(at); !week.Equal(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the week to start on monday the 6th, got %s", week)
+	}
+}
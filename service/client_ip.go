@@ -0,0 +1,106 @@
+// Address of the visitor behind the proxies in front of linkr
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Proxies in front of linkr, whose `X-Forwarded-For` is believed.
+// The header of any other peer can be made up, so it's ignored
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// Parses the comma separated addresses, or networks, of the proxies.
+// e.g. `10.0.0.0/8, 192.168.1.10`
+func ParseTrustedProxies(list string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+	for _, entry := range splitList(list) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address '%s'", entry)
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+
+			proxies.networks = append(proxies.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network '%s': %w", entry, err)
+		}
+
+		proxies.networks = append(proxies.networks, network)
+	}
+
+	return proxies, nil
+}
+
+// Reads the proxies from `LINKR_TRUSTED_PROXIES`.
+// None are trusted when it's not defined
+func NewTrustedProxiesFromEnv() (*TrustedProxies, error) {
+	return ParseTrustedProxies(os.Getenv("LINKR_TRUSTED_PROXIES"))
+}
+
+func (p *TrustedProxies) trusts(addr string) bool {
+	if p == nil {
+		return false
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Address of the visitor. When the peer is a trusted proxy, `X-Forwarded-For`
+// is read from the right, each proxy appending the address it got the request
+// from, and the first address that isn't a trusted proxy is the visitor's
+func (p *TrustedProxies) clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !p.trusts(peer) {
+		return peer
+	}
+
+	hops := []string{}
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !p.trusts(hops[i]) {
+			return hops[i]
+		}
+
+		peer = hops[i]
+	}
+
+	// every hop is a proxy
+	return peer
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		proxies   *TrustedProxies
+		peer      string
+		forwarded string
+		want      string
+	}{
+		{"no proxies", nil, "203.0.113.7:4000", "1.2.3.4", "203.0.113.7"},
+		{"untrusted peer", proxies, "203.0.113.7:4000", "1.2.3.4", "203.0.113.7"},
+		{"trusted peer", proxies, "192.168.1.10:4000", "1.2.3.4", "1.2.3.4"},
+		{"chain of proxies", proxies, "10.0.0.1:4000", "1.2.3.4, 10.0.0.2", "1.2.3.4"},
+		{"made up by the visitor", proxies, "10.0.0.1:4000", "6.6.6.6, 1.2.3.4", "1.2.3.4"},
+		{"only proxies", proxies, "10.0.0.1:4000", "10.0.0.3", "10.0.0.3"},
+		{"trusted peer without the header", proxies, "[fd00::1]:4000", "", "fd00::1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		req.RemoteAddr = tt.peer
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		if got := tt.proxies.clientIP(req); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, list := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := ParseTrustedProxies(list); err == nil {
+			t.Errorf("expected '%s' to be rejected", list)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Signing keys of the api clients
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+const (
+	// shared secret, kept by both the client and linkr
+	SigningAlgHS256 = "HS256"
+	// public keys, linkr can't sign requests with them
+	SigningAlgEd25519 = "Ed25519"
+	SigningAlgES256   = "ES256"
+)
+
+func SupportedSigningAlgorithms() []string {
+	return []string{SigningAlgHS256, SigningAlgEd25519, SigningAlgES256}
+}
+
+// algorithms the client signs with a key linkr only knows the public half of
+func isAsymmetricAlgorithm(algorithm string) bool {
+	return algorithm == SigningAlgEd25519 || algorithm == SigningAlgES256
+}
+
+// Key requests can be signed with. Shared secrets are base64 encoded,
+// while public keys are the base64 encoded PKIX (DER) key
+type SigningKey struct {
+	Algorithm string `db:"algorithm"`
+	Key       string `db:"signing_key"`
+}
+
+// Builds the jwt algorithm verifying the signatures of the key
+func (k SigningKey) verifier() (jwt.Algorithm, error) {
+	raw, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.Algorithm {
+	case SigningAlgHS256, "":
+		return jwt.NewHS256(raw), nil
+	case SigningAlgEd25519, SigningAlgES256:
+		pub, err := parsePublicKey(k.Algorithm, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if k.Algorithm == SigningAlgEd25519 {
+			return jwt.NewEd25519(jwt.Ed25519PublicKey(pub.(ed25519.PublicKey))), nil
+		}
+
+		return jwt.NewES256(jwt.ECDSAPublicKey(pub.(*ecdsa.PublicKey))), nil
+	}
+
+	return nil, fmt.Errorf("unknown signing algorithm '%s'", k.Algorithm)
+}
+
+// parses the PKIX (DER) public key, checking it's usable with the algorithm
+func parsePublicKey(algorithm string, der []byte) (interface{}, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.New("must be a PKIX public key")
+	}
+
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if algorithm == SigningAlgEd25519 {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if algorithm == SigningAlgES256 && key.Curve == elliptic.P256() {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("must be a public key usable with %s", algorithm)
+}
+
+// Normalizes the public key a client registers, given either in PEM
+// or as base64 encoded DER, into the stored base64 encoded DER
+func normalizePublicKey(algorithm string, raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+
+	var der []byte
+	if block, _ := pem.Decode([]byte(raw)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return "", errors.New("must be a PEM or base64 encoded public key")
+		}
+		der = decoded
+	}
+
+	if _, err := parsePublicKey(algorithm, der); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+const (
+	// random bytes in a signing key
+	SigningKeyLength = 32
+	// how long a rotated key stays valid when the grace isn't configured
+	DefaultKeyRotationGrace = 24 * time.Hour
+)
+
+// Generates a random signing key, encoded in base64
+func generateSigningKey() (string, error) {
+	key := make([]byte, SigningKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Key every client was created with before keys were generated: the
+// sha256 of nothing. Anyone knowing the id of such a client can sign as it
+var legacySigningKey = func() string {
+	sum := sha256.Sum256(nil)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}()
+
+// the key is the shared legacy key, which never verifies a request
+func (k SigningKey) isLegacy() bool {
+	return (k.Algorithm == SigningAlgHS256 || k.Algorithm == "") && k.Key == legacySigningKey
+}
+
+// Keys the requests of `client` can be signed with: its current key,
+// then the rotated keys that are still in their grace window.
+// The legacy shared key is left out
+func clientSigningKeys(ctx context.Context, clients ClientStore, client *LinkrClient, now time.Time) ([]SigningKey, error) {
+	previous, err := clients.PreviousKeys(ctx, client.Id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []SigningKey{}
+	for _, key := range append([]SigningKey{{Algorithm: client.Algorithm, Key: client.SigningKey}}, previous...) {
+		if !key.isLegacy() {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Gives the clients still holding the legacy shared key a random key, which
+// no one knows until it's rotated through the api. The legacy key isn't
+// kept through a grace window.
+// Returns the ids of the clients whose key was replaced
+func RotateLegacySigningKeys(ctx context.Context, clients ClientStore) ([]string, error) {
+	all, err := clients.List(ctx, ClientFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list the clients: %w", err)
+	}
+
+	rotated := []string{}
+	for _, client := range all {
+		if !(SigningKey{Algorithm: client.Algorithm, Key: client.SigningKey}).isLegacy() {
+			continue
+		}
+
+		key, err := generateSigningKey()
+		if err != nil {
+			return rotated, fmt.Errorf("couldn't generate signing key: %w", err)
+		}
+
+		if err := clients.RotateKey(ctx, client.Id, key, time.Now().UTC(), nil); err != nil {
+			return rotated, fmt.Errorf("couldn't rotate the key of client %s: %w", client.Id, err)
+		}
+
+		rotated = append(rotated, client.Id)
+	}
+
+	return rotated, nil
+}
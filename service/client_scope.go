@@ -0,0 +1,101 @@
+// Keeps the scopes of the clients stored as the actions they allow
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Rewrites the scopes stored as roles (`read-only`), or holding roles,
+// as the actions the roles bundle. Scopes that can't be parsed are left
+// as they are, and won't allow any action.
+// Returns the number of clients updated
+func MigrateClientScopes(ctx context.Context, db *sqlx.DB) (int, error) {
+	tx, err := sqlDB{db}.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	clients := []struct {
+		Id    string `db:"id"`
+		Scope string `db:"scope"`
+	}{}
+	if err := tx.SelectContext(ctx, &clients, `SELECT id, scope FROM "ApiClient"`); err != nil {
+		return 0, fmt.Errorf("couldn't retrieve the clients: %w", err)
+	}
+
+	updated := 0
+	for _, client := range clients {
+		actions, err := linkr.ParseScope(client.Scope)
+		if err != nil {
+			slog.Warn("client has an invalid scope", "client_id", client.Id, "error", err.Error())
+			continue
+		}
+
+		scope := linkr.FormatScope(actions)
+		if scope == client.Scope {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE "ApiClient" SET scope = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, scope, client.Id)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't update the scope of client %s: %w", client.Id, err)
+		}
+
+		updated++
+	}
+
+	return updated, tx.Commit()
+}
+
+// Checks the client of the request can grant the actions to another
+// client, which it can only do for the actions its own scope allows.
+// Keeps clients that can manage clients from minting more powerful ones
+func authorizeGrant(r *http.Request, actions []string) error {
+	client, err := requestClient(r)
+	if err != nil {
+		return err
+	}
+
+	granted, err := client.Actions()
+	if err != nil {
+		return ErrForbidden("operation not allowed")
+	}
+
+	missing := []string{}
+	for _, action := range actions {
+		if !linkr.ScopeAllows(granted, action) {
+			missing = append(missing, action)
+		}
+	}
+
+	if len(missing) > 0 {
+		return ErrForbidden(fmt.Sprintf("can't grant actions the client isn't allowed to take: %v", missing))
+	}
+
+	return nil
+}
+
+// Checks the client of the request can manage `target`, which it can only
+// do when it's allowed every action of the target. Keeps clients that can
+// manage clients from taking over, or locking out, more powerful ones.
+// Targets whose scope can't be parsed are only managed by admins
+func authorizeManage(r *http.Request, target *LinkrClient) error {
+	actions, err := target.Actions()
+	if err != nil {
+		actions = linkr.SupportedActions()
+	}
+
+	if err := authorizeGrant(r, actions); err != nil {
+		return ErrForbidden("can't manage clients allowed to take actions the client isn't")
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMigrateClientScopes(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	for id, scope := range map[string]string{
+		"api_admin":  "admin",
+		"api_reader": "read-only",
+		"api_mixed":  "write-only, stats:read",
+		"api_done":   "links:read",
+		"api_broken": "superuser",
+	} {
+		db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES (?, ?, ?, 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, id, id, scope)
+	}
+
+	migrated, err := MigrateClientScopes(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated != 3 {
+		t.Errorf("expected 3 clients to be migrated, got %d", migrated)
+	}
+
+	for id, scope := range map[string]string{
+		"api_admin":  "clients:manage,links:create,links:delete,links:read,namespaces:manage,stats:read",
+		"api_reader": "links:read,stats:read",
+		"api_mixed":  "links:create,links:delete,stats:read",
+		"api_done":   "links:read",
+		"api_broken": "superuser",
+	} {
+		stored := ""
+		db.Get(&stored, `SELECT scope FROM "ApiClient" WHERE id = ?`, id)
+		if stored != scope {
+			t.Errorf("%s: expected scope '%s', got '%s'", id, scope, stored)
+		}
+	}
+
+	if migrated, _ := MigrateClientScopes(context.Background(), db); migrated != 0 {
+		t.Errorf("expected migrating again to do nothing, got %d", migrated)
+	}
+}
+
+func TestMiddlewareRequire(t *testing.T) {
+	cc := &CommandCenter{}
+
+	h := cc.MiddlewareRequire(linkr.ActionLinksRead, linkr.ActionStatsRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range []struct {
+		scope  string
+		status int
+	}{
+		{"read-only", http.StatusOK},
+		{"links:read,stats:read", http.StatusOK},
+		{"admin", http.StatusOK},
+		{"links:read", http.StatusForbidden},
+		{"write-only", http.StatusForbidden},
+		{"superuser", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/links", nil)
+		req = req.WithContext(context.WithValue(req.Context(), CtxLinkrClient, &LinkrClient{Id: "api_1", Scope: tt.scope}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.scope, tt.status, rec.Code)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected requiring an unknown action to panic")
+		}
+	}()
+	cc.MiddlewareRequire("links:write")
+}
+
+func TestCreateClientWithScope(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+
+	r := chi.NewRouter()
+	r.Use(asTestAdmin)
+	r.Post("/client/create", a.HandleCreateClient)
+
+	for _, tt := range []struct {
+		body   string
+		status int
+		scope  string
+	}{
+		{`{"username": "reader", "role": "read-only"}`, http.StatusCreated, "links:read,stats:read"},
+		{`{"username": "stats", "scope": ["stats:read", "namespaces:manage"]}`, http.StatusCreated, "namespaces:manage,stats:read"},
+		{`{"username": "bundled", "role": "admin", "scope": ["write-only"]}`, http.StatusCreated, "links:create,links:delete"},
+		{`{"username": "unknown", "scope": ["links:write"]}`, http.StatusUnprocessableEntity, ""},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/client/create", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.body, tt.status, rec.Code, rec.Body.String())
+			continue
+		}
+
+		if tt.status != http.StatusCreated {
+			continue
+		}
+
+		client := linkr.Client{}
+		decodeDetails(t, rec, &client)
+
+		stored := ""
+		db.Get(&stored, `SELECT scope FROM "ApiClient" WHERE id = ?`, client.Id)
+		if client.Scope != tt.scope || stored != tt.scope {
+			t.Errorf("%s: expected scope '%s', got '%s' (stored '%s')", tt.body, tt.scope, client.Scope, stored)
+		}
+	}
+}
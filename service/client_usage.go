@@ -0,0 +1,89 @@
+// Tracking of when the api clients were last used
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// longest the last use of a client waits before it's written
+const DefaultClientUsageFlushInterval = 30 * time.Second
+
+// Keeps when the clients last authenticated, and writes it to the
+// database in the background, so requests don't wait on it.
+// A client authenticating many times between flushes is written once
+type ClientUsageTracker struct {
+	clients  ClientStore
+	interval time.Duration
+
+	mu sync.Mutex
+	// last use of the clients, waiting to be written
+	pending map[string]time.Time
+}
+
+func NewClientUsageTracker(clients ClientStore, interval time.Duration) *ClientUsageTracker {
+	return &ClientUsageTracker{
+		clients:  clients,
+		interval: interval,
+		pending:  map[string]time.Time{},
+	}
+}
+
+// Notes the client was used at `at`, without blocking
+func (u *ClientUsageTracker) Touch(clientId string, at time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if last, ok := u.pending[clientId]; !ok || at.After(last) {
+		u.pending[clientId] = at.UTC()
+	}
+}
+
+// Writes the pending uses every interval, until `ctx` is done.
+// `Flush` writes the ones left after it returns
+func (u *ClientUsageTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := u.Flush(ctx); err != nil {
+				slog.Error(fmt.Sprintf("couldn't write the last use of the clients: %s", err.Error()))
+			}
+		}
+	}
+}
+
+// Writes the pending uses, returning the number of clients written.
+// Uses that couldn't be written are kept for the next flush
+func (u *ClientUsageTracker) Flush(ctx context.Context) (int, error) {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = map[string]time.Time{}
+	u.mu.Unlock()
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	err := u.write(ctx, pending)
+	if err != nil {
+		for clientId, at := range pending {
+			u.Touch(clientId, at)
+		}
+
+		return 0, err
+	}
+
+	return len(pending), nil
+}
+
+func (u *ClientUsageTracker) write(ctx context.Context, pending map[string]time.Time) error {
+	return u.clients.TouchLastUsed(ctx, pending)
+}
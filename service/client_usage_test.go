@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientUsageTracker(t *testing.T) {
+	db, _ := newTestDB(t)
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_1', 'bot', 'admin', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`)
+
+	usage := NewClientUsageTracker(NewSQLClientStore(db), time.Hour)
+
+	lastUsed := func() time.Time {
+		at := time.Time{}
+		if err := db.Get(&at, `SELECT last_used_at FROM "ApiClient" WHERE id = 'api_1'`); err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	usage.Touch("api_1", at)
+	usage.Touch("api_1", at.Add(-time.Minute))
+	usage.Touch("api_1", at.Add(time.Minute))
+
+	if written, err := usage.Flush(context.Background()); err != nil || written != 1 {
+		t.Fatalf("expected a single write for the client, got %d: %v", written, err)
+	}
+
+	if got := lastUsed(); !got.Equal(at.Add(time.Minute)) {
+		t.Errorf("expected the latest use to be written, got %s", got)
+	}
+
+	if written, _ := usage.Flush(context.Background()); written != 0 {
+		t.Errorf("expected nothing left to write, got %d", written)
+	}
+
+	// uses arriving out of order don't move the last use back
+	usage.Touch("api_1", at)
+	usage.Flush(context.Background())
+	if got := lastUsed(); !got.Equal(at.Add(time.Minute)) {
+		t.Errorf("expected the last use to stay, got %s", got)
+	}
+}
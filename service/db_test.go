@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"iam-kevin/linkr/migrations"
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// creates an in-memory database migrated to the linkr schema,
+// which seeds the global namespace
+func newTestDB(t *testing.T) (*sqlx.DB, *LinkrNamespace) {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each connection to :memory: is a different database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dfNs := new(LinkrNamespace)
+	if err := db.Get(dfNs, `SELECT * FROM "Namespace" WHERE unique_tag = '-'`); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, dfNs
+}
+
+// api handler with a url policy resolving every host to a public address,
+// and the default key rotation grace
+func newTestApiHandler(db *sqlx.DB, dfNs *LinkrNamespace) *ApiHandler {
+	return NewApiHandler(NewSQLStores(db), linkr.NewShortner("https://examp.le"), dfNs, &URLPolicy{
+		AllowedSchemes: []string{"http", "https"},
+		BlockPrivate:   true,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		},
+	}, DefaultKeyRotationGrace)
+}
+
+// client allowed to do anything, for handlers tested without `MiddlewareGated`
+var testAdmin = &LinkrClient{Id: "api_admin", Scope: linkr.RoleAdmin}
+
+// attaches `client` to the request, as `MiddlewareGated` does
+func withTestClient(r *http.Request, client *LinkrClient) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), CtxLinkrClient, client))
+}
+
+// middleware attaching `testAdmin` to the requests
+func asTestAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withTestClient(r, testAdmin))
+	})
+}
@@ -2,89 +2,159 @@
 package service
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	linkr "iam-kevin/linkr/pkg"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/lucsky/cuid"
 )
 
 type ApiHandler struct {
-	// db connection
-	db *sqlx.DB
+	// links, namespaces, clients and their stats
+	stores *Stores
 
 	// short url host
 	shortner *linkr.Shortner
 
 	dfNs *LinkrNamespace
+
+	// decides where links can redirect to
+	policy *URLPolicy
+
+	// how long rotated signing keys keep working
+	keyGrace time.Duration
 }
 
-func NewApiHandler(db *sqlx.DB, shortner *linkr.Shortner, defaultNs *LinkrNamespace) *ApiHandler {
+func NewApiHandler(stores *Stores, shortner *linkr.Shortner, defaultNs *LinkrNamespace, policy *URLPolicy, keyGrace time.Duration) *ApiHandler {
 	return &ApiHandler{
-		db:       db,
+		stores:   stores,
 		shortner: shortner,
 		dfNs:     defaultNs,
+		policy:   policy,
+		keyGrace: keyGrace,
 	}
 }
 
+// policy destinations of links in `ns` are checked against,
+// restricted by the host lists of the client making the request
+func (a *ApiHandler) destinationPolicy(r *http.Request, ns *LinkrNamespace) (*URLPolicy, error) {
+	client, err := requestClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.policy.ForNamespace(ns).ForClient(client), nil
+}
+
 const (
 	// set time format
 	TimeFormatYYYYMMDD = "20060102"
+
+	// role of the clients created without a role or scope
+	DefaultClientRole = linkr.RoleWriteOnly
 )
 
 // helps generate a client who can access and create resources
 func generateClient(scope string) (*linkr.Client, error) {
-	scp := linkr.RoleReadOnly
+	scp := DefaultClientRole
 	if scope != "" {
 		scp = scope
 	}
 
-	if !linkr.IsRole(scope) {
-		return nil, fmt.Errorf("unknown role type '%s'", scope)
+	if _, err := linkr.ParseScope(scp); err != nil {
+		return nil, fmt.Errorf("invalid scope '%s': %w", scp, err)
 	}
 
 	// generate id
 	cid := cuid.New()
 
+	key, err := generateSigningKey()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate signing key: %w", err)
+	}
+
 	return &linkr.Client{
 		Id:         fmt.Sprintf("api_%s-%s", cid, time.Now().Format(TimeFormatYYYYMMDD)),
 		Scope:      scp,
-		SigningKey: base64.URLEncoding.EncodeToString(sha256.New().Sum(nil)),
+		SigningKey: key,
 	}, nil
 }
 
 // Handler for creating a resource user
 func (a *ApiHandler) HandleCreateClient(w http.ResponseWriter, r *http.Request) {
 	body := new(RequestClientCreate)
-	json.NewDecoder(r.Body).Decode(body)
+	if err := decodeRequest(r, body); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	// default role
-	roleType := linkr.RoleWriteOnly
-	if linkr.IsRole(body.Role) {
+	// unknown roles were already rejected
+	roleType := DefaultClientRole
+	if body.Role != "" {
 		roleType = body.Role
 	}
 
-	insertClientStr := `INSERT INTO "ApiClient" (id, username, description, scope, signing_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	// the actions listed take over the ones of the role
+	scope := roleType
+	if len(body.Scope) > 0 {
+		scope = strings.Join(body.Scope, ",")
+	}
+
+	// stored as the actions, rather than the role bundling them
+	actions, err := linkr.ParseScope(scope)
+	if err != nil {
+		writeError(w, r, ErrValidation(FieldError{Field: "scope", Message: err.Error()}))
+		return
+	}
+
+	if err := authorizeGrant(r, actions); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	c, err := generateClient(roleType)
+	c, err := generateClient(linkr.FormatScope(actions))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, ErrBadRequest(err.Error()))
 		return
 	}
 
-	a.db.MustExec(insertClientStr, c.Id, body.Username, nil, c.Scope, c.SigningKey)
+	// clients with a key pair register the public half, and keep
+	// the private one to themselves
+	c.Algorithm = SigningAlgHS256
+	storedKey := c.SigningKey
+	if isAsymmetricAlgorithm(body.Algorithm) {
+		c.Algorithm = body.Algorithm
+		c.SigningKey = ""
+		storedKey = body.PublicKey
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	json.NewEncoder(w).Encode(ResponseClientCreate{
+	err = a.stores.Clients.Create(r.Context(), &LinkrClient{
+		Id:          c.Id,
+		Username:    body.Username,
+		Description: sql.NullString{String: body.Description, Valid: body.Description != ""},
+		Scope:       c.Scope,
+		SigningKey:  storedKey,
+		Algorithm:   c.Algorithm,
+		AllowHosts:  storedHostList(body.AllowHosts),
+		DenyHosts:   storedHostList(body.DenyHosts),
+	})
+	if errors.Is(err, ErrRecordExists) {
+		writeError(w, r, ErrConflict(fmt.Sprintf("username '%s' is taken", body.Username)))
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't save client: %w", err))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ResponseClientCreate{
 		Message: "client created",
 		Details: c,
 	})
@@ -93,49 +163,61 @@ func (a *ApiHandler) HandleCreateClient(w http.ResponseWriter, r *http.Request)
 // Handler for creating shortned links
 func (a *ApiHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
 	input := new(RequestLinkCreate)
-	json.NewDecoder(r.Body).Decode(input)
+	if err := decodeRequest(r, input); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	var namespaceId int64
+	ns := a.dfNs
 
 	if input.Namespace != "" {
-		// check if namespace is valid
-		if input.Namespace == linkr.ReservedGlobalChar {
-			http.Error(w, "not supported", http.StatusBadRequest)
+		// namespaces are created explicitly, before links are added to them
+		var err error
+		ns, err = a.stores.Namespaces.GetByTag(r.Context(), input.Namespace)
+		if err != nil {
+			writeError(w, r, dbError(err, "namespace"))
 			return
 		}
 
-		// TODO: other checks
-		// - \w
-		// - not long (4 chars max)
-
-		// check namespace exissts
-		ns := new(LinkrNamespace)
-		err := a.db.Get(ns, `SELECT * FROM "Namespace" where unique_tag = ?`, input.Namespace)
-		if err == nil {
-			// assumes exists
-			namespaceId = ns.Id
-		} else {
-			res := a.db.MustExec(`INSERT OR IGNORE INTO "Namespace" (unique_tag) VALUES (?)`, input.Namespace)
-			ix, _ := res.LastInsertId()
-			namespaceId = ix
+		if ns.ArchivedAt.Valid {
+			writeError(w, r, ErrGone(fmt.Sprintf("namespace '%s' is archived", ns.Tag)))
+			return
 		}
+	}
 
-	} else {
-		namespaceId = a.dfNs.Id
+	if err := a.authorizeNamespace(r, ns, linkr.ActionLinksCreate); err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	slog.Info("namespace id", "namespaceid", namespaceId)
+	namespaceId := ns.Id
+
+	policy, err := a.destinationPolicy(r, ns)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	destination, err := policy.Check(r.Context(), "redirect_url", input.Url)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	RequestLogger(r).Debug("namespace of the link", "namespace_id", namespaceId)
 
 	var expiresIn int64 = 0
-	now := time.Now()
+	now := time.Now().UTC()
 	var expiresAt *time.Time = nil
 
+	forwardMode := ForwardModeNone
+	if input.ForwardMode != "" {
+		forwardMode = input.ForwardMode
+	}
+
 	if input.ExpiresIn != "" {
-		ex, err := linkr.ConvertStringDurationToSeconds(input.ExpiresIn)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("couldn't construction duration from `expires_in` input: %s", err.Error()), http.StatusBadRequest)
-			return
-		}
+		// already validated
+		ex, _ := linkr.ConvertStringDurationToSeconds(input.ExpiresIn)
 
 		expiresIn = int64(ex.Seconds())
 		v := now.Add(ex)
@@ -143,16 +225,75 @@ func (a *ApiHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// create url
-	urlshort := cuid.Slug()
-	serializedHeaders := extractHeadersToForward(r.Header.Clone())
+	urlshort := ""
+
+	link := Link{
+		OriginalUrl: destination,
+		NamespaceId: int(namespaceId),
+		ExpiresIn:   sql.NullInt32{Int32: int32(expiresIn), Valid: true},
+		CreatedAt:   now,
+		ForwardMode: sql.NullString{String: forwardMode, Valid: true},
+	}
+
+	if expiresAt != nil {
+		link.ExpiresAt = sql.NullTime{Time: *expiresAt, Valid: true}
+	}
+
+	if headers := extractHeadersToForward(r.Header.Clone()); headers != nil {
+		link.SerializedHeaders = sql.NullString{String: *headers, Valid: true}
+	}
+
+	if err := checkForwardMode(forwardMode, link.SerializedHeaders.String); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if input.Identifier != "" {
+		urlshort = input.Identifier
+
+		// save the link, unless the identifier is taken
+		link.Tag = urlshort
+		err := a.stores.Links.Create(r.Context(), &link)
+		if errors.Is(err, ErrRecordExists) {
+			writeError(w, r, a.identifierTakenError(r.Context(), namespaceId, input))
+			return
+		}
+
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't save link: %w", err))
+			return
+		}
+	} else {
+		gen, err := a.idGenerator(r.Context(), ns.IdStrategy.String, namespaceId)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't pick the identifier generator of namespace %d: %w", namespaceId, err))
+			return
+		}
+
+		// save the link
+		identifier, existing, err := a.saveWithGeneratedIdentifier(r.Context(), gen, namespaceId, destination, func(identifier string) error {
+			link.Tag = identifier
+			return a.stores.Links.Create(r.Context(), &link)
+		})
+
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't save link: %w", err))
+			return
+		}
 
-	// save the link
-	a.db.MustExec(`
-		INSERT INTO "Link" 
-			(id, identifier, destination_url, namespace_id, expires_in, expires_at, headers) 
-			VALUES
-			(NULL, ?, ?, ?, ?, ?, ?)
-	`, urlshort, input.Url, namespaceId, expiresIn, &expiresAt, serializedHeaders)
+		if existing != nil {
+			// handing out the existing link would drop the settings of the request
+			if !existing.hasSameSettings(&link) {
+				writeError(w, r, ErrConflict(fmt.Sprintf("link '%s' already points to this url, with a different expiry, forward mode or headers", existing.Tag)))
+				return
+			}
+
+			a.respondExistingLink(w, input.Namespace, existing)
+			return
+		}
+
+		urlshort = identifier
+	}
 
 	shortenedLink := ""
 	if input.Namespace == "" {
@@ -161,9 +302,6 @@ func (a *ApiHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
 		shortenedLink = a.shortner.CreateWithNamespace(input.Namespace, urlshort)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-
 	var expiresInSecond *int64
 	var expiresAtString string
 	if expiresAt != nil {
@@ -174,7 +312,7 @@ func (a *ApiHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
 		expiresInSecond = &expiresIn
 	}
 
-	json.NewEncoder(w).Encode(ResponseClientCreate{
+	writeJSON(w, http.StatusCreated, ResponseClientCreate{
 		Message: "link created",
 		Details: ResponseLinkCreate{
 			ShortenedUrl:     shortenedLink,
@@ -183,16 +321,78 @@ func (a *ApiHandler) HandleCreateLink(w http.ResponseWriter, r *http.Request) {
 			ExpiresInSeconds: expiresInSecond,
 			CreatedAt:        now.Format(time.RFC3339),
 			ExpiresAt:        expiresAtString,
+			ForwardMode:      forwardMode,
 		},
 	})
 }
 
+// responds with a link that was created before with the same url
+func (a *ApiHandler) respondExistingLink(w http.ResponseWriter, namespace string, link *Link) {
+	details := ResponseLinkCreate{
+		ShortenedUrl: a.shortner.Create(link.Tag),
+		Identifier:   link.Tag,
+		Namespace:    namespace,
+		CreatedAt:    link.CreatedAt.Format(time.RFC3339),
+		ForwardMode:  ForwardModeNone,
+	}
+
+	if namespace != "" {
+		details.ShortenedUrl = a.shortner.CreateWithNamespace(namespace, link.Tag)
+	}
+
+	if link.ExpiresAt.Valid {
+		details.ExpiresAt = link.ExpiresAt.Time.Format(time.RFC3339)
+	}
+
+	if link.ExpiresIn.Valid && link.ExpiresIn.Int32 > 0 {
+		expiresIn := int64(link.ExpiresIn.Int32)
+		details.ExpiresInSeconds = &expiresIn
+	}
+
+	if link.ForwardMode.Valid && link.ForwardMode.String != "" {
+		details.ForwardMode = link.ForwardMode.String
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "link already exists",
+		Details: details,
+	})
+}
+
+// error for a custom identifier that's taken, along with
+// available alternatives when they are requested
+func (a *ApiHandler) identifierTakenError(ctx context.Context, namespaceId int64, input *RequestLinkCreate) *ApiError {
+	details := ResponseIdentifierTaken{
+		Identifier:  input.Identifier,
+		Suggestions: []string{},
+	}
+
+	if input.SuggestAlternatives {
+		candidates := linkr.SuggestIdentifiers(input.Identifier, 5)
+
+		taken, err := a.stores.Links.TakenIdentifiers(ctx, namespaceId, candidates)
+		if err != nil {
+			loggerFrom(ctx).Error(fmt.Sprintf("couldn't check suggested identifiers: %s", err.Error()))
+		} else {
+			for _, candidate := range candidates {
+				if !includes(taken, candidate) && len(details.Suggestions) < 3 {
+					details.Suggestions = append(details.Suggestions, candidate)
+				}
+			}
+		}
+	}
+
+	apiErr := ErrConflict("identifier already taken")
+	apiErr.Details = details
+	return apiErr
+}
+
 const (
 	// Prefix to be atteched to request headers that
 	// we'd like to forward as part of the request
 	//
-	// Example if the saved header is `Linkr-Forward-Super-Secret: 2313`,
-	// the forwarded request becomes `Super-Secret: 2313`
+	// Example if the saved header is `Linkr-Forward-Campaign: spring`,
+	// the forwarded request becomes `Campaign: spring`
 	LinkrHeaderPrefix = "Linkr-Forward"
 )
 
@@ -202,9 +402,10 @@ func extractHeadersToForward(headers http.Header) *string {
 	newheaders := make(http.Header)
 	for hk, hv := range headers {
 		lowerhk := strings.ToLower(hk)
-		if suffix, ok := strings.CutPrefix(lowerhk, fmt.Sprintf("%s-", linkrPrefix)); ok {
-			// remove trailing -
-			newheaders.Set(suffix, strings.Join(hv, ","))
+		if suffix, ok := strings.CutPrefix(lowerhk, fmt.Sprintf("%s-", linkrPrefix)); ok && suffix != "" {
+			for _, v := range hv {
+				newheaders.Add(suffix, v)
+			}
 		}
 	}
 
@@ -212,15 +413,6 @@ func extractHeadersToForward(headers http.Header) *string {
 		return nil
 	}
 
-	// simple serialization
-	// to store result as k1=v11,v12;k2=v21,v22
-	serialized := make([]string, len(newheaders))
-	for k, v := range newheaders {
-		// comma separate values and
-		// potential store as k=v1,v2,v3
-		serialized = append(serialized, fmt.Sprintf("%s=%s", k, strings.Join(v, ",")))
-	}
-
-	output := (strings.Join(serialized, ";"))
+	output := EncodeForwardHeaders(newheaders)
 	return &output
 }
@@ -0,0 +1,337 @@
+// Responsible for managing the api clients
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func describeClient(client *LinkrClient) ResponseClient {
+	res := ResponseClient{
+		Id:          client.Id,
+		Username:    client.Username,
+		Description: client.Description.String,
+		Scope:       client.Scope,
+		Algorithm:   client.Algorithm,
+		Disabled:    client.DisabledAt.Valid,
+		AllowHosts:  splitList(client.AllowHosts.String),
+		DenyHosts:   splitList(client.DenyHosts.String),
+		CreatedAt:   client.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:   client.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+
+	if client.DisabledAt.Valid {
+		res.DisabledAt = client.DisabledAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	if client.LastUsedAt.Valid {
+		res.LastUsedAt = client.LastUsedAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	return res
+}
+
+// retrieves the client `id`
+func (a *ApiHandler) findClient(r *http.Request, id string) (*LinkrClient, error) {
+	return a.stores.Clients.Get(r.Context(), id)
+}
+
+// refuses operations of the client on itself, which would lock it out
+func refuseSelf(r *http.Request, client *LinkrClient, operation string) error {
+	self, err := requestClient(r)
+	if err != nil {
+		return err
+	}
+
+	if self.Id == client.Id {
+		return ErrForbidden(fmt.Sprintf("clients can't %s themselves", operation))
+	}
+
+	return nil
+}
+
+// Handler for listing the clients, oldest first.
+//
+// Supported query parameters:
+//   - status: active | disabled
+func (a *ApiHandler) HandleListClients(w http.ResponseWriter, r *http.Request) {
+	filter := ClientFilter{}
+
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case "active", "disabled":
+		disabled := status == "disabled"
+		filter.Disabled = &disabled
+	default:
+		writeError(w, r, ErrValidation(FieldError{Field: "status", Message: "must be one of [active disabled]"}))
+		return
+	}
+
+	clients, err := a.stores.Clients.List(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't list clients: %w", err))
+		return
+	}
+
+	res := make([]ResponseClient, 0, len(clients))
+	for i := range clients {
+		res = append(res, describeClient(&clients[i]))
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "clients retrieved",
+		Details: res,
+	})
+}
+
+// Handler for retrieving a client
+func (a *ApiHandler) HandleGetClient(w http.ResponseWriter, r *http.Request) {
+	client, err := a.findClient(r, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "client"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "client retrieved",
+		Details: describeClient(client),
+	})
+}
+
+// Handler for changing the description, scope or host lists of a client.
+// Clients can't change their own host lists
+func (a *ApiHandler) HandleUpdateClient(w http.ResponseWriter, r *http.Request) {
+	input := new(RequestClientUpdate)
+	if err := decodeRequest(r, input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	client, err := a.findClient(r, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "client"))
+		return
+	}
+
+	if err := authorizeManage(r, client); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	update := ClientUpdate{Description: input.Description}
+
+	if input.Scope != nil {
+		// already validated
+		actions, _ := linkr.ParseScope(strings.Join(input.Scope, ","))
+		if err := authorizeGrant(r, actions); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		scope := linkr.FormatScope(actions)
+		update.Scope = &scope
+	}
+
+	if input.AllowHosts != nil || input.DenyHosts != nil {
+		self, err := requestClient(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if self.Id == client.Id {
+			writeError(w, r, ErrForbidden("clients can't change their own host lists"))
+			return
+		}
+	}
+
+	if input.AllowHosts != nil {
+		hosts := joinHostList(*input.AllowHosts)
+		update.AllowHosts = &hosts
+	}
+
+	if input.DenyHosts != nil {
+		hosts := joinHostList(*input.DenyHosts)
+		update.DenyHosts = &hosts
+	}
+
+	if update != (ClientUpdate{}) {
+		if err := a.stores.Clients.Update(r.Context(), client.Id, update); err != nil {
+			writeError(w, r, fmt.Errorf("couldn't update client %s: %w", client.Id, err))
+			return
+		}
+
+		client, err = a.findClient(r, client.Id)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't retrieve client: %w", err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "client updated",
+		Details: describeClient(client),
+	})
+}
+
+// Handler for disabling a client. Disabled clients can't authenticate,
+// with their signing key nor the access tokens they were issued
+func (a *ApiHandler) HandleDisableClient(w http.ResponseWriter, r *http.Request) {
+	a.setClientDisabled(w, r, true)
+}
+
+// Handler for enabling a disabled client
+func (a *ApiHandler) HandleEnableClient(w http.ResponseWriter, r *http.Request) {
+	a.setClientDisabled(w, r, false)
+}
+
+func (a *ApiHandler) setClientDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	client, err := a.findClient(r, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "client"))
+		return
+	}
+
+	if err := authorizeManage(r, client); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if disabled {
+		if err := refuseSelf(r, client, "disable"); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now().UTC()
+
+		// disabling again keeps when it was first disabled
+		disabledAt = &now
+		if client.DisabledAt.Valid {
+			disabledAt = &client.DisabledAt.Time
+		}
+	}
+
+	if err := a.stores.Clients.SetDisabled(r.Context(), client.Id, disabledAt); err != nil {
+		writeError(w, r, fmt.Errorf("couldn't update client %s: %w", client.Id, err))
+		return
+	}
+
+	client, err = a.findClient(r, client.Id)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't retrieve client: %w", err))
+		return
+	}
+
+	message := "client enabled"
+	if disabled {
+		message = "client disabled"
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: message,
+		Details: describeClient(client),
+	})
+}
+
+// Handler for deleting a client, along with its previous keys and
+// namespace memberships. Namespaces it owns are left without an owner
+func (a *ApiHandler) HandleDeleteClient(w http.ResponseWriter, r *http.Request) {
+	client, err := a.findClient(r, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "client"))
+		return
+	}
+
+	if err := refuseSelf(r, client, "delete"); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := authorizeManage(r, client); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := a.stores.Clients.Delete(r.Context(), client.Id); err != nil {
+		writeError(w, r, fmt.Errorf("couldn't delete client %s: %w", client.Id, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for rotating the signing key of a client.
+// Clients signing with a key pair send their new public key,
+// while the others are responded a new shared key.
+//
+// The previous key keeps working for the grace window of the handler,
+// so the client can switch keys without failing requests
+func (a *ApiHandler) HandleRotateClientKey(w http.ResponseWriter, r *http.Request) {
+	client, err := a.findClient(r, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "client"))
+		return
+	}
+
+	if err := authorizeManage(r, client); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	res := ResponseClientKeyRotated{ClientId: client.Id, Algorithm: client.Algorithm}
+
+	var key string
+	if isAsymmetricAlgorithm(client.Algorithm) {
+		input := new(RequestClientKeyRotate)
+		if err := decodeRequest(r, input); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		normalized, err := normalizePublicKey(client.Algorithm, input.PublicKey)
+		if err != nil {
+			writeError(w, r, ErrValidation(FieldError{Field: "public_key", Message: err.Error()}))
+			return
+		}
+
+		key = normalized
+	} else {
+		generated, err := generateSigningKey()
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't generate signing key: %w", err))
+			return
+		}
+
+		key = generated
+		res.SigningKey = generated
+	}
+
+	// the previous key keeps working through the grace
+	var previousExpiresAt *time.Time
+	if a.keyGrace > 0 {
+		expiresAt := now.Add(a.keyGrace)
+		previousExpiresAt = &expiresAt
+		res.PreviousKeyExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+
+	if err := a.stores.Clients.RotateKey(r.Context(), client.Id, key, now, previousExpiresAt); err != nil {
+		writeError(w, r, fmt.Errorf("couldn't rotate the key of client %s: %w", client.Id, err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "signing key rotated",
+		Details: res,
+	})
+}
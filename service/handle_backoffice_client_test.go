@@ -0,0 +1,423 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGeneratedClientsHaveTheirOwnKeys(t *testing.T) {
+	first, err := generateClient("read-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := generateClient("read-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.SigningKey == second.SigningKey {
+		t.Error("expected each client to have its own signing key")
+	}
+
+	if _, err := NewVerifier(NewMemoryNonceStore(), DefaultDigestMaxAge, SigningKey{Algorithm: SigningAlgHS256, Key: first.SigningKey}); err != nil {
+		t.Errorf("expected the key to be usable by the verifier: %s", err)
+	}
+}
+
+func TestRotateClientKey(t *testing.T) {
+	db, dfNs := newTestDB(t)
+
+	oldKey, _ := generateSigningKey()
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_1', 'bot', 'admin', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, oldKey)
+
+	a := newTestApiHandler(db, dfNs)
+	r := chi.NewRouter()
+	r.Use(asTestAdmin)
+	r.Post("/client/{id}/rotate-key", a.HandleRotateClientKey)
+
+	rotate := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/client/"+id+"/rotate-key", nil))
+		return rec
+	}
+
+	if rec := rotate("missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown clients, got %d", rec.Code)
+	}
+
+	rec := rotate("api_1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rotating failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	res := ResponseClientKeyRotated{}
+	decodeDetails(t, rec, &res)
+
+	if res.SigningKey == "" || res.SigningKey == oldKey || res.PreviousKeyExpiresAt == "" {
+		t.Fatalf("unexpected rotation %+v", res)
+	}
+
+	cc := NewCommandCenter(NewSQLClientStore(db), NewMemoryNonceStore(), DefaultDigestMaxAge, NewTokenIssuer([]byte("secret"), DefaultAccessTokenTTL), nil, nil)
+	unknownKey, _ := generateSigningKey()
+
+	for _, tt := range []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"new key", res.SigningKey, http.StatusOK},
+		{"previous key in its grace", oldKey, http.StatusOK},
+		{"unknown key", unknownKey, http.StatusUnauthorized},
+	} {
+		if got := gatedStatus(t, cc, "api_1", tt.key); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+
+	// once the grace is over, the previous key stops working
+	db.MustExec(`UPDATE "ClientKey" SET expires_at = ?`, time.Now().UTC().Add(-time.Minute))
+	if got := gatedStatus(t, cc, "api_1", oldKey); got != http.StatusUnauthorized {
+		t.Errorf("expected the expired key to be rejected, got %d", got)
+	}
+
+	// without grace, the previous key stops working right away
+	a.keyGrace = 0
+	newKey := res.SigningKey
+	rec = rotate("api_1")
+	if !strings.Contains(rec.Body.String(), "client_signing_key") || strings.Contains(rec.Body.String(), "previous_key_expires_at") {
+		t.Fatalf("unexpected rotation without grace: %s", rec.Body.String())
+	}
+
+	if got := gatedStatus(t, cc, "api_1", newKey); got != http.StatusUnauthorized {
+		t.Errorf("expected the rotated key to be rejected without grace, got %d", got)
+	}
+
+	var kept int
+	db.Get(&kept, `SELECT COUNT(*) FROM "ClientKey"`)
+	if kept != 0 {
+		t.Errorf("expected expired keys to be removed on rotation, got %d", kept)
+	}
+}
+
+func TestClientsWithKeyPairs(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+	cc := NewCommandCenter(NewSQLClientStore(db), NewMemoryNonceStore(), DefaultDigestMaxAge, NewTokenIssuer([]byte("secret"), DefaultAccessTokenTTL), nil, nil)
+
+	r := chi.NewRouter()
+	r.Use(asTestAdmin)
+	r.Post("/client/create", a.HandleCreateClient)
+	r.Post("/client/{id}/rotate-key", a.HandleRotateClientKey)
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	// status of a request signed by the client with `alg`
+	signedStatus := func(clientId string, alg jwt.Algorithm) int {
+		h := cc.MiddlewareGated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest(http.MethodGet, "/links", nil)
+		signTestTokenWith(t, req, clientId, alg, LinkrJWToken{})
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	ecPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	edDer, _ := x509.MarshalPKIXPublicKey(edPub)
+	ecDer, _ := x509.MarshalPKIXPublicKey(&ecPriv.PublicKey)
+	edPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edDer}))
+
+	for _, tt := range []struct {
+		name      string
+		algorithm string
+		publicKey string
+		signer    jwt.Algorithm
+	}{
+		{"ed25519 with a pem key", SigningAlgEd25519, edPem, jwt.NewEd25519(jwt.Ed25519PrivateKey(edPriv))},
+		{"es256 with a base64 key", SigningAlgES256, base64.StdEncoding.EncodeToString(ecDer), jwt.NewES256(jwt.ECDSAPrivateKey(ecPriv))},
+	} {
+		body, _ := json.Marshal(RequestClientCreate{Username: tt.algorithm, Role: "read-only", Algorithm: tt.algorithm, PublicKey: tt.publicKey})
+		rec := post("/client/create", string(body))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("%s: create failed with %d: %s", tt.name, rec.Code, rec.Body.String())
+		}
+
+		client := linkr.Client{}
+		decodeDetails(t, rec, &client)
+		if client.Algorithm != tt.algorithm || client.SigningKey != "" {
+			t.Errorf("%s: unexpected client %+v", tt.name, client)
+		}
+
+		if got := signedStatus(client.Id, tt.signer); got != http.StatusOK {
+			t.Errorf("%s: expected requests signed with the private key to pass, got %d", tt.name, got)
+		}
+
+		// signing with the public key as an HS256 secret must not work
+		stored := ""
+		db.Get(&stored, `SELECT signing_key FROM "ApiClient" WHERE id = ?`, client.Id)
+		secret, _ := base64.StdEncoding.DecodeString(stored)
+		if got := signedStatus(client.Id, jwt.NewHS256(secret)); got != http.StatusUnauthorized {
+			t.Errorf("%s: expected requests signed with the public key to be rejected, got %d", tt.name, got)
+		}
+	}
+
+	edClient := ""
+	db.Get(&edClient, `SELECT id FROM "ApiClient" WHERE algorithm = ?`, SigningAlgEd25519)
+
+	if rec := post("/client/"+edClient+"/rotate-key", `{}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected rotating without a public key to fail, got %d", rec.Code)
+	}
+
+	newPub, newPriv, _ := ed25519.GenerateKey(rand.Reader)
+	newDer, _ := x509.MarshalPKIXPublicKey(newPub)
+	rec := post("/client/"+edClient+"/rotate-key", `{"public_key": "`+base64.StdEncoding.EncodeToString(newDer)+`"}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "client_signing_key") {
+		t.Fatalf("unexpected rotation %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for name, signer := range map[string]jwt.Algorithm{
+		"new key":                   jwt.NewEd25519(jwt.Ed25519PrivateKey(newPriv)),
+		"previous key in its grace": jwt.NewEd25519(jwt.Ed25519PrivateKey(edPriv)),
+	} {
+		if got := signedStatus(edClient, signer); got != http.StatusOK {
+			t.Errorf("%s: expected %d, got %d", name, http.StatusOK, got)
+		}
+	}
+
+	for _, tt := range []struct {
+		body  string
+		field string
+	}{
+		{`{"username": "bot-a", "algorithm": "RS256"}`, "algorithm"},
+		{`{"username": "bot-b", "algorithm": "Ed25519"}`, "public_key"},
+		{`{"username": "bot-c", "algorithm": "ES256", "public_key": "` + base64.StdEncoding.EncodeToString(edDer) + `"}`, "public_key"},
+		{`{"username": "bot-d", "public_key": "` + base64.StdEncoding.EncodeToString(edDer) + `"}`, "public_key"},
+	} {
+		rec := post("/client/create", tt.body)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected %s to be rejected, got %d", tt.body, rec.Code)
+			continue
+		}
+
+		if fields := decodeError(t, rec).Fields; len(fields) != 1 || fields[0].Field != tt.field {
+			t.Errorf("expected %s to be rejected for %s, got %+v", tt.body, tt.field, fields)
+		}
+	}
+}
+
+func TestClientLifecycle(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+	usage := NewClientUsageTracker(NewSQLClientStore(db), time.Hour)
+	cc := NewCommandCenter(NewSQLClientStore(db), NewMemoryNonceStore(), DefaultDigestMaxAge, NewTokenIssuer([]byte("secret"), DefaultAccessTokenTTL), usage, nil)
+
+	r := chi.NewRouter()
+	r.Use(asTestAdmin)
+	r.Post("/client/create", a.HandleCreateClient)
+	r.Get("/client", a.HandleListClients)
+	r.Get("/client/{id}", a.HandleGetClient)
+	r.Patch("/client/{id}", a.HandleUpdateClient)
+	r.Delete("/client/{id}", a.HandleDeleteClient)
+	r.Post("/client/{id}/disable", a.HandleDisableClient)
+	r.Post("/client/{id}/enable", a.HandleEnableClient)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/client/create", `{"username": "bot", "description": "posts links", "role": "read-only"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	created := linkr.Client{}
+	decodeDetails(t, rec, &created)
+
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES (?, 'admin', 'admin', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, testAdmin.Id)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag, owner_id) VALUES ('d', ?)`, created.Id)
+
+	described := func(rec *httptest.ResponseRecorder) ResponseClient {
+		t.Helper()
+		res := ResponseClient{}
+		decodeDetails(t, rec, &res)
+		return res
+	}
+
+	client := described(serve(http.MethodGet, "/client/"+created.Id, ""))
+	if client.Username != "bot" || client.Description != "posts links" || client.Scope != "links:read,stats:read" || client.Disabled {
+		t.Errorf("unexpected client %+v", client)
+	}
+
+	if strings.Contains(serve(http.MethodGet, "/client", "").Body.String(), created.SigningKey) {
+		t.Error("expected signing keys not to be listed")
+	}
+
+	if rec := serve(http.MethodGet, "/client/api_ghost", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected unknown clients not to be found, got %d", rec.Code)
+	}
+
+	if rec := serve(http.MethodPatch, "/client/"+created.Id, `{"scope": ["links:write"]}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected unknown actions to be rejected, got %d", rec.Code)
+	}
+
+	client = described(serve(http.MethodPatch, "/client/"+created.Id, `{"description": "", "scope": ["read-only", "links:create"]}`))
+	if client.Description != "" || client.Scope != "links:create,links:read,stats:read" {
+		t.Errorf("unexpected update %+v", client)
+	}
+
+	if got := gatedStatus(t, cc, created.Id, created.SigningKey); got != http.StatusOK {
+		t.Fatalf("expected the client to authenticate, got %d", got)
+	}
+
+	if written, err := usage.Flush(context.Background()); err != nil || written != 1 {
+		t.Fatalf("expected the use of the client to be written, got %d: %v", written, err)
+	}
+
+	if client := described(serve(http.MethodGet, "/client/"+created.Id, "")); client.LastUsedAt == "" {
+		t.Error("expected the last use of the client to be kept")
+	}
+
+	client = described(serve(http.MethodPost, "/client/"+created.Id+"/disable", ""))
+	if !client.Disabled || client.DisabledAt == "" {
+		t.Errorf("expected the client to be disabled, got %+v", client)
+	}
+
+	if got := gatedStatus(t, cc, created.Id, created.SigningKey); got != http.StatusUnauthorized {
+		t.Errorf("expected disabled clients not to authenticate, got %d", got)
+	}
+
+	token, _, _ := cc.tokens.Issue(&LinkrClient{Id: created.Id})
+	req := httptest.NewRequest(http.MethodGet, "/links", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	cc.MiddlewareGated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the tokens of disabled clients to be rejected, got %d", rec.Code)
+	}
+
+	listed := []ResponseClient{}
+	decodeDetails(t, serve(http.MethodGet, "/client?status=disabled", ""), &listed)
+	if len(listed) != 1 || listed[0].Id != created.Id {
+		t.Errorf("expected the disabled client to be listed, got %+v", listed)
+	}
+
+	if client := described(serve(http.MethodPost, "/client/"+created.Id+"/enable", "")); client.Disabled {
+		t.Errorf("expected the client to be enabled, got %+v", client)
+	}
+
+	if got := gatedStatus(t, cc, created.Id, created.SigningKey); got != http.StatusOK {
+		t.Errorf("expected enabled clients to authenticate, got %d", got)
+	}
+
+	for _, path := range []string{"/client/" + testAdmin.Id + "/disable", "/client/" + testAdmin.Id} {
+		method := http.MethodPost
+		if !strings.HasSuffix(path, "/disable") {
+			method = http.MethodDelete
+		}
+
+		if rec := serve(method, path, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected clients not to lock themselves out, got %d", method, path, rec.Code)
+		}
+	}
+
+	if rec := serve(http.MethodDelete, "/client/"+created.Id, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(http.MethodGet, "/client/"+created.Id, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the client to be deleted, got %d", rec.Code)
+	}
+
+	owner := ""
+	db.Get(&owner, `SELECT COALESCE(owner_id, '') FROM "Namespace" WHERE unique_tag = 'd'`)
+	if owner != "" {
+		t.Errorf("expected the namespaces of the client to be left without owner, got '%s'", owner)
+	}
+}
+
+func TestManagersCantTakeOverMorePowerfulClients(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+
+	adminKey, _ := generateSigningKey()
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_root', 'root', ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, linkr.FormatScope(linkr.SupportedActions()), adminKey)
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_peer', 'peer', 'clients:manage', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, adminKey)
+
+	manager := &LinkrClient{Id: "api_manager", Scope: linkr.ActionClientsManage}
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, withTestClient(r, manager))
+		})
+	})
+	r.Patch("/client/{id}", a.HandleUpdateClient)
+	r.Delete("/client/{id}", a.HandleDeleteClient)
+	r.Post("/client/{id}/disable", a.HandleDisableClient)
+	r.Post("/client/{id}/enable", a.HandleEnableClient)
+	r.Post("/client/{id}/rotate-key", a.HandleRotateClientKey)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/client/api_root/rotate-key", ""},
+		{http.MethodPatch, "/client/api_root", `{"allow_hosts": []}`},
+		{http.MethodPatch, "/client/api_root", `{"description": "mine"}`},
+		{http.MethodPost, "/client/api_root/disable", ""},
+		{http.MethodPost, "/client/api_root/enable", ""},
+		{http.MethodDelete, "/client/api_root", ""},
+	} {
+		if rec := serve(tt.method, tt.path, tt.body); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected the admin not to be managed by a client manager, got %d: %s", tt.method, tt.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	stored := ""
+	db.Get(&stored, `SELECT signing_key FROM "ApiClient" WHERE id = 'api_root'`)
+	if stored != adminKey {
+		t.Error("expected the key of the admin to be kept")
+	}
+
+	// clients allowed no more than the manager can still be managed
+	if rec := serve(http.MethodPost, "/client/api_peer/rotate-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected the manager to rotate the key of its peer, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(http.MethodPost, "/client/api_peer/disable", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected the manager to disable its peer, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,314 @@
+// Responsible for managing links after they are created
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// number of links listed when the `limit` isn't defined
+	DefaultLinkListLimit = 50
+	// most links that can be listed at once
+	MaxLinkListLimit = 200
+)
+
+// link along with the tag of the namespace it belongs to
+type NamespacedLink struct {
+	Link
+	NamespaceTag string `db:"unique_tag"`
+}
+
+// retrieves the link `identifier` in the namespace tagged `namespace`
+func (a *ApiHandler) findLink(r *http.Request, namespace string, identifier string) (*NamespacedLink, error) {
+	return a.stores.Links.GetByTag(r.Context(), namespace, identifier)
+}
+
+// builds the response describing the link
+func (a *ApiHandler) describeLink(link *NamespacedLink, now time.Time) ResponseLink {
+	res := ResponseLink{
+		Identifier:     link.Tag,
+		Namespace:      link.NamespaceTag,
+		DestinationUrl: link.OriginalUrl,
+		Expired:        link.IsExpiredAt(now),
+		ForwardMode:    ForwardModeNone,
+		CreatedAt:      link.CreatedAt.Format(time.RFC3339),
+	}
+
+	if link.NamespaceTag == linkr.ReservedGlobalChar {
+		res.ShortenedUrl = a.shortner.Create(link.Tag)
+	} else {
+		res.ShortenedUrl = a.shortner.CreateWithNamespace(link.NamespaceTag, link.Tag)
+	}
+
+	if link.ForwardMode.Valid && link.ForwardMode.String != "" {
+		res.ForwardMode = link.ForwardMode.String
+	}
+
+	if link.ExpiresAt.Valid {
+		res.ExpiresAt = link.ExpiresAt.Time.Format(time.RFC3339)
+	}
+
+	if link.ExpiresIn.Valid && link.ExpiresIn.Int32 > 0 {
+		expiresIn := int64(link.ExpiresIn.Int32)
+		res.ExpiresInSeconds = &expiresIn
+	}
+
+	return res
+}
+
+// Handler for retrieving a single link.
+// `{namespace}` is `-` for links without a namespace
+func (a *ApiHandler) HandleGetLink(w http.ResponseWriter, r *http.Request) {
+	link, err := a.findLink(r, chi.URLParam(r, "namespace"), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "link"))
+		return
+	}
+
+	if err := a.authorizeLink(r, link, linkr.ActionLinksRead); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "link retrieved",
+		Details: a.describeLink(link, time.Now()),
+	})
+}
+
+// Handler for listing links, newest first.
+//
+// Supported query parameters:
+//   - namespace: tag of the namespace the links belong to
+//   - created_after, created_before: RFC3339 creation range
+//   - status: active | expired
+//   - destination_prefix: start of the destination url
+//   - limit: number of links in the page
+//   - cursor: `next_cursor` of the previous page
+func (a *ApiHandler) HandleListLinks(w http.ResponseWriter, r *http.Request) {
+	client, err := requestClient(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now().UTC()
+
+	filter := LinkFilter{
+		// only the links of the namespaces the client can read
+		Access:            namespaceAccess(client, linkr.ActionLinksRead),
+		Namespace:         query.Get("namespace"),
+		Now:               now,
+		DestinationPrefix: query.Get("destination_prefix"),
+	}
+
+	for param, bound := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, r, ErrValidation(FieldError{Field: param, Message: "must be an RFC3339 time"}))
+			return
+		}
+
+		*bound = &at
+	}
+
+	switch status := query.Get("status"); status {
+	case "", "active", "expired":
+		filter.Status = status
+	default:
+		writeError(w, r, ErrValidation(FieldError{Field: "status", Message: "must be one of [active expired]"}))
+		return
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		lastId, err := decodeLinkCursor(cursor)
+		if err != nil {
+			writeError(w, r, ErrValidation(FieldError{Field: "cursor", Message: "must be the `next_cursor` of a previous page"}))
+			return
+		}
+
+		filter.BeforeId = lastId
+	}
+
+	limit := DefaultLinkListLimit
+	if value := query.Get("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l <= 0 || l > MaxLinkListLimit {
+			writeError(w, r, ErrValidation(FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", MaxLinkListLimit)}))
+			return
+		}
+
+		limit = l
+	}
+
+	// fetch one more than needed to know if there's a next page
+	filter.Limit = limit + 1
+
+	links, err := a.stores.Links.List(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't list links: %w", err))
+		return
+	}
+
+	res := ResponseLinkList{Links: make([]ResponseLink, 0, len(links))}
+	if len(links) > limit {
+		links = links[:limit]
+		res.NextCursor = encodeLinkCursor(links[limit-1].Id)
+	}
+
+	for i := range links {
+		res.Links = append(res.Links, a.describeLink(&links[i], now))
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "links retrieved",
+		Details: res,
+	})
+}
+
+// Handler for changing the destination, expiry or
+// header forwarding of a link
+func (a *ApiHandler) HandleUpdateLink(w http.ResponseWriter, r *http.Request) {
+	input := new(RequestLinkUpdate)
+	if err := decodeRequest(r, input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	link, err := a.findLink(r, chi.URLParam(r, "namespace"), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "link"))
+		return
+	}
+
+	if err := a.authorizeLink(r, link, linkr.ActionLinksCreate); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	update := LinkUpdate{ForwardMode: input.ForwardMode}
+
+	if input.ForwardMode != nil {
+		if err := checkForwardMode(*input.ForwardMode, link.SerializedHeaders.String); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	if input.Url != nil {
+		ns, err := a.stores.Namespaces.Get(r.Context(), int64(link.NamespaceId))
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't retrieve the namespace of link %d: %w", link.Id, err))
+			return
+		}
+
+		policy, err := a.destinationPolicy(r, ns)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		destination, err := policy.Check(r.Context(), "redirect_url", *input.Url)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		update.DestinationUrl = &destination
+	}
+
+	if input.ExpiresIn != nil {
+		var expiresIn int64 = 0
+		var expiresAt *time.Time = nil
+
+		// empty duration means the link never expires
+		if *input.ExpiresIn != "" {
+			// already validated
+			ex, _ := linkr.ConvertStringDurationToSeconds(*input.ExpiresIn)
+
+			expiresIn = int64(ex.Seconds())
+			v := now.Add(ex)
+			expiresAt = &v
+		}
+
+		update.SetExpiry = true
+		update.ExpiresIn = expiresIn
+		update.ExpiresAt = expiresAt
+	}
+
+	if update.DestinationUrl != nil || update.SetExpiry || update.ForwardMode != nil {
+		if err := a.stores.Links.Update(r.Context(), link.Id, update); err != nil {
+			writeError(w, r, fmt.Errorf("couldn't update link: %w", err))
+			return
+		}
+
+		link, err = a.findLink(r, link.NamespaceTag, link.Tag)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't retrieve link: %w", err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "link updated",
+		Details: a.describeLink(link, now),
+	})
+}
+
+// Handler for deleting a link
+func (a *ApiHandler) HandleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	link, err := a.findLink(r, chi.URLParam(r, "namespace"), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "link"))
+		return
+	}
+
+	if err := a.authorizeLink(r, link, linkr.ActionLinksDelete); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := a.stores.Links.Delete(r.Context(), link.Id); err != nil {
+		writeError(w, r, fmt.Errorf("couldn't delete link: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// escapes the wildcards of a LIKE pattern, using `\`
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeLinkCursor(lastId int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(lastId)))
+}
+
+func decodeLinkCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(raw))
+}
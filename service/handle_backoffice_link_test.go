@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newTestLinkApi(a *ApiHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(asTestAdmin)
+	r.Get("/links", a.HandleListLinks)
+	r.Get("/links/{namespace}/{id}", a.HandleGetLink)
+	r.Patch("/links/{namespace}/{id}", a.HandleUpdateLink)
+	r.Delete("/links/{namespace}/{id}", a.HandleDeleteLink)
+	return r
+}
+
+func decodeDetails(t *testing.T, rec *httptest.ResponseRecorder, details interface{}) {
+	t.Helper()
+
+	if err := json.NewDecoder(rec.Body).Decode(&ResponseClientCreate{Details: details}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) *ApiError {
+	t.Helper()
+
+	res := ResponseError{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Error == nil {
+		t.Fatalf("expected an error envelope, got %d", rec.Code)
+	}
+
+	return res.Error
+}
+
+func TestListLinks(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag) VALUES ('d')`)
+
+	past := time.Now().UTC().Add(-time.Hour)
+	insert := `INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`
+	db.MustExec(insert, "a", "https://a.example/1", dfNs.Id, nil, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	db.MustExec(insert, "b", "https://a.example/2", dfNs.Id, past, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	db.MustExec(insert, "c", "https://b.example/100%", 2, nil, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	api := newTestLinkApi(newTestApiHandler(db, dfNs))
+	list := func(query string) ResponseLinkList {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/links?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("listing with '%s' failed with %d: %s", query, rec.Code, rec.Body.String())
+		}
+
+		res := ResponseLinkList{}
+		decodeDetails(t, rec, &res)
+		return res
+	}
+
+	identifiers := func(res ResponseLinkList) string {
+		ids := []string{}
+		for _, l := range res.Links {
+			ids = append(ids, l.Identifier)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "c,b,a"},
+		{"namespace=d", "c"},
+		{"namespace=-", "b,a"},
+		{"status=expired", "b"},
+		{"status=active", "c,a"},
+		{"destination_prefix=https://a.example/", "b,a"},
+		{"destination_prefix=https://b.example/100%25", "c"},
+		{"destination_prefix=https://b.example/1_", ""},
+		{"created_after=2024-01-15T00:00:00Z", "c,b"},
+		{"created_after=2024-01-15T03:00:00%2B03:00&created_before=2024-02-15T00:00:00Z", "b"},
+	}
+
+	for _, tt := range tests {
+		if got := identifiers(list(tt.query)); got != tt.want {
+			t.Errorf("listing with '%s' got [%s], want [%s]", tt.query, got, tt.want)
+		}
+	}
+
+	page := list("limit=2")
+	if identifiers(page) != "c,b" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page [%s] with cursor '%s'", identifiers(page), page.NextCursor)
+	}
+
+	page = list("limit=2&cursor=" + page.NextCursor)
+	if identifiers(page) != "a" || page.NextCursor != "" {
+		t.Fatalf("unexpected last page [%s] with cursor '%s'", identifiers(page), page.NextCursor)
+	}
+
+	if got := list("namespace=d").Links[0].ShortenedUrl; got != "https://examp.le/d/c" {
+		t.Errorf("got short url %s", got)
+	}
+}
+
+func TestUpdateAndDeleteLink(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_in, expires_at) VALUES ('a', 'https://a.example', ?, 60, ?)`, dfNs.Id, time.Now().UTC().Add(-time.Minute))
+
+	api := newTestLinkApi(newTestApiHandler(db, dfNs))
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/links/-/a", "")
+	got := ResponseLink{}
+	decodeDetails(t, rec, &got)
+	if !got.Expired || got.ShortenedUrl != "https://examp.le/a" {
+		t.Errorf("unexpected link %+v", got)
+	}
+
+	rec = serve(http.MethodPatch, "/links/-/a", `{"redirect_url": "https://b.example", "expires_in": ""}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	got = ResponseLink{}
+	decodeDetails(t, rec, &got)
+	if got.Expired || got.ExpiresAt != "" || got.DestinationUrl != "https://b.example" {
+		t.Errorf("link not updated %+v", got)
+	}
+
+	if rec := serve(http.MethodPatch, "/links/-/a", `{"forward_mode": "teleport"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown forward mode accepted with %d", rec.Code)
+	}
+
+	if rec := serve(http.MethodDelete, "/links/-/a", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete failed with %d", rec.Code)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		rec := serve(method, "/links/-/a", `{}`)
+		if rec.Code != http.StatusNotFound || decodeError(t, rec).Code != ErrCodeNotFound {
+			t.Errorf("%s of deleted link got %d", method, rec.Code)
+		}
+	}
+}
+
+func TestQueryModeRefusesCredentials(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+	api := newTestLinkApi(a)
+
+	create := func(mode string, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"redirect_url": "https://examp.le", "identifier": "`+mode+`", "forward_mode": "`+mode+`"}`))
+		req.Header.Set(LinkrHeaderPrefix+"-"+header, "value")
+		rec := httptest.NewRecorder()
+		a.HandleCreateLink(rec, withTestClient(req, testAdmin))
+		return rec
+	}
+
+	for _, header := range []string{"Authorization", "Api-Key", "X-Session-Id", "Super-Secret"} {
+		rec := create(ForwardModeQuery, header)
+		if rec.Code != http.StatusUnprocessableEntity || decodeError(t, rec).Fields[0].Field != "forward_mode" {
+			t.Errorf("%s: expected query mode to be refused, got %d", header, rec.Code)
+		}
+	}
+
+	if rec := create(ForwardModeQuery, "Campaign"); rec.Code != http.StatusCreated {
+		t.Errorf("expected other headers in query mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := create(ForwardModeProxy, "Api-Key"); rec.Code != http.StatusCreated {
+		t.Fatalf("expected credentials to be forwarded by proxy, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/links/-/proxy", strings.NewReader(`{"forward_mode": "query"}`)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the link not to switch to query mode, got %d", rec.Code)
+	}
+}
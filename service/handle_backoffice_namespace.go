@@ -0,0 +1,383 @@
+// Responsible for managing namespaces and who can use them
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// refuses to delete namespaces that still have links
+	NamespaceDeleteRestrict = "restrict"
+	// deletes the links, and their clicks, along with the namespace
+	NamespaceDeleteCascade = "cascade"
+	// keeps the namespace and its links, but stops the links from redirecting
+	NamespaceDeleteArchive = "archive"
+)
+
+func SupportedNamespaceDeleteModes() []string {
+	return []string{NamespaceDeleteRestrict, NamespaceDeleteCascade, NamespaceDeleteArchive}
+}
+
+func describeNamespace(ns *LinkrNamespace) ResponseNamespace {
+	res := ResponseNamespace{
+		Tag:         ns.Tag,
+		Description: ns.Description.String,
+		OwnerId:     ns.OwnerId.String,
+		ExpiredUrl:  ns.ExpiredUrl.String,
+		IdStrategy:  ns.IdStrategy.String,
+		AllowHosts:  splitList(ns.AllowHosts.String),
+		DenyHosts:   splitList(ns.DenyHosts.String),
+	}
+
+	if ns.ArchivedAt.Valid {
+		res.ArchivedAt = ns.ArchivedAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	return res
+}
+
+func describeNamespaceMember(member *NamespaceMember) ResponseNamespaceMember {
+	return ResponseNamespaceMember{
+		ClientId:  member.ClientId,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// retrieves the namespace tagged `tag`
+func (a *ApiHandler) findNamespace(r *http.Request, tag string) (*LinkrNamespace, error) {
+	return a.stores.Namespaces.GetByTag(r.Context(), tag)
+}
+
+// Handler for creating a namespace. It belongs to the client creating
+// it, unless `owner_id` is given
+func (a *ApiHandler) HandleCreateNamespace(w http.ResponseWriter, r *http.Request) {
+	input := new(RequestNamespaceCreate)
+	if err := decodeRequest(r, input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	client, err := requestClient(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ownerId := client.Id
+	if input.OwnerId != "" {
+		_, err := a.stores.Clients.Get(r.Context(), input.OwnerId)
+		if errors.Is(err, ErrRecordNotFound) {
+			writeError(w, r, ErrValidation(FieldError{Field: "owner_id", Message: "no such client"}))
+			return
+		}
+
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't check owner %s exists: %w", input.OwnerId, err))
+			return
+		}
+
+		ownerId = input.OwnerId
+	}
+
+	ns := &LinkrNamespace{
+		Tag:         input.Tag,
+		Description: sql.NullString{String: input.Description, Valid: input.Description != ""},
+		OwnerId:     sql.NullString{String: ownerId, Valid: true},
+		AllowHosts:  storedHostList(input.AllowHosts),
+		DenyHosts:   storedHostList(input.DenyHosts),
+	}
+
+	if err := a.stores.Namespaces.Create(r.Context(), ns); err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ResponseClientCreate{
+		Message: "namespace created",
+		Details: describeNamespace(ns),
+	})
+}
+
+// Handler for listing the namespaces the client has a role in,
+// by their tag. Archived namespaces are left out, unless
+// `include_archived=true`
+func (a *ApiHandler) HandleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	client, err := requestClient(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	namespaces, err := a.stores.Namespaces.List(r.Context(), NamespaceFilter{
+		Access:          namespaceAccess(client, ""),
+		IncludeArchived: r.URL.Query().Get("include_archived") == "true",
+	})
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't list namespaces: %w", err))
+		return
+	}
+
+	res := make([]ResponseNamespace, 0, len(namespaces))
+	for i := range namespaces {
+		res = append(res, describeNamespace(&namespaces[i]))
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "namespaces retrieved",
+		Details: res,
+	})
+}
+
+// Handler for retrieving a namespace
+func (a *ApiHandler) HandleGetNamespace(w http.ResponseWriter, r *http.Request) {
+	ns, err := a.findNamespace(r, chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if err := a.authorizeNamespaceMember(r, ns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "namespace retrieved",
+		Details: describeNamespace(ns),
+	})
+}
+
+// Handler for changing the description, expired url,
+// id strategy or host lists of a namespace
+func (a *ApiHandler) HandleUpdateNamespace(w http.ResponseWriter, r *http.Request) {
+	input := new(RequestNamespaceUpdate)
+	if err := decodeRequest(r, input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ns, err := a.findNamespace(r, chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if err := a.authorizeNamespaceAdmin(r, ns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	update := NamespaceUpdate{
+		Description: input.Description,
+		IdStrategy:  input.IdStrategy,
+	}
+
+	if input.AllowHosts != nil {
+		hosts := joinHostList(*input.AllowHosts)
+		update.AllowHosts = &hosts
+	}
+
+	if input.DenyHosts != nil {
+		hosts := joinHostList(*input.DenyHosts)
+		update.DenyHosts = &hosts
+	}
+
+	if input.ExpiredUrl != nil {
+		expiredUrl := *input.ExpiredUrl
+		if expiredUrl != "" {
+			policy, err := a.destinationPolicy(r, ns)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+
+			expiredUrl, err = policy.Check(r.Context(), "expired_url", expiredUrl)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		update.ExpiredUrl = &expiredUrl
+	}
+
+	if update != (NamespaceUpdate{}) {
+		if err := a.stores.Namespaces.Update(r.Context(), ns.Id, update); err != nil {
+			writeError(w, r, fmt.Errorf("couldn't update namespace %d: %w", ns.Id, err))
+			return
+		}
+
+		ns, err = a.findNamespace(r, ns.Tag)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't retrieve namespace: %w", err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "namespace updated",
+		Details: describeNamespace(ns),
+	})
+}
+
+// Handler for deleting a namespace. The `mode` query parameter decides
+// what happens to its links:
+//   - restrict (default): the namespace isn't deleted while it has links
+//   - cascade: the links are deleted along with it
+//   - archive: the namespace and links are kept, but the links stop redirecting
+func (a *ApiHandler) HandleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	mode := NamespaceDeleteRestrict
+	if value := r.URL.Query().Get("mode"); value != "" {
+		if !includes(SupportedNamespaceDeleteModes(), value) {
+			writeError(w, r, ErrValidation(FieldError{Field: "mode", Message: fmt.Sprintf("must be one of %v", SupportedNamespaceDeleteModes())}))
+			return
+		}
+
+		mode = value
+	}
+
+	ns, err := a.findNamespace(r, chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if ns.Tag == linkr.ReservedGlobalChar {
+		writeError(w, r, ErrForbidden(fmt.Sprintf("namespace '%s' can't be deleted", ns.Tag)))
+		return
+	}
+
+	if err := a.authorizeNamespaceAdmin(r, ns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if mode == NamespaceDeleteArchive {
+		if err := a.stores.Namespaces.Archive(r.Context(), ns.Id, time.Now()); err != nil {
+			writeError(w, r, fmt.Errorf("couldn't archive namespace %d: %w", ns.Id, err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	links, err := a.stores.Namespaces.Delete(r.Context(), ns.Id, mode == NamespaceDeleteCascade)
+	if errors.Is(err, ErrRecordInUse) {
+		writeError(w, r, ErrConflict(fmt.Sprintf("namespace '%s' has %d links. delete them, or use mode cascade or archive", ns.Tag, links)))
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't delete namespace %d: %w", ns.Id, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for listing the members of a namespace
+func (a *ApiHandler) HandleListNamespaceMembers(w http.ResponseWriter, r *http.Request) {
+	ns, err := a.findNamespace(r, chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if err := a.authorizeNamespaceAdmin(r, ns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	members, err := a.stores.Namespaces.Members(r.Context(), ns.Id)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't list the members of namespace %d: %w", ns.Id, err))
+		return
+	}
+
+	res := make([]ResponseNamespaceMember, 0, len(members))
+	for i := range members {
+		res = append(res, describeNamespaceMember(&members[i]))
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "members retrieved",
+		Details: res,
+	})
+}
+
+// Handler for granting a client a role in the namespace,
+// or changing the role it has
+func (a *ApiHandler) HandlePutNamespaceMember(w http.ResponseWriter, r *http.Request) {
+	input := new(RequestNamespaceMember)
+	if err := decodeRequest(r, input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ns, err := a.findNamespace(r, chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if err := a.authorizeNamespaceAdmin(r, ns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	clientId := chi.URLParam(r, "client")
+	if _, err := a.stores.Clients.Get(r.Context(), clientId); err != nil {
+		writeError(w, r, dbError(err, "client"))
+		return
+	}
+
+	err = a.stores.Namespaces.PutMember(r.Context(), &NamespaceMember{NamespaceId: ns.Id, ClientId: clientId, Role: input.Role})
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't save member %s of namespace %d: %w", clientId, ns.Id, err))
+		return
+	}
+
+	// existing members keep when they joined
+	member, err := a.stores.Namespaces.Member(r.Context(), ns.Id, clientId)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't retrieve member: %w", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "member saved",
+		Details: describeNamespaceMember(member),
+	})
+}
+
+// Handler for revoking the access of a client to the namespace
+func (a *ApiHandler) HandleDeleteNamespaceMember(w http.ResponseWriter, r *http.Request) {
+	ns, err := a.findNamespace(r, chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if err := a.authorizeNamespaceAdmin(r, ns); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = a.stores.Namespaces.DeleteMember(r.Context(), ns.Id, chi.URLParam(r, "client"))
+	if err != nil {
+		writeError(w, r, dbError(err, "member"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNamespaceAccess(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+
+	for id, scope := range map[string]string{
+		"api_admin": "admin",
+		"api_owner": "read-write",
+		"api_other": "read-write",
+	} {
+		db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES (?, ?, ?, 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, id, id, scope)
+	}
+
+	owner := &LinkrClient{Id: "api_owner", Scope: "read-write"}
+	other := &LinkrClient{Id: "api_other", Scope: "read-write"}
+
+	r := chi.NewRouter()
+	r.Post("/create", a.HandleCreateLink)
+	r.Get("/links", a.HandleListLinks)
+	r.Get("/links/{namespace}/{id}", a.HandleGetLink)
+	r.Delete("/links/{namespace}/{id}", a.HandleDeleteLink)
+	r.Post("/namespaces", a.HandleCreateNamespace)
+	r.Get("/namespaces/{namespace}/members", a.HandleListNamespaceMembers)
+	r.Put("/namespaces/{namespace}/members/{client}", a.HandlePutNamespaceMember)
+	r.Delete("/namespaces/{namespace}/members/{client}", a.HandleDeleteNamespaceMember)
+
+	as := func(client *LinkrClient, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, withTestClient(httptest.NewRequest(method, path, strings.NewReader(body)), client))
+		return rec
+	}
+
+	// identifiers of the links the client can list
+	listed := func(client *LinkrClient) []string {
+		rec := as(client, http.MethodGet, "/links", "")
+		res := ResponseLinkList{}
+		decodeDetails(t, rec, &res)
+
+		identifiers := []string{}
+		for _, link := range res.Links {
+			identifiers = append(identifiers, link.Namespace+"/"+link.Identifier)
+		}
+		return identifiers
+	}
+
+	if rec := as(other, http.MethodPost, "/create", `{"redirect_url": "https://examp.le", "namespace": "d"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("expected links not to create namespaces, got %d", rec.Code)
+	}
+
+	rec := as(testAdmin, http.MethodPost, "/namespaces", `{"tag": "d", "description": "docs", "owner_id": "api_owner"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("namespace creation failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	ns := ResponseNamespace{}
+	decodeDetails(t, rec, &ns)
+	if ns.Tag != "d" || ns.Description != "docs" || ns.OwnerId != "api_owner" {
+		t.Errorf("unexpected namespace %+v", ns)
+	}
+
+	for body, status := range map[string]int{
+		`{"tag": "d"}`:                          http.StatusConflict,
+		`{"tag": "-"}`:                          http.StatusUnprocessableEntity,
+		`{"tag": "e", "owner_id": "api_ghost"}`: http.StatusUnprocessableEntity,
+	} {
+		if rec := as(testAdmin, http.MethodPost, "/namespaces", body); rec.Code != status {
+			t.Errorf("%s: expected %d, got %d", body, status, rec.Code)
+		}
+	}
+
+	if rec := as(owner, http.MethodPost, "/create", `{"redirect_url": "https://examp.le", "namespace": "d", "identifier": "docs"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected the owner to create links, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := as(other, http.MethodPost, "/create", `{"redirect_url": "https://examp.le", "identifier": "open"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected anyone to create links in the global namespace, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := as(other, http.MethodPost, "/create", `{"redirect_url": "https://examp.le", "namespace": "d"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected clients outside the namespace to be refused, got %d", rec.Code)
+	}
+
+	if rec := as(other, http.MethodGet, "/links/d/docs", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the links of the namespace to be hidden from clients outside of it, got %d", rec.Code)
+	}
+
+	if got := strings.Join(listed(other), ","); got != "-/open" {
+		t.Errorf("expected clients outside the namespace not to list its links, got %s", got)
+	}
+
+	if rec := as(other, http.MethodPut, "/namespaces/d/members/api_other", `{"role": "admin"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected clients outside the namespace not to grant themselves access, got %d", rec.Code)
+	}
+
+	for path, status := range map[string]int{
+		"/namespaces/d/members/api_ghost": http.StatusNotFound,
+		"/namespaces/x/members/api_other": http.StatusNotFound,
+	} {
+		if rec := as(owner, http.MethodPut, path, `{"role": "read-only"}`); rec.Code != status {
+			t.Errorf("%s: expected %d, got %d", path, status, rec.Code)
+		}
+	}
+
+	if rec := as(owner, http.MethodPut, "/namespaces/d/members/api_other", `{"role": "owner"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected unknown roles to be rejected, got %d", rec.Code)
+	}
+
+	if rec := as(owner, http.MethodPut, "/namespaces/d/members/api_other", `{"role": "read-only"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the owner to add members, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := as(other, http.MethodGet, "/links/d/docs", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected members to read the links, got %d", rec.Code)
+	}
+
+	if got := strings.Join(listed(other), ","); got != "-/open,d/docs" {
+		t.Errorf("expected members to list the links, got %s", got)
+	}
+
+	// the role in the namespace limits the scope of the client
+	if rec := as(other, http.MethodDelete, "/links/d/docs", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("expected read-only members not to delete links, got %d", rec.Code)
+	}
+
+	if rec := as(other, http.MethodGet, "/namespaces/d/members", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("expected members that aren't admins not to manage members, got %d", rec.Code)
+	}
+
+	rec = as(owner, http.MethodGet, "/namespaces/d/members", "")
+	members := []ResponseNamespaceMember{}
+	decodeDetails(t, rec, &members)
+	if len(members) != 1 || members[0].ClientId != "api_other" || members[0].Role != "read-only" {
+		t.Errorf("unexpected members %+v", members)
+	}
+
+	if rec := as(owner, http.MethodDelete, "/namespaces/d/members/api_other", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected the member to be removed, got %d", rec.Code)
+	}
+
+	if rec := as(owner, http.MethodDelete, "/namespaces/d/members/api_other", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected removing a missing member to fail, got %d", rec.Code)
+	}
+
+	if rec := as(other, http.MethodGet, "/links/d/docs", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected removed members to lose access, got %d", rec.Code)
+	}
+}
+
+func TestManageNamespaces(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+	links := NewLinkHandler(NewSQLStores(db), dfNs, nil, nil, nil)
+
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_other', 'other', 'read-write', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`)
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES (?, 'admin', 'admin', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, testAdmin.Id)
+	other := &LinkrClient{Id: "api_other", Scope: "read-write"}
+
+	r := chi.NewRouter()
+	r.Post("/create", a.HandleCreateLink)
+	r.Post("/namespaces", a.HandleCreateNamespace)
+	r.Get("/namespaces", a.HandleListNamespaces)
+	r.Get("/namespaces/{namespace}", a.HandleGetNamespace)
+	r.Patch("/namespaces/{namespace}", a.HandleUpdateNamespace)
+	r.Delete("/namespaces/{namespace}", a.HandleDeleteNamespace)
+	r.Get("/r/{namespace}/{id}", links.HandleRedirectShortenedLinkWithNamespace)
+
+	as := func(client *LinkrClient, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, withTestClient(httptest.NewRequest(method, path, strings.NewReader(body)), client))
+		return rec
+	}
+
+	// tags of the namespaces the client can see
+	listed := func(client *LinkrClient, query string) string {
+		res := []ResponseNamespace{}
+		decodeDetails(t, as(client, http.MethodGet, "/namespaces"+query, ""), &res)
+
+		tags := []string{}
+		for _, ns := range res {
+			tags = append(tags, ns.Tag)
+		}
+		return strings.Join(tags, ",")
+	}
+
+	for _, tag := range []string{"notes", "a-b", "v1", "API", "-", ""} {
+		if rec := as(testAdmin, http.MethodPost, "/namespaces", `{"tag": "`+tag+`"}`); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected tag '%s' to be rejected, got %d", tag, rec.Code)
+		}
+	}
+
+	for _, tag := range []string{"d", "e"} {
+		if rec := as(testAdmin, http.MethodPost, "/namespaces", `{"tag": "`+tag+`", "description": "first"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("creating '%s' failed with %d: %s", tag, rec.Code, rec.Body.String())
+		}
+
+		body := `{"redirect_url": "https://examp.le", "namespace": "` + tag + `", "identifier": "home"}`
+		if rec := as(testAdmin, http.MethodPost, "/create", body); rec.Code != http.StatusCreated {
+			t.Fatalf("creating a link in '%s' failed with %d: %s", tag, rec.Code, rec.Body.String())
+		}
+	}
+
+	if got := listed(testAdmin, ""); got != "-,d,e" {
+		t.Errorf("expected all namespaces to be listed, got %s", got)
+	}
+
+	if got := listed(other, ""); got != "-" {
+		t.Errorf("expected namespaces without a role to be left out, got %s", got)
+	}
+
+	if rec := as(other, http.MethodGet, "/namespaces/d", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected namespaces without a role to be hidden, got %d", rec.Code)
+	}
+
+	if rec := as(other, http.MethodPatch, "/namespaces/d", `{"description": "mine"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected clients outside the namespace not to update it, got %d", rec.Code)
+	}
+
+	for body, field := range map[string]string{
+		`{"id_strategy": "teleport"}`:         "id_strategy",
+		`{"expired_url": "ftp://examp.le/x"}`: "expired_url",
+	} {
+		rec := as(testAdmin, http.MethodPatch, "/namespaces/d", body)
+		if rec.Code != http.StatusUnprocessableEntity || decodeError(t, rec).Fields[0].Field != field {
+			t.Errorf("%s: expected %s to be rejected, got %d", body, field, rec.Code)
+		}
+	}
+
+	rec := as(testAdmin, http.MethodPatch, "/namespaces/d", `{"description": "", "expired_url": "https://examp.le/gone", "id_strategy": "base62"}`)
+	ns := ResponseNamespace{}
+	decodeDetails(t, rec, &ns)
+	if ns.Description != "" || ns.ExpiredUrl != "https://examp.le/gone" || ns.IdStrategy != "base62" {
+		t.Errorf("unexpected namespace %+v", ns)
+	}
+
+	for query, status := range map[string]int{
+		"":               http.StatusConflict,
+		"?mode=restrict": http.StatusConflict,
+		"?mode=shred":    http.StatusUnprocessableEntity,
+	} {
+		if rec := as(testAdmin, http.MethodDelete, "/namespaces/d"+query, ""); rec.Code != status {
+			t.Errorf("%s: expected %d, got %d", query, status, rec.Code)
+		}
+	}
+
+	if rec := as(testAdmin, http.MethodDelete, "/namespaces/-?mode=cascade", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the global namespace not to be deleted, got %d", rec.Code)
+	}
+
+	if rec := as(testAdmin, http.MethodDelete, "/namespaces/d?mode=archive", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("archiving failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// links of archived namespaces are handled as expired
+	if rec := as(nil, http.MethodGet, "/r/d/home", ""); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://examp.le/gone" {
+		t.Errorf("expected links of archived namespaces to go to the expired url, got %d", rec.Code)
+	}
+
+	if rec := as(testAdmin, http.MethodPost, "/create", `{"redirect_url": "https://examp.le", "namespace": "d"}`); rec.Code != http.StatusGone {
+		t.Errorf("expected archived namespaces not to take links, got %d", rec.Code)
+	}
+
+	if got := listed(testAdmin, ""); got != "-,e" {
+		t.Errorf("expected archived namespaces to be left out, got %s", got)
+	}
+
+	if got := listed(testAdmin, "?include_archived=true"); got != "-,d,e" {
+		t.Errorf("expected archived namespaces to be included, got %s", got)
+	}
+
+	db.MustExec(`INSERT INTO "Click" (link_id, namespace_id, clicked_at) SELECT l.id, l.namespace_id, CURRENT_TIMESTAMP FROM "Link" l JOIN "Namespace" n ON n.id = l.namespace_id WHERE n.unique_tag = 'e'`)
+
+	if rec := as(testAdmin, http.MethodDelete, "/namespaces/e?mode=cascade", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("cascading failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	remaining := 0
+	db.Get(&remaining, `SELECT (SELECT COUNT(*) FROM "Link") + (SELECT COUNT(*) FROM "Click") + (SELECT COUNT(*) FROM "Namespace" WHERE unique_tag = 'e')`)
+	if remaining != 1 {
+		t.Errorf("expected only the link of the archived namespace to remain, got %d rows", remaining)
+	}
+
+	if rec := as(testAdmin, http.MethodGet, "/namespaces/e", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the namespace to be deleted, got %d", rec.Code)
+	}
+}
+
+func TestHostListsOfNamespacesAndClients(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES (?, 'admin', 'admin', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, testAdmin.Id)
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_docs', 'docs', 'admin', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`)
+
+	r := chi.NewRouter()
+	r.Post("/create", a.HandleCreateLink)
+	r.Post("/namespaces", a.HandleCreateNamespace)
+	r.Patch("/namespaces/{namespace}", a.HandleUpdateNamespace)
+	r.Patch("/clients/{id}", a.HandleUpdateClient)
+
+	as := func(client *LinkrClient, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, withTestClient(httptest.NewRequest(method, path, strings.NewReader(body)), client))
+		return rec
+	}
+
+	createLink := func(client *LinkrClient, url string) int {
+		return as(client, http.MethodPost, "/create", `{"redirect_url": "`+url+`", "namespace": "h"}`).Code
+	}
+
+	if rec := as(testAdmin, http.MethodPost, "/namespaces", `{"tag": "h", "allow_hosts": ["https://examp.le"]}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected urls to be rejected as hosts, got %d", rec.Code)
+	}
+
+	rec := as(testAdmin, http.MethodPost, "/namespaces", `{"tag": "h", "allow_hosts": ["Examp.le", "examp.org"]}`)
+	ns := ResponseNamespace{}
+	decodeDetails(t, rec, &ns)
+	if strings.Join(ns.AllowHosts, ",") != "examp.le,examp.org" {
+		t.Errorf("expected the allowed hosts to be saved, got %v", ns.AllowHosts)
+	}
+
+	if code := createLink(testAdmin, "https://other.host/"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected hosts outside the namespace allow list to be rejected, got %d", code)
+	}
+
+	rec = as(testAdmin, http.MethodPatch, "/namespaces/h", `{"deny_hosts": ["private.examp.le"]}`)
+	decodeDetails(t, rec, &ns)
+	if strings.Join(ns.DenyHosts, ",") != "private.examp.le" || len(ns.AllowHosts) != 2 {
+		t.Errorf("expected the denied hosts to be added, got %+v", ns)
+	}
+
+	if code := createLink(testAdmin, "https://private.examp.le/"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected hosts denied by the namespace to be rejected, got %d", code)
+	}
+
+	// the lists of the client are satisfied along with the namespace's
+	docs := &LinkrClient{Id: "api_docs", Scope: linkr.RoleAdmin}
+	if rec := as(docs, http.MethodPatch, "/clients/api_docs", `{"allow_hosts": []}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected clients not to change their own host lists, got %d", rec.Code)
+	}
+
+	if rec := as(testAdmin, http.MethodPatch, "/clients/api_docs", `{"allow_hosts": ["docs.examp.le"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("updating the client failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	docs, _ = a.stores.Clients.Get(context.Background(), "api_docs")
+	for url, status := range map[string]int{
+		"https://docs.examp.le/":  http.StatusCreated,
+		"https://examp.le/":       http.StatusUnprocessableEntity,
+		"https://docs.examp.org/": http.StatusUnprocessableEntity,
+	} {
+		if code := createLink(docs, url); code != status {
+			t.Errorf("%s: expected %d, got %d", url, status, code)
+		}
+	}
+
+	if rec := as(testAdmin, http.MethodPatch, "/namespaces/h", `{"allow_hosts": []}`); rec.Code != http.StatusOK {
+		t.Fatalf("clearing the allowed hosts failed with %d", rec.Code)
+	}
+
+	if code := createLink(testAdmin, "https://other.host/"); code != http.StatusCreated {
+		t.Errorf("expected any host once the allow list is cleared, got %d", code)
+	}
+}
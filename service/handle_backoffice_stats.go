@@ -0,0 +1,246 @@
+// Responsible for serving the click stats of links and namespaces
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	StatsBucketHour = "hour"
+	StatsBucketDay  = "day"
+	StatsBucketWeek = "week"
+
+	// range of the stats when `from` isn't defined
+	DefaultStatsRange = 7 * 24 * time.Hour
+	// most buckets in the series of the stats
+	MaxStatsBuckets = 1000
+	// entries of the top referrers, agents and links
+	StatsTopLimit = 10
+)
+
+func SupportedStatsBuckets() []string {
+	return []string{StatsBucketHour, StatsBucketDay, StatsBucketWeek}
+}
+
+// time range the stats are served for
+type statsRange struct {
+	// aligned to the start of the bucket
+	from   time.Time
+	to     time.Time
+	bucket string
+}
+
+// start of the bucket `t` belongs to
+func (s *statsRange) truncate(t time.Time) time.Time {
+	switch s.bucket {
+	case StatsBucketHour:
+		return t.UTC().Truncate(time.Hour)
+	case StatsBucketWeek:
+		return truncateWeek(t)
+	default:
+		return truncateDay(t)
+	}
+}
+
+func (s *statsRange) next(t time.Time) time.Time {
+	switch s.bucket {
+	case StatsBucketHour:
+		return t.Add(time.Hour)
+	case StatsBucketWeek:
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// Reads the `from`, `to` and `bucket` query parameters
+func parseStatsRange(query url.Values, now time.Time) (*statsRange, error) {
+	s := &statsRange{bucket: StatsBucketDay, to: now.UTC()}
+
+	if bucket := query.Get("bucket"); bucket != "" {
+		if !includes(SupportedStatsBuckets(), bucket) {
+			return nil, ErrValidation(FieldError{Field: "bucket", Message: fmt.Sprintf("must be one of %v", SupportedStatsBuckets())})
+		}
+
+		s.bucket = bucket
+	}
+
+	if value := query.Get("to"); value != "" {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, ErrValidation(FieldError{Field: "to", Message: "must be an RFC3339 time"})
+		}
+
+		s.to = to.UTC()
+	}
+
+	s.from = s.to.Add(-DefaultStatsRange)
+	if value := query.Get("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, ErrValidation(FieldError{Field: "from", Message: "must be an RFC3339 time"})
+		}
+
+		s.from = from.UTC()
+	}
+
+	if !s.from.Before(s.to) {
+		return nil, ErrValidation(FieldError{Field: "from", Message: "must be before `to`"})
+	}
+
+	s.from = s.truncate(s.from)
+
+	buckets := 0
+	for at := s.from; at.Before(s.to); at = s.next(at) {
+		buckets++
+		if buckets > MaxStatsBuckets {
+			return nil, ErrValidation(FieldError{Field: "bucket", Message: fmt.Sprintf("range spans more than %d buckets, use a larger bucket", MaxStatsBuckets)})
+		}
+	}
+
+	return s, nil
+}
+
+// Handler for the click stats of a link.
+// `{namespace}` is `-` for links without a namespace
+//
+// Supported query parameters:
+//   - from, to: RFC3339 range. defaults to the last 7 days
+//   - bucket: hour | day | week. defaults to day
+func (a *ApiHandler) HandleLinkStats(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseStatsRange(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	link, err := a.findLink(r, chi.URLParam(r, "namespace"), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, dbError(err, "link"))
+		return
+	}
+
+	if err := a.authorizeLink(r, link, linkr.ActionStatsRead); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	stats, err := a.clickStats(r.Context(), StatsFilter{LinkId: link.Id}, rng)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't retrieve the stats of link %d: %w", link.Id, err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "stats retrieved",
+		Details: stats,
+	})
+}
+
+// Handler for the click stats of all the links in a namespace.
+// Supports the query parameters of `HandleLinkStats`
+func (a *ApiHandler) HandleNamespaceStats(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseStatsRange(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ns, err := a.stores.Namespaces.GetByTag(r.Context(), chi.URLParam(r, "namespace"))
+	if err != nil {
+		writeError(w, r, dbError(err, "namespace"))
+		return
+	}
+
+	if err := a.authorizeNamespace(r, ns, linkr.ActionStatsRead); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	stats, err := a.clickStats(r.Context(), StatsFilter{NamespaceId: ns.Id}, rng)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't retrieve the stats of namespace %d: %w", ns.Id, err))
+		return
+	}
+
+	// top links, by their clicks over the range
+	topLinks, err := a.stores.Stats.TopLinks(r.Context(), StatsFilter{NamespaceId: ns.Id, From: rng.from, To: rng.to}, StatsTopLimit)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't retrieve the top links of namespace %d: %w", ns.Id, err))
+		return
+	}
+
+	stats.TopLinks = statsCounts(topLinks)
+
+	writeJSON(w, http.StatusOK, ResponseClientCreate{
+		Message: "stats retrieved",
+		Details: stats,
+	})
+}
+
+// Builds the stats of the clicks matching the filter, from the rollups.
+//
+// Clicks are counted by the hour, while visitors, referrers and agents
+// are counted by the day the range starts and ends in
+func (a *ApiHandler) clickStats(ctx context.Context, filter StatsFilter, rng *statsRange) (*ResponseClickStats, error) {
+	filter.From, filter.To = rng.from, rng.to
+
+	stats := &ResponseClickStats{
+		From:   rng.from.Format(time.RFC3339),
+		To:     rng.to.Format(time.RFC3339),
+		Bucket: rng.bucket,
+		Series: []ResponseStatsBucket{},
+	}
+
+	// hours are grouped into the buckets here, as sql can't truncate to weeks
+	hours, err := a.stores.Stats.HourlyClicks(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[time.Time]int64{}
+	for hour, clicks := range hours {
+		counts[rng.truncate(hour)] += clicks
+		stats.TotalClicks += clicks
+	}
+
+	for at := rng.from; at.Before(rng.to); at = rng.next(at) {
+		stats.Series = append(stats.Series, ResponseStatsBucket{Start: at.Format(time.RFC3339), Clicks: counts[at]})
+	}
+
+	stats.UniqueVisitors, err = a.stores.Stats.UniqueVisitors(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers, err := a.stores.Stats.TopReferrers(ctx, filter, StatsTopLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	agents, err := a.stores.Stats.TopAgents(ctx, filter, StatsTopLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TopReferrers = statsCounts(referrers)
+	stats.TopAgents = statsCounts(agents)
+	return stats, nil
+}
+
+func statsCounts(counts []ClickCount) []ResponseStatsCount {
+	res := make([]ResponseStatsCount, len(counts))
+	for i, c := range counts {
+		res[i] = ResponseStatsCount{Name: c.Name, Clicks: c.Clicks}
+	}
+
+	return res
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestClickStats(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag) VALUES ('d')`)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('one', 'https://dest.example', 2)`)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('two', 'https://dest.example', 2)`)
+
+	day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	insert := `INSERT INTO "Click" (link_id, namespace_id, clicked_at, referrer, user_agent, ip_hash) VALUES (?, 2, ?, ?, ?, ?)`
+	db.MustExec(insert, 1, day.Add(time.Hour), "https://news.example", "Mozilla/5.0 Firefox/126.0", "aaaa")
+	db.MustExec(insert, 1, day.Add(2*time.Hour), nil, "Mozilla/5.0 Firefox/126.0", "aaaa")
+	db.MustExec(insert, 1, day.Add(26*time.Hour), nil, "curl/8.4.0", "bbbb")
+	db.MustExec(insert, 2, day.Add(26*time.Hour), nil, nil, "cccc")
+	// outside of the range
+	db.MustExec(insert, 1, day.Add(-time.Hour), nil, nil, "dddd")
+
+	if _, err := NewClickRollup(NewSQLStatsStore(db), time.Hour, 100).RollupPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(asTestAdmin)
+	a := newTestApiHandler(db, dfNs)
+	r.Get("/links/{namespace}/{id}/stats", a.HandleLinkStats)
+	r.Get("/namespaces/{namespace}/stats", a.HandleNamespaceStats)
+
+	stats := func(path string) ResponseClickStats {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("retrieving '%s' failed with %d: %s", path, rec.Code, rec.Body.String())
+		}
+
+		res := ResponseClickStats{}
+		decodeDetails(t, rec, &res)
+		return res
+	}
+
+	link := stats("/links/d/one/stats?from=2024-05-06T00:00:00Z&to=2024-05-09T00:00:00Z")
+	if link.TotalClicks != 3 || link.UniqueVisitors != 2 {
+		t.Errorf("expected 3 clicks from 2 visitors, got %d from %d", link.TotalClicks, link.UniqueVisitors)
+	}
+
+	if len(link.Series) != 3 || link.Series[0].Clicks != 2 || link.Series[1].Clicks != 1 || link.Series[2].Clicks != 0 {
+		t.Errorf("unexpected series %+v", link.Series)
+	}
+
+	if len(link.TopReferrers) != 2 || link.TopReferrers[0] != (ResponseStatsCount{Name: "(direct)", Clicks: 2}) {
+		t.Errorf("unexpected referrers %+v", link.TopReferrers)
+	}
+
+	if len(link.TopAgents) != 2 || link.TopAgents[0] != (ResponseStatsCount{Name: "Firefox", Clicks: 2}) {
+		t.Errorf("unexpected agents %+v", link.TopAgents)
+	}
+
+	hourly := stats("/links/d/one/stats?from=2024-05-06T00:30:00Z&to=2024-05-06T03:00:00Z&bucket=hour")
+	if len(hourly.Series) != 3 || hourly.Series[0].Start != "2024-05-06T00:00:00Z" || hourly.Series[1].Clicks != 1 || hourly.Series[2].Clicks != 1 {
+		t.Errorf("unexpected hourly series %+v", hourly.Series)
+	}
+
+	weekly := stats("/links/d/one/stats?from=2024-05-01T00:00:00Z&to=2024-05-13T00:00:00Z&bucket=week")
+	if len(weekly.Series) != 2 || weekly.Series[0].Start != "2024-04-29T00:00:00Z" || weekly.Series[0].Clicks != 1 || weekly.Series[1].Clicks != 3 {
+		t.Errorf("unexpected weekly series %+v", weekly.Series)
+	}
+
+	ns := stats("/namespaces/d/stats?from=2024-05-06T00:00:00Z&to=2024-05-09T00:00:00Z")
+	if ns.TotalClicks != 4 || ns.UniqueVisitors != 3 {
+		t.Errorf("expected 4 clicks from 3 visitors, got %d from %d", ns.TotalClicks, ns.UniqueVisitors)
+	}
+
+	if len(ns.TopLinks) != 2 || ns.TopLinks[0] != (ResponseStatsCount{Name: "one", Clicks: 3}) {
+		t.Errorf("unexpected top links %+v", ns.TopLinks)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/links/d/missing/stats", http.StatusNotFound},
+		{"/namespaces/missing/stats", http.StatusNotFound},
+		{"/links/d/one/stats?bucket=month", http.StatusUnprocessableEntity},
+		{"/links/d/one/stats?from=yesterday", http.StatusUnprocessableEntity},
+		{"/links/d/one/stats?from=2024-05-09T00:00:00Z&to=2024-05-06T00:00:00Z", http.StatusUnprocessableEntity},
+		{"/links/d/one/stats?from=2020-01-01T00:00:00Z&to=2024-01-01T00:00:00Z&bucket=hour", http.StatusUnprocessableEntity},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("expected %d for '%s', got %d", tt.status, tt.path, rec.Code)
+		}
+	}
+}
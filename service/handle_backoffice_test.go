@@ -0,0 +1,283 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateLinkWithCustomIdentifier(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag) VALUES ('d')`)
+	a := newTestApiHandler(db, dfNs)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.HandleCreateLink(rec, withTestClient(httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(body)), testAdmin))
+		return rec
+	}
+
+	rec := create(`{"redirect_url": "https://examp.le/launch", "namespace": "d", "identifier": "launch2026"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	created := ResponseLinkCreate{}
+	decodeDetails(t, rec, &created)
+	if created.ShortenedUrl != "https://examp.le/d/launch2026" {
+		t.Errorf("got short url %s", created.ShortenedUrl)
+	}
+
+	// same identifier in another namespace is fine
+	if rec := create(`{"redirect_url": "https://examp.le/launch", "identifier": "launch2026"}`); rec.Code != http.StatusCreated {
+		t.Errorf("create in global namespace failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('launch2026-2', 'https://examp.le', (SELECT id FROM "Namespace" WHERE unique_tag = 'd'))`)
+
+	rec = create(`{"redirect_url": "https://examp.le/launch", "namespace": "d", "identifier": "launch2026", "suggest_alternatives": true}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected conflict, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	taken := ResponseIdentifierTaken{}
+	json.NewDecoder(rec.Body).Decode(&ResponseError{Error: &ApiError{Details: &taken}})
+	if len(taken.Suggestions) == 0 {
+		t.Error("expected suggestions")
+	}
+
+	for _, s := range taken.Suggestions {
+		if s == "launch2026" || s == "launch2026-2" {
+			t.Errorf("suggested taken identifier %s", s)
+		}
+	}
+
+	rec = create(`{"redirect_url": "https://examp.le/launch", "namespace": "d", "identifier": "launch2026"}`)
+	taken = ResponseIdentifierTaken{}
+	json.NewDecoder(rec.Body).Decode(&ResponseError{Error: &ApiError{Details: &taken}})
+	if rec.Code != http.StatusConflict || len(taken.Suggestions) != 0 {
+		t.Errorf("expected conflict without suggestions, got %d: %v", rec.Code, taken.Suggestions)
+	}
+
+	for _, identifier := range []string{"v1", "API", "no/slashes", "x"} {
+		rec := create(`{"redirect_url": "https://examp.le", "identifier": "` + identifier + `"}`)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected '%s' to be rejected, got %d", identifier, rec.Code)
+			continue
+		}
+
+		if fields := decodeError(t, rec).Fields; len(fields) != 1 || fields[0].Field != "identifier" {
+			t.Errorf("expected error on the identifier field, got %v", fields)
+		}
+	}
+}
+
+func TestCreateLinkWithIdStrategies(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag, id_strategy) VALUES ('b', 'base62'), ('h', 'hash'), ('w', 'words'), ('x', 'teleport')`)
+	a := newTestApiHandler(db, dfNs)
+
+	create := func(body string) (int, ResponseLinkCreate) {
+		rec := httptest.NewRecorder()
+		a.HandleCreateLink(rec, withTestClient(httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(body)), testAdmin))
+
+		created := ResponseLinkCreate{}
+		if rec.Code < 300 {
+			decodeDetails(t, rec, &created)
+		}
+
+		return rec.Code, created
+	}
+
+	if _, created := create(`{"redirect_url": "https://examp.le/1", "namespace": "b"}`); created.Identifier != "1" {
+		t.Errorf("expected first base62 identifier to be 1, got %s", created.Identifier)
+	}
+
+	// collides with the next value of the counter
+	create(`{"redirect_url": "https://examp.le/2", "namespace": "b", "identifier": "222"}`)
+	db.MustExec(`UPDATE "IdCounter" SET value = ? WHERE namespace_id = (SELECT id FROM "Namespace" WHERE unique_tag = 'b')`, 2*62*62+2*62+2-1)
+	if _, created := create(`{"redirect_url": "https://examp.le/3", "namespace": "b"}`); created.Identifier != "223" {
+		t.Errorf("expected collision to be retried with 223, got %s", created.Identifier)
+	}
+
+	status, first := create(`{"redirect_url": "https://examp.le/same", "namespace": "h"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("hash link creation failed with %d", status)
+	}
+
+	status, second := create(`{"redirect_url": "https://examp.le/same", "namespace": "h"}`)
+	if status != http.StatusOK || second.Identifier != first.Identifier || second.ShortenedUrl != "https://examp.le/h/"+first.Identifier {
+		t.Errorf("expected existing link %s, got %d %+v", first.Identifier, status, second)
+	}
+
+	// the existing link isn't handed out for other settings
+	for _, body := range []string{
+		`{"redirect_url": "https://examp.le/same", "namespace": "h", "expires_in": "1h"}`,
+		`{"redirect_url": "https://examp.le/same", "namespace": "h", "forward_mode": "query"}`,
+	} {
+		if status, _ := create(body); status != http.StatusConflict {
+			t.Errorf("%s: expected a conflict, got %d", body, status)
+		}
+	}
+
+	status, expiring := create(`{"redirect_url": "https://examp.le/soon", "namespace": "h", "expires_in": "1m"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("hash link creation failed with %d", status)
+	}
+
+	if status, again := create(`{"redirect_url": "https://examp.le/soon", "namespace": "h", "expires_in": "1m"}`); status != http.StatusOK || again.Identifier != expiring.Identifier {
+		t.Errorf("expected the link requested along with it, got %d %+v", status, again)
+	}
+
+	// about to expire, so it doesn't last the minute requested
+	db.MustExec(`UPDATE "Link" SET expires_at = ? WHERE identifier = ?`, time.Now().UTC().Add(time.Second), expiring.Identifier)
+	if status, _ := create(`{"redirect_url": "https://examp.le/soon", "namespace": "h", "expires_in": "1m"}`); status != http.StatusConflict {
+		t.Errorf("expected a conflict with the link about to expire, got %d", status)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"redirect_url": "https://examp.le/same", "namespace": "h"}`))
+	req.Header.Set(LinkrHeaderPrefix+"-Campaign", "spring")
+	rec := httptest.NewRecorder()
+	a.HandleCreateLink(rec, withTestClient(req, testAdmin))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected a conflict for other forwarded headers, got %d", rec.Code)
+	}
+
+	// taken by a link to another url
+	db.MustExec(`UPDATE "Link" SET destination_url = 'https://examp.le/moved' WHERE identifier = ?`, first.Identifier)
+	if status, third := create(`{"redirect_url": "https://examp.le/same", "namespace": "h"}`); status != http.StatusCreated || third.Identifier == first.Identifier {
+		t.Errorf("expected a new salted identifier, got %d %s", status, third.Identifier)
+	}
+
+	if _, created := create(`{"redirect_url": "https://examp.le", "namespace": "w"}`); !strings.Contains(created.Identifier, "-") {
+		t.Errorf("expected word pair identifier, got %s", created.Identifier)
+	}
+
+	if status, _ := create(`{"redirect_url": "https://examp.le", "namespace": "x"}`); status != http.StatusInternalServerError {
+		t.Errorf("expected unknown strategy to fail, got %d", status)
+	}
+}
+
+func TestCreateLinkValidation(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+
+	tests := []struct {
+		body   string
+		status int
+		fields []string
+	}{
+		{``, http.StatusBadRequest, nil},
+		{`{"redirect_url": `, http.StatusBadRequest, nil},
+		{`{"redirect_url": 12}`, http.StatusUnprocessableEntity, []string{"redirect_url"}},
+		{`{}`, http.StatusUnprocessableEntity, []string{"redirect_url"}},
+		{`{"namespace": "-", "expires_in": "12y", "forward_mode": "teleport"}`, http.StatusUnprocessableEntity, []string{"redirect_url", "namespace", "expires_in", "forward_mode"}},
+		// wouldn't fit the seconds stored with the link
+		{`{"redirect_url": "https://examp.le", "expires_in": "24856d"}`, http.StatusUnprocessableEntity, []string{"expires_in"}},
+		{`{"redirect_url": "https://examp.le", "expires_in": "9999999999999d"}`, http.StatusUnprocessableEntity, []string{"expires_in"}},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		a.HandleCreateLink(rec, withTestClient(httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(tt.body)), testAdmin))
+
+		if rec.Code != tt.status {
+			t.Errorf("'%s' got %d, want %d", tt.body, rec.Code, tt.status)
+			continue
+		}
+
+		fields := []string{}
+		for _, f := range decodeError(t, rec).Fields {
+			fields = append(fields, f.Field)
+		}
+
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("'%s' got errors on %v, want %v", tt.body, fields, tt.fields)
+		}
+	}
+}
+
+func TestCreateClientDuplicateUsername(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.HandleCreateClient(rec, withTestClient(httptest.NewRequest(http.MethodPost, "/client/create", strings.NewReader(body)), testAdmin))
+		return rec
+	}
+
+	if rec := create(`{"username": "marketing", "role": "read-write"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := create(`{"username": "marketing", "role": "read-write"}`)
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Code != ErrCodeConflict {
+		t.Errorf("expected conflict for duplicate username, got %d", rec.Code)
+	}
+
+	if rec := create(`{"role": "read-write"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected missing username to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestCreateClientValidation(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	a := newTestApiHandler(db, dfNs)
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_taken', 'taken', 'links:read', 'key', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`)
+
+	// can manage clients, but not take every action
+	manager := &LinkrClient{Id: "api_manager", Scope: "clients:manage,links:create,links:read"}
+
+	tests := []struct {
+		name    string
+		creator *LinkrClient
+		body    string
+		status  int
+		field   string
+		scope   string
+	}{
+		{"default role", testAdmin, `{"username": "writer"}`, http.StatusCreated, "", "links:create,links:delete"},
+		{"known role", testAdmin, `{"username": "reader", "role": "read-only"}`, http.StatusCreated, "", "links:read,stats:read"},
+		{"unknown role", testAdmin, `{"username": "super", "role": "superuser"}`, http.StatusUnprocessableEntity, "role", ""},
+		{"short username", testAdmin, `{"username": "ab"}`, http.StatusUnprocessableEntity, "username", ""},
+		{"long username", testAdmin, `{"username": "` + strings.Repeat("a", 33) + `"}`, http.StatusUnprocessableEntity, "username", ""},
+		{"username with spaces", testAdmin, `{"username": "my bot"}`, http.StatusUnprocessableEntity, "username", ""},
+		{"username starting with a digit", testAdmin, `{"username": "1bot"}`, http.StatusUnprocessableEntity, "username", ""},
+		{"duplicate username", testAdmin, `{"username": "taken"}`, http.StatusConflict, "", ""},
+		{"delegated within scope", manager, `{"username": "helper", "scope": ["links:read"]}`, http.StatusCreated, "", "links:read"},
+		{"delegated admin", manager, `{"username": "usurper", "role": "admin"}`, http.StatusForbidden, "", ""},
+		{"delegated beyond scope", manager, `{"username": "cleaner", "scope": ["links:delete"]}`, http.StatusForbidden, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			a.HandleCreateClient(rec, withTestClient(httptest.NewRequest(http.MethodPost, "/client/create", strings.NewReader(tt.body)), tt.creator))
+
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			if tt.field != "" {
+				if fields := decodeError(t, rec).Fields; len(fields) != 1 || fields[0].Field != tt.field {
+					t.Errorf("expected error on the %s field, got %v", tt.field, fields)
+				}
+			}
+
+			if tt.scope != "" {
+				created := struct {
+					Scope string `json:"scope"`
+				}{}
+				decodeDetails(t, rec, &created)
+
+				if created.Scope != tt.scope {
+					t.Errorf("got scope %s, want %s", created.Scope, tt.scope)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Responsible for issuing the access tokens
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// [Must be used under `MiddlewareGated`]
+// Handler issuing an access token to the client. Only signed requests
+// can get tokens, so a leaked token can't be used to renew itself
+func (cc *CommandCenter) HandleIssueToken(w http.ResponseWriter, r *http.Request) {
+	client, _ := r.Context().Value(CtxLinkrClient).(*LinkrClient)
+	if client == nil {
+		writeError(w, r, fmt.Errorf("user entity is not attached as part of the request"))
+		return
+	}
+
+	if scheme, _ := r.Context().Value(CtxAuthScheme).(string); scheme != AuthSchemeDigest {
+		writeError(w, r, ErrForbidden("access tokens can only be requested with a signed request"))
+		return
+	}
+
+	token, expiresAt, err := cc.tokens.Issue(client)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("couldn't issue access token: %w", err))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ResponseClientCreate{
+		Message: "access token issued",
+		Details: ResponseAccessToken{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(time.Until(expiresAt).Round(time.Second).Seconds()),
+			ExpiresAt:   expiresAt.UTC().Format(time.RFC3339),
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAccessTokens(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	key, _ := generateSigningKey()
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_1', 'bot', 'read-only', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, key)
+
+	tokens := NewTokenIssuer([]byte("secret"), time.Minute)
+	cc := NewCommandCenter(NewSQLClientStore(db), NewMemoryNonceStore(), DefaultDigestMaxAge, tokens, nil, nil)
+
+	r := chi.NewRouter()
+	r.Use(cc.MiddlewareGated)
+	r.Post("/v1/api/token", cc.HandleIssueToken)
+	r.Get("/v1/api/links", func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CtxLinkrClient).(*LinkrClient)
+		w.Write([]byte(client.Id + ":" + client.Scope))
+	})
+
+	withBearer := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/api/token", nil)
+	signTestRequest(t, req, "api_1", key, "")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("issuing failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	res := ResponseAccessToken{}
+	decodeDetails(t, rec, &res)
+	if res.TokenType != "Bearer" || res.ExpiresIn != 60 || res.AccessToken == "" {
+		t.Fatalf("unexpected token %+v", res)
+	}
+
+	if rec := withBearer(http.MethodGet, "/v1/api/links", res.AccessToken); rec.Code != http.StatusOK || rec.Body.String() != "api_1:read-only" {
+		t.Errorf("expected the token to authenticate the client, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := withBearer(http.MethodPost, "/v1/api/token", res.AccessToken); rec.Code != http.StatusForbidden {
+		t.Errorf("expected tokens not to renew themselves, got %d", rec.Code)
+	}
+
+	expired := NewTokenIssuer([]byte("secret"), time.Minute)
+	expired.now = func() time.Time { return time.Now().Add(-2 * time.Minute) }
+	expiredToken, _, _ := expired.Issue(&LinkrClient{Id: "api_1", Scope: "read-only"})
+
+	forged, _, _ := NewTokenIssuer([]byte("other"), time.Minute).Issue(&LinkrClient{Id: "api_1", Scope: "admin"})
+	removed, _, _ := tokens.Issue(&LinkrClient{Id: "api_removed", Scope: "admin"})
+
+	for name, token := range map[string]string{
+		"expired":        expiredToken,
+		"forged":         forged,
+		"removed client": removed,
+		"malformed":      "not-a-token",
+	} {
+		rec := withBearer(http.MethodGet, "/v1/api/links", token)
+		if rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != ErrCodeUnauthenticated {
+			t.Errorf("%s: expected the token to be rejected, got %d", name, rec.Code)
+		}
+
+		if got := rec.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, "Bearer") {
+			t.Errorf("%s: expected the client to be asked for a bearer token, got '%s'", name, got)
+		}
+	}
+}
@@ -4,32 +4,117 @@ package service
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"log/slog"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
 	"time"
 
 	linkr "iam-kevin/linkr/pkg"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jmoiron/sqlx"
 )
 
 type LinkrNamespace struct {
 	Id          int64          `db:"id"`
 	Tag         string         `db:"unique_tag"`
 	Description sql.NullString `db:"desc"`
+	// where to send visitors of expired links in this namespace.
+	// when not set, expired links respond with 410 Gone
+	ExpiredUrl sql.NullString `db:"expired_url"`
+	// how identifiers of links in this namespace are generated.
+	// see `linkr.SupportedIdStrategies`
+	IdStrategy sql.NullString `db:"id_strategy"`
+	// comma separated hosts links in this namespace can point to.
+	// any host is allowed when not set
+	AllowHosts sql.NullString `db:"allow_hosts"`
+	// comma separated hosts links in this namespace can't point to
+	DenyHosts sql.NullString `db:"deny_hosts"`
+	// client the namespace belongs to
+	OwnerId sql.NullString `db:"owner_id"`
+	// when the namespace was archived. links of archived
+	// namespaces are handled as expired
+	ArchivedAt sql.NullTime `db:"archived_at"`
 }
 
 type LinkHandler struct {
-	db   *sqlx.DB
-	dfNs *LinkrNamespace
+	stores *Stores
+	dfNs   *LinkrNamespace
+
+	// clock used to check link expiry
+	now func() time.Time
+
+	// client used to fetch destinations of links
+	// that forward headers by proxy
+	client *http.Client
+
+	// records the visits. clicks aren't recorded when nil
+	clicks *ClickRecorder
+
+	// counts the outcomes of the redirects. optional
+	metrics *Metrics
+
+	// checks the destinations again before the proxy fetches them,
+	// as the policy may have changed since the link was saved. optional
+	policy *URLPolicy
 }
 
-func NewLinkHandler(db *sqlx.DB, defaultNs *LinkrNamespace) *LinkHandler {
+func NewLinkHandler(stores *Stores, defaultNs *LinkrNamespace, clicks *ClickRecorder, metrics *Metrics, policy *URLPolicy) *LinkHandler {
 	return &LinkHandler{
-		db:   db,
-		dfNs: defaultNs,
+		stores:  stores,
+		dfNs:    defaultNs,
+		clicks:  clicks,
+		metrics: metrics,
+		policy:  policy,
+		now:     time.Now,
+		client:  newProxyClient(false),
+	}
+}
+
+// largest response of a destination relayed by the proxy
+const MaxProxiedBodySize = 10 << 20
+
+var errPrivateAddress = errors.New("destination resolves to a private address")
+
+// Client fetching the destinations of the links forwarding headers by proxy.
+// Redirects aren't followed, but relayed to the visitor, and unless
+// `allowPrivate`, addresses are checked once resolved, so a host can't
+// point to private, loopback or link-local addresses at the time of the visit
+func newProxyClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+
+			return nil
+		}
+	}
+
+	return &http.Client{
+		// the server's write timeout cuts slower responses anyway
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			// a proxy of the environment would be dialed instead of the destination
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 2 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
 }
 
@@ -42,6 +127,17 @@ type Link struct {
 	ExpiresIn         sql.NullInt32  `db:"expires_in"`
 	CreatedAt         time.Time      `db:"created_at"`
 	SerializedHeaders sql.NullString `db:"headers"`
+	ForwardMode       sql.NullString `db:"forward_mode"`
+}
+
+// checks if the link is no longer usable at time `at`.
+// a link expires the moment `at` reaches `ExpiresAt`
+func (l *Link) IsExpiredAt(at time.Time) bool {
+	if !l.ExpiresAt.Valid {
+		return false
+	}
+
+	return !at.Before(l.ExpiresAt.Time)
 }
 
 // redirect to the page
@@ -50,23 +146,13 @@ func (l *LinkHandler) HandleRedirectShortenedLink(w http.ResponseWriter, r *http
 	id := chi.URLParam(r, "id")
 
 	// check if such a thing exists
-	link := new(Link)
-	err := l.db.Get(link, `SELECT * FROM "Link" WHERE identifier = ? AND namespace_id = ?`, id, l.dfNs.Id)
+	link, err := l.stores.Links.Get(r.Context(), l.dfNs.Id, id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("couldn't retrieve that: %s", err.Error()), http.StatusNotFound)
+		l.failLookup(w, r, err)
 		return
 	}
 
-	// TODO: deserialize the header
-
-	req, err := http.NewRequest(http.MethodGet, link.OriginalUrl, nil)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("couldn't create the request : %s", err.Error()), http.StatusNotFound)
-		return
-
-	}
-
-	http.Redirect(w, req, link.OriginalUrl, http.StatusTemporaryRedirect)
+	l.redirect(w, r, l.dfNs, link)
 }
 
 // redirect to the page
@@ -76,37 +162,183 @@ func (l *LinkHandler) HandleRedirectShortenedLinkWithNamespace(w http.ResponseWr
 	namespace := chi.URLParam(r, "namespace")
 
 	if namespace == linkr.ReservedGlobalChar {
-		http.Error(w, "invalid or unsupported namespace", http.StatusBadRequest)
+		l.metrics.redirect(RedirectMiss)
+		writeError(w, r, ErrBadRequest("invalid or unsupported namespace"))
 		return
 	}
 
 	// get namespace
-	ns := new(LinkrNamespace)
-	err := l.db.Get(ns, `SELECT * FROM "Namespace" where unique_tag = ?`, namespace)
+	ns, err := l.stores.Namespaces.GetByTag(r.Context(), namespace)
 	if err != nil {
-		slog.Error(err.Error())
-		http.Error(w, "url not found", http.StatusNotFound)
+		l.failLookup(w, r, err)
 		return
 	}
 
 	// check if such a thing exists
-	link := new(Link)
-	err = l.db.Get(link, `SELECT * FROM "Link" WHERE identifier = ? AND namespace_id = ?`, id, ns.Id)
+	link, err := l.stores.Links.Get(r.Context(), ns.Id, id)
+	if err != nil {
+		l.failLookup(w, r, err)
+		return
+	}
+
+	l.redirect(w, r, ns, link)
+}
+
+// responds to a lookup of the link, or its namespace, that failed
+func (l *LinkHandler) failLookup(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrRecordNotFound) || errors.Is(err, sql.ErrNoRows) {
+		l.metrics.redirect(RedirectMiss)
+	} else {
+		l.metrics.redirect(RedirectError)
+	}
+
+	writeError(w, r, dbError(err, "url"))
+}
+
+// sends the visitor to the destination of `link`, unless the link
+// has expired or its namespace was archived, in which case the
+// namespace decides where they land
+func (l *LinkHandler) redirect(w http.ResponseWriter, r *http.Request, ns *LinkrNamespace, link *Link) {
+	if link.IsExpiredAt(l.now()) || ns.ArchivedAt.Valid {
+		l.metrics.redirect(RedirectExpired)
+		if ns.ExpiredUrl.Valid && ns.ExpiredUrl.String != "" {
+			http.Redirect(w, r, ns.ExpiredUrl.String, http.StatusFound)
+			return
+		}
+
+		writeError(w, r, ErrGone("link expired"))
+		return
+	}
+
+	// only the modes forwarding the headers need them, so links
+	// that don't aren't broken by headers that can't be restored
+	var headers http.Header
+	if link.ForwardMode.String == ForwardModeQuery || link.ForwardMode.String == ForwardModeProxy {
+		decoded, err := DecodeForwardHeaders(link.SerializedHeaders.String)
+		if err != nil {
+			l.metrics.redirect(RedirectError)
+			writeError(w, r, fmt.Errorf("couldn't restore the headers of link %d: %w", link.Id, err))
+			return
+		}
+
+		headers = decoded
+	}
+
+	l.metrics.redirect(RedirectHit)
+	if l.clicks != nil {
+		l.clicks.Record(l.clicks.EventFromRequest(r, link, l.now()))
+	}
+
+	switch link.ForwardMode.String {
+	case ForwardModeQuery:
+		destination, err := withHeadersAsQuery(link.OriginalUrl, headers)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("couldn't build the destination of link %d: %w", link.Id, err))
+			return
+		}
+
+		http.Redirect(w, r, destination, http.StatusTemporaryRedirect)
+	case ForwardModeProxy:
+		l.proxy(w, r, ns, link, headers)
+	default:
+		http.Redirect(w, r, link.OriginalUrl, http.StatusTemporaryRedirect)
+	}
+}
+
+// Appends the forwarded headers to the query of `destination`.
+// `Linkr-Forward-Campaign: spring` becomes `?campaign=spring`.
+//
+// The values are visible to the visitor, and end up in the logs of the
+// destination and the history of the browser, which is why the api
+// refuses query mode for headers that look like credentials
+// (see `credentialHeaders`). links saved before are redirected as they are
+func withHeadersAsQuery(destination string, headers http.Header) (string, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for k, vs := range headers {
+		for _, v := range vs {
+			query.Add(strings.ToLower(k), v)
+		}
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// headers describing the connection between two hops,
+// which shouldn't be relayed by the proxy
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	// cookies of the destination don't belong to linkr's domain
+	"Set-Cookie",
+}
+
+// fetches the destination of the link with the forwarded headers
+// and relays the response to the visitor
+func (l *LinkHandler) proxy(w http.ResponseWriter, r *http.Request, ns *LinkrNamespace, link *Link, headers http.Header) {
+	if l.policy != nil {
+		if _, err := l.policy.ForNamespace(ns).Check(r.Context(), "destination_url", link.OriginalUrl); err != nil {
+			RequestLogger(r).Warn(fmt.Sprintf("refused to fetch the destination of link %d: %s", link.Id, err.Error()))
+			writeError(w, r, ErrBadGateway("destination is not allowed"))
+			return
+		}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, link.OriginalUrl, nil)
 	if err != nil {
-		slog.Error(fmt.Sprintf("couldn't retrieve that: %s", err.Error()))
-		http.Error(w, "url not found", http.StatusNotFound)
+		writeError(w, r, fmt.Errorf("couldn't create the request of link %d: %w", link.Id, err))
 		return
 	}
 
-	// TODO: deserialize the header
+	req.Header = headers
+	if accept := r.Header.Get("Accept"); accept != "" && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", accept)
+	}
 
-	req, err := http.NewRequest(http.MethodGet, link.OriginalUrl, nil)
+	res, err := l.client.Do(req)
 	if err != nil {
-		slog.Error(fmt.Sprintf("couldn't create the request : %s", err.Error()))
-		http.Error(w, "url not found", http.StatusNotFound)
+		RequestLogger(r).Error(fmt.Sprintf("couldn't reach the destination of link %d: %s", link.Id, err.Error()))
+		writeError(w, r, ErrBadGateway("couldn't reach the destination"))
 		return
+	}
+	defer res.Body.Close()
 
+	// relaying the declared length while cutting the body would
+	// leave the visitor waiting for bytes that never come
+	if res.ContentLength > MaxProxiedBodySize {
+		RequestLogger(r).Warn(fmt.Sprintf("destination of link %d responded %d bytes, over the %d relayed", link.Id, res.ContentLength, MaxProxiedBodySize))
+		writeError(w, r, ErrBadGateway("destination response is too large"))
+		return
+	}
+
+	for k, vs := range res.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+
+	for _, h := range hopByHopHeaders {
+		w.Header().Del(h)
+	}
+
+	// the body is only known to fit when its length was declared
+	if res.ContentLength < 0 {
+		w.Header().Del("Content-Length")
 	}
 
-	http.Redirect(w, req, link.OriginalUrl, http.StatusTemporaryRedirect)
+	w.WriteHeader(res.StatusCode)
+	if _, err := io.Copy(w, io.LimitReader(res.Body, MaxProxiedBodySize)); err != nil {
+		RequestLogger(r).Error(fmt.Sprintf("couldn't relay the destination of link %d: %s", link.Id, err.Error()))
+	}
 }
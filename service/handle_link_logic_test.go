@@ -0,0 +1,194 @@
+package service
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newTestLinkRouter(l *LinkHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/{namespace}/{id}", l.HandleRedirectShortenedLinkWithNamespace)
+	r.Get("/{id}", l.HandleRedirectShortenedLink)
+	return r
+}
+
+func TestRedirectExpiry(t *testing.T) {
+	db, dfNs := newTestDB(t)
+
+	expiresAt := time.Date(2024, 5, 9, 2, 9, 42, 0, time.UTC)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag, expired_url) VALUES ('d', 'https://examp.le/expired')`)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_in, expires_at) VALUES ('abc', 'https://dest.example', ?, 60, ?)`, dfNs.Id, expiresAt)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_in, expires_at) VALUES ('abc', 'https://dest.example', (SELECT id FROM "Namespace" WHERE unique_tag = 'd'), 60, ?)`, expiresAt)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('forever', 'https://dest.example', ?)`, dfNs.Id)
+
+	tests := []struct {
+		name     string
+		path     string
+		now      time.Time
+		status   int
+		location string
+	}{
+		{"unknown link", "/nope", expiresAt, http.StatusNotFound, ""},
+		{"before expiry", "/abc", expiresAt.Add(-time.Nanosecond), http.StatusTemporaryRedirect, "https://dest.example"},
+		{"at expiry", "/abc", expiresAt, http.StatusGone, ""},
+		{"after expiry", "/abc", expiresAt.Add(time.Hour), http.StatusGone, ""},
+		{"expiry in another timezone", "/abc", expiresAt.In(time.FixedZone("EAT", 3*60*60)), http.StatusGone, ""},
+		{"without expiry", "/forever", expiresAt.AddDate(100, 0, 0), http.StatusTemporaryRedirect, "https://dest.example"},
+		{"namespaced before expiry", "/d/abc", expiresAt.Add(-time.Second), http.StatusTemporaryRedirect, "https://dest.example"},
+		{"namespaced expired landing", "/d/abc", expiresAt, http.StatusFound, "https://examp.le/expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLinkHandler(NewSQLStores(db), dfNs, nil, nil, nil)
+			l.now = func() time.Time { return tt.now }
+
+			rec := httptest.NewRecorder()
+			newTestLinkRouter(l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("got location '%s', want '%s'", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestRedirectForwardModes(t *testing.T) {
+	db, dfNs := newTestDB(t)
+
+	var received http.Header
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Set-Cookie", "session=destination")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("proxied"))
+	}))
+	defer destination.Close()
+
+	headers := EncodeForwardHeaders(http.Header{"Super-Secret": {"a;b=c"}})
+	insert := `INSERT INTO "Link" (identifier, destination_url, namespace_id, headers, forward_mode) VALUES (?, ?, ?, ?, ?)`
+	db.MustExec(insert, "none", destination.URL+"?x=1", dfNs.Id, headers, ForwardModeNone)
+	db.MustExec(insert, "query", destination.URL+"?x=1", dfNs.Id, headers, ForwardModeQuery)
+	db.MustExec(insert, "proxy", destination.URL, dfNs.Id, headers, ForwardModeProxy)
+	db.MustExec(insert, "legacy", destination.URL, dfNs.Id, ";Super-Secret=2313", ForwardModeQuery)
+	db.MustExec(insert, "corrupt", destination.URL, dfNs.Id, "%zz", ForwardModeNone)
+	db.MustExec(insert, "corrupt-query", destination.URL, dfNs.Id, "%zz", ForwardModeQuery)
+
+	l := NewLinkHandler(NewSQLStores(db), dfNs, nil, nil, nil)
+	// the destination listens on loopback
+	l.client = newProxyClient(true)
+	router := newTestLinkRouter(l)
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := serve("/none"); rec.Header().Get("Location") != destination.URL+"?x=1" {
+		t.Errorf("plain redirect changed the destination: %s", rec.Header().Get("Location"))
+	}
+
+	if rec := serve("/query"); rec.Header().Get("Location") != destination.URL+"?super-secret=a%3Bb%3Dc&x=1" {
+		t.Errorf("headers not appended as query: %s", rec.Header().Get("Location"))
+	}
+
+	if rec := serve("/legacy"); rec.Header().Get("Location") != destination.URL+"?super-secret=2313" {
+		t.Errorf("legacy headers not appended as query: %s", rec.Header().Get("Location"))
+	}
+
+	// the headers aren't needed without forwarding them
+	if rec := serve("/corrupt"); rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != destination.URL {
+		t.Errorf("expected links not forwarding headers to redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if rec := serve("/corrupt-query"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected headers that can't be restored to fail the forwarding, got %d", rec.Code)
+	}
+
+	rec := serve("/proxy")
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "proxied" {
+		t.Errorf("response not relayed. got %d '%s'", rec.Code, rec.Body.String())
+	}
+
+	if received.Get("Super-Secret") != "a;b=c" {
+		t.Errorf("destination didn't receive the forwarded header: %v", received)
+	}
+
+	if rec.Header().Get("Set-Cookie") != "" {
+		t.Error("destination cookies should not be relayed")
+	}
+}
+
+func TestProxyGuards(t *testing.T) {
+	db, dfNs := newTestDB(t)
+
+	internal := 0
+	destination := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/internal", http.StatusFound)
+		case "/internal":
+			internal++
+			w.Write([]byte("internal"))
+		case "/large":
+			w.Write(make([]byte, MaxProxiedBodySize+1024))
+		case "/declared":
+			w.Header().Set("Content-Length", strconv.Itoa(MaxProxiedBodySize+1024))
+			w.Write(make([]byte, MaxProxiedBodySize+1024))
+		}
+	}))
+	defer destination.Close()
+
+	insert := `INSERT INTO "Link" (identifier, destination_url, namespace_id, forward_mode) VALUES (?, ?, ?, ?)`
+	db.MustExec(insert, "internal", destination.URL+"/internal", dfNs.Id, ForwardModeProxy)
+	db.MustExec(insert, "moved", destination.URL+"/moved", dfNs.Id, ForwardModeProxy)
+	db.MustExec(insert, "large", destination.URL+"/large", dfNs.Id, ForwardModeProxy)
+	db.MustExec(insert, "declared", destination.URL+"/declared", dfNs.Id, ForwardModeProxy)
+
+	serve := func(l *LinkHandler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		newTestLinkRouter(l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := serve(NewLinkHandler(NewSQLStores(db), dfNs, nil, nil, nil), "/internal"); rec.Code != http.StatusBadGateway || internal != 0 {
+		t.Errorf("expected loopback destinations to be refused, got %d", rec.Code)
+	}
+
+	l := NewLinkHandler(NewSQLStores(db), dfNs, nil, nil, nil)
+	l.client = newProxyClient(true)
+
+	rec := serve(l, "/moved")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/internal" || internal != 0 {
+		t.Errorf("expected the redirect to be relayed, not followed. got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if rec := serve(l, "/large"); rec.Body.Len() != MaxProxiedBodySize || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("expected the body to be cut at %d bytes, without a length, got %d %s", MaxProxiedBodySize, rec.Body.Len(), rec.Header().Get("Content-Length"))
+	}
+
+	if rec := serve(l, "/declared"); rec.Code != http.StatusBadGateway || rec.Header().Get("Content-Length") == strconv.Itoa(MaxProxiedBodySize+1024) {
+		t.Errorf("expected a destination declaring a larger body to be refused, got %d", rec.Code)
+	}
+
+	// hosts denied since the link was saved aren't fetched
+	denying := *dfNs
+	denying.DenyHosts = sql.NullString{String: "127.0.0.1", Valid: true}
+
+	l = NewLinkHandler(NewSQLStores(db), &denying, nil, nil, &URLPolicy{AllowedSchemes: []string{"http"}})
+	l.client = newProxyClient(true)
+
+	if rec := serve(l, "/internal"); rec.Code != http.StatusBadGateway || internal != 0 {
+		t.Errorf("expected the denied destination to be refused, got %d", rec.Code)
+	}
+}
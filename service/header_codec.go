@@ -0,0 +1,130 @@
+// Serialization of the headers forwarded along with a shortened link
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// headers are dropped, visitor is simply redirected
+	ForwardModeNone = "none"
+	// headers are appended to the destination url as query parameters
+	ForwardModeQuery = "query"
+	// linkr fetches the destination with the headers and relays the response
+	ForwardModeProxy = "proxy"
+)
+
+// Retrieve the list of supported header forwarding modes
+func SupportedForwardModes() []string {
+	return []string{ForwardModeNone, ForwardModeQuery, ForwardModeProxy}
+}
+
+// parts of the names of headers that look like they carry credentials.
+// in query mode, their values would end up in the url of the destination,
+// its logs and the history of the visitor's browser
+var credentialHeaderParts = []string{"auth", "token", "secret", "password", "passwd", "key", "cookie", "session", "credential", "signature", "jwt", "bearer"}
+
+// names of the headers that look like they carry credentials
+func credentialHeaders(headers http.Header) []string {
+	names := []string{}
+	for k := range headers {
+		lower := strings.ToLower(k)
+		for _, part := range credentialHeaderParts {
+			if strings.Contains(lower, part) {
+				names = append(names, lower)
+				break
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// refuses query mode for links forwarding headers that look like credentials
+func checkForwardMode(mode string, serializedHeaders string) error {
+	if mode != ForwardModeQuery {
+		return nil
+	}
+
+	headers, err := DecodeForwardHeaders(serializedHeaders)
+	if err != nil {
+		return err
+	}
+
+	if names := credentialHeaders(headers); len(names) > 0 {
+		return ErrValidation(FieldError{
+			Field:   "forward_mode",
+			Message: fmt.Sprintf("query would put %v in the url of the destination. use %s to forward credentials", names, ForwardModeProxy),
+		})
+	}
+
+	return nil
+}
+
+// Encodes the headers to be stored along with a link.
+//
+// Headers are stored url encoded (k1=v11&k1=v12&k2=v21) so that
+// values containing `;`, `=` or `,` survive the round trip
+func EncodeForwardHeaders(headers http.Header) string {
+	values := make(url.Values, len(headers))
+	for k, vs := range headers {
+		key := http.CanonicalHeaderKey(k)
+		for _, v := range vs {
+			values.Add(key, v)
+		}
+	}
+
+	return values.Encode()
+}
+
+// Restores headers stored with `EncodeForwardHeaders`.
+//
+// Also understands the legacy format (k1=v11,v12;k2=v21,v22)
+// that links were stored with at first
+func DecodeForwardHeaders(serialized string) (http.Header, error) {
+	headers := make(http.Header)
+	if serialized == "" {
+		return headers, nil
+	}
+
+	values, err := url.ParseQuery(serialized)
+	if err != nil {
+		if strings.Contains(serialized, ";") {
+			return decodeLegacyForwardHeaders(serialized)
+		}
+
+		return nil, fmt.Errorf("malformed forward headers: %w", err)
+	}
+
+	for k, vs := range values {
+		for _, v := range vs {
+			headers.Add(k, v)
+		}
+	}
+
+	return headers, nil
+}
+
+func decodeLegacyForwardHeaders(serialized string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, entry := range strings.Split(serialized, ";") {
+		// the legacy serializer prepended empty entries
+		if entry == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(entry, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("malformed legacy forward header entry '%s'", entry)
+		}
+
+		headers.Add(k, v)
+	}
+
+	return headers, nil
+}
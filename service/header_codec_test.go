@@ -0,0 +1,63 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestForwardHeadersRoundTrip(t *testing.T) {
+	headers := http.Header{
+		"Super-Secret": {"a=b;c=d"},
+		"Multi":        {"one, two", "three"},
+		"Emoji":        {"🔗 & friends"},
+	}
+
+	got, err := DecodeForwardHeaders(EncodeForwardHeaders(headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, headers) {
+		t.Errorf("headers changed in round trip. got %v, want %v", got, headers)
+	}
+}
+
+func TestDecodeLegacyForwardHeaders(t *testing.T) {
+	got, err := DecodeForwardHeaders(";Super-Secret=2313;Trace=a,b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := http.Header{
+		"Super-Secret": {"2313"},
+		"Trace":        {"a,b"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExtractHeadersToForward(t *testing.T) {
+	headers := http.Header{
+		"Linkr-Forward-Super-Secret": {"x;y=z"},
+		"Linkr-Forward":              {"no suffix"},
+		"Authorization":              {"Bearer nope"},
+	}
+
+	serialized := extractHeadersToForward(headers)
+	if serialized == nil {
+		t.Fatal("expected headers to be extracted")
+	}
+
+	got, err := DecodeForwardHeaders(*serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := http.Header{"Super-Secret": {"x;y=z"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -1,10 +1,118 @@
 package service
 
+import (
+	"fmt"
+	"strings"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
 type RequestClientCreate struct {
-	Username string `json:"username"`
+	Username    string `json:"username" validate:"required"`
+	Description string `json:"description,omitempty"`
 	// type of client accessing resource
-	// options: admin | read-write | read-only | write-only
+	// options: admin | read-write | read-only | write-only (default)
 	Role string `json:"role,omitempty"`
+	// actions, or roles, the client is allowed to take. replaces `role`
+	// e.g. ["links:read", "stats:read"]
+	Scope []string `json:"scope,omitempty"`
+	// algorithm the requests are signed with
+	// options: HS256 (default) | Ed25519 | ES256
+	Algorithm string `json:"algorithm,omitempty"`
+	// PEM or base64 encoded PKIX public key. required for Ed25519 and ES256
+	PublicKey string `json:"public_key,omitempty"`
+	// hosts, or their subdomains, the links of the client can point to,
+	// along with the hosts its namespaces allow. any host when empty
+	AllowHosts []string `json:"allow_hosts,omitempty"`
+	// hosts, or their subdomains, the links of the client can't point to
+	DenyHosts []string `json:"deny_hosts,omitempty"`
+}
+
+// normalizes the public key, so it's stored as validated
+func (r *RequestClientCreate) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.Username != "" {
+		if err := linkr.ValidateUsername(r.Username); err != nil {
+			fields = append(fields, FieldError{Field: "username", Message: err.Error()})
+		}
+	}
+
+	fields = append(fields, validateHostList("allow_hosts", r.AllowHosts)...)
+	fields = append(fields, validateHostList("deny_hosts", r.DenyHosts)...)
+
+	if r.Role != "" && !linkr.IsRole(r.Role) {
+		fields = append(fields, FieldError{Field: "role", Message: fmt.Sprintf("must be one of %v", linkr.SupportedListOfRoles())})
+	}
+
+	for _, entry := range r.Scope {
+		if _, ok := linkr.RoleActions(entry); !ok && !linkr.IsAction(entry) {
+			fields = append(fields, FieldError{Field: "scope", Message: fmt.Sprintf("unknown action '%s'. only support %v", entry, linkr.SupportedActions())})
+		}
+	}
+
+	if r.Algorithm != "" && !includes(SupportedSigningAlgorithms(), r.Algorithm) {
+		return append(fields, FieldError{Field: "algorithm", Message: fmt.Sprintf("must be one of %v", SupportedSigningAlgorithms())})
+	}
+
+	if !isAsymmetricAlgorithm(r.Algorithm) {
+		if r.PublicKey != "" {
+			fields = append(fields, FieldError{Field: "public_key", Message: fmt.Sprintf("only used with %s or %s", SigningAlgEd25519, SigningAlgES256)})
+		}
+
+		return fields
+	}
+
+	if r.PublicKey == "" {
+		return append(fields, FieldError{Field: "public_key", Message: fmt.Sprintf("is required for %s", r.Algorithm)})
+	}
+
+	key, err := normalizePublicKey(r.Algorithm, r.PublicKey)
+	if err != nil {
+		return append(fields, FieldError{Field: "public_key", Message: err.Error()})
+	}
+
+	r.PublicKey = key
+	return fields
+}
+
+// fields left out are kept as they are
+type RequestClientUpdate struct {
+	// empty value clears the description
+	Description *string `json:"description,omitempty"`
+	// actions, or roles, the client is allowed to take. replaces the current ones
+	Scope []string `json:"scope,omitempty"`
+	// replace the host lists of the client. see `RequestClientCreate`
+	AllowHosts *[]string `json:"allow_hosts,omitempty"`
+	DenyHosts  *[]string `json:"deny_hosts,omitempty"`
+}
+
+func (r *RequestClientUpdate) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.AllowHosts != nil {
+		fields = append(fields, validateHostList("allow_hosts", *r.AllowHosts)...)
+	}
+
+	if r.DenyHosts != nil {
+		fields = append(fields, validateHostList("deny_hosts", *r.DenyHosts)...)
+	}
+
+	if r.Scope == nil {
+		return fields
+	}
+
+	if _, err := linkr.ParseScope(strings.Join(r.Scope, ",")); err != nil {
+		fields = append(fields, FieldError{Field: "scope", Message: err.Error()})
+	}
+
+	return fields
+}
+
+// new public key of clients signing with a key pair
+type RequestClientKeyRotate struct {
+	// PEM or base64 encoded PKIX public key
+	PublicKey string `json:"public_key" validate:"required"`
 }
 
 type ResponseClientCreate struct {
@@ -16,3 +124,28 @@ const (
 	HeaderLinkrApiKey = "Linkr-Api-Key"
 	HeaderLinkrDigest = "Linkr-Digest"
 )
+
+// client as described to the admins. signing keys are never responded
+type ResponseClient struct {
+	Id          string   `json:"client_id"`
+	Username    string   `json:"username"`
+	Description string   `json:"description,omitempty"`
+	Scope       string   `json:"scope"`
+	Algorithm   string   `json:"algorithm"`
+	Disabled    bool     `json:"disabled"`
+	DisabledAt  string   `json:"disabled_at,omitempty"`
+	LastUsedAt  string   `json:"last_used_at,omitempty"`
+	AllowHosts  []string `json:"allow_hosts,omitempty"`
+	DenyHosts   []string `json:"deny_hosts,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+type ResponseClientKeyRotated struct {
+	ClientId string `json:"client_id"`
+	// left out for clients signing with their own private key
+	SigningKey string `json:"client_signing_key,omitempty"`
+	Algorithm  string `json:"algorithm"`
+	// when the previous key stops working. empty when it already did
+	PreviousKeyExpiresAt string `json:"previous_key_expires_at,omitempty"`
+}
@@ -1,5 +1,30 @@
 package service
 
+import (
+	"fmt"
+	"math"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+// longest expiry of a link. `expires_in` is stored in seconds, as a 32 bit integer
+const MaxLinkExpiry = math.MaxInt32 * time.Second
+
+// checks `expires_in` is a duration a link can be stored with
+func validateExpiresIn(value string) []FieldError {
+	ex, err := linkr.ConvertStringDurationToSeconds(value)
+	if err != nil {
+		return []FieldError{{Field: "expires_in", Message: err.Error()}}
+	}
+
+	if ex > MaxLinkExpiry {
+		return []FieldError{{Field: "expires_in", Message: fmt.Sprintf("can't be longer than %d seconds", int64(MaxLinkExpiry.Seconds()))}}
+	}
+
+	return nil
+}
+
 type RequestLinkCreate struct {
 	// url to redirect to
 	Url string `json:"redirect_url" validate:"required"`
@@ -7,6 +32,43 @@ type RequestLinkCreate struct {
 	Namespace string `json:"namespace,omitempty"`
 	// if defined, how long the URL should be alive for
 	ExpiresIn string `json:"expires_in,omitempty"`
+	// how the `Linkr-Forward-*` headers reach the destination
+	// options: none | query | proxy
+	ForwardMode string `json:"forward_mode,omitempty"`
+	// if defined, the identifier used in the shortened url
+	// instead of a generated one
+	Identifier string `json:"identifier,omitempty"`
+	// if the `identifier` is taken, respond with available alternatives
+	SuggestAlternatives bool `json:"suggest_alternatives,omitempty"`
+}
+
+func (r *RequestLinkCreate) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.Namespace == linkr.ReservedGlobalChar {
+		fields = append(fields, FieldError{Field: "namespace", Message: fmt.Sprintf("'%s' is reserved", r.Namespace)})
+	}
+
+	if r.ExpiresIn != "" {
+		fields = append(fields, validateExpiresIn(r.ExpiresIn)...)
+	}
+
+	if r.ForwardMode != "" && !includes(SupportedForwardModes(), r.ForwardMode) {
+		fields = append(fields, FieldError{Field: "forward_mode", Message: fmt.Sprintf("must be one of %v", SupportedForwardModes())})
+	}
+
+	if r.Identifier != "" {
+		if err := linkr.ValidateIdentifier(r.Identifier); err != nil {
+			fields = append(fields, FieldError{Field: "identifier", Message: err.Error()})
+		}
+	}
+
+	return fields
+}
+
+type ResponseIdentifierTaken struct {
+	Identifier  string   `json:"identifier"`
+	Suggestions []string `json:"suggestions"`
 }
 
 type ResponseLinkCreate struct {
@@ -16,4 +78,52 @@ type ResponseLinkCreate struct {
 	ExpiresInSeconds *int64 `json:"expires_in_seconds,omitempty"`
 	CreatedAt        string `json:"created_at"`
 	ExpiresAt        string `json:"expires_at,omitempty"`
+	ForwardMode      string `json:"forward_mode"`
+}
+
+// fields left out are not changed
+type RequestLinkUpdate struct {
+	// url to redirect to
+	Url *string `json:"redirect_url,omitempty"`
+	// how long the URL should be alive for, from the time of the update.
+	// empty string makes the link never expire
+	ExpiresIn *string `json:"expires_in,omitempty"`
+	// how the `Linkr-Forward-*` headers reach the destination
+	ForwardMode *string `json:"forward_mode,omitempty"`
+}
+
+func (r *RequestLinkUpdate) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.Url != nil && *r.Url == "" {
+		fields = append(fields, FieldError{Field: "redirect_url", Message: "can't be empty"})
+	}
+
+	if r.ExpiresIn != nil && *r.ExpiresIn != "" {
+		fields = append(fields, validateExpiresIn(*r.ExpiresIn)...)
+	}
+
+	if r.ForwardMode != nil && !includes(SupportedForwardModes(), *r.ForwardMode) {
+		fields = append(fields, FieldError{Field: "forward_mode", Message: fmt.Sprintf("must be one of %v", SupportedForwardModes())})
+	}
+
+	return fields
+}
+
+type ResponseLink struct {
+	ShortenedUrl     string `json:"short_url"`
+	Identifier       string `json:"identifier"`
+	Namespace        string `json:"namespace"`
+	DestinationUrl   string `json:"redirect_url"`
+	ExpiresInSeconds *int64 `json:"expires_in_seconds,omitempty"`
+	ExpiresAt        string `json:"expires_at,omitempty"`
+	Expired          bool   `json:"expired"`
+	ForwardMode      string `json:"forward_mode"`
+	CreatedAt        string `json:"created_at"`
+}
+
+type ResponseLinkList struct {
+	Links []ResponseLink `json:"links"`
+	// pass as `cursor` to retrieve the next page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+type RequestNamespaceCreate struct {
+	// tag of the namespace in the shortened urls
+	Tag         string `json:"tag" validate:"required"`
+	Description string `json:"description,omitempty"`
+	// client the namespace belongs to. defaults to the client creating it
+	OwnerId string `json:"owner_id,omitempty"`
+	// hosts, or their subdomains, links of the namespace can point to.
+	// any host is allowed when empty
+	AllowHosts []string `json:"allow_hosts,omitempty"`
+	// hosts, or their subdomains, links of the namespace can't point to
+	DenyHosts []string `json:"deny_hosts,omitempty"`
+}
+
+func (r *RequestNamespaceCreate) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.Tag != "" {
+		if err := linkr.ValidateNamespaceTag(r.Tag); err != nil {
+			fields = append(fields, FieldError{Field: "tag", Message: err.Error()})
+		}
+	}
+
+	fields = append(fields, validateHostList("allow_hosts", r.AllowHosts)...)
+	return append(fields, validateHostList("deny_hosts", r.DenyHosts)...)
+}
+
+// fields left out are kept as they are. empty values clear them
+type RequestNamespaceUpdate struct {
+	Description *string `json:"description,omitempty"`
+	// where visitors of expired links are sent
+	ExpiredUrl *string `json:"expired_url,omitempty"`
+	// how identifiers of new links are generated
+	// options: cuid | base62 | random | words | hash
+	IdStrategy *string `json:"id_strategy,omitempty"`
+	// replace the host lists of the namespace. see `RequestNamespaceCreate`
+	AllowHosts *[]string `json:"allow_hosts,omitempty"`
+	DenyHosts  *[]string `json:"deny_hosts,omitempty"`
+}
+
+func (r *RequestNamespaceUpdate) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.IdStrategy != nil && *r.IdStrategy != "" && !includes(linkr.SupportedIdStrategies(), *r.IdStrategy) {
+		fields = append(fields, FieldError{Field: "id_strategy", Message: fmt.Sprintf("must be one of %v", linkr.SupportedIdStrategies())})
+	}
+
+	if r.AllowHosts != nil {
+		fields = append(fields, validateHostList("allow_hosts", *r.AllowHosts)...)
+	}
+
+	if r.DenyHosts != nil {
+		fields = append(fields, validateHostList("deny_hosts", *r.DenyHosts)...)
+	}
+
+	return fields
+}
+
+type RequestNamespaceMember struct {
+	// role of the client in the namespace
+	// options: admin | read-write | read-only | write-only
+	Role string `json:"role" validate:"required"`
+}
+
+func (r *RequestNamespaceMember) Validate() []FieldError {
+	fields := []FieldError{}
+
+	if r.Role != "" && !linkr.IsRole(r.Role) {
+		fields = append(fields, FieldError{Field: "role", Message: fmt.Sprintf("must be one of %v", linkr.SupportedListOfRoles())})
+	}
+
+	return fields
+}
+
+type ResponseNamespace struct {
+	Tag         string   `json:"tag"`
+	Description string   `json:"description,omitempty"`
+	OwnerId     string   `json:"owner_id,omitempty"`
+	ExpiredUrl  string   `json:"expired_url,omitempty"`
+	IdStrategy  string   `json:"id_strategy,omitempty"`
+	AllowHosts  []string `json:"allow_hosts,omitempty"`
+	DenyHosts   []string `json:"deny_hosts,omitempty"`
+	ArchivedAt  string   `json:"archived_at,omitempty"`
+}
+
+type ResponseNamespaceMember struct {
+	ClientId  string `json:"client_id"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
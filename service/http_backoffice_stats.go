@@ -0,0 +1,31 @@
+package service
+
+type ResponseStatsBucket struct {
+	// RFC3339 start of the bucket
+	Start  string `json:"start"`
+	Clicks int64  `json:"clicks"`
+}
+
+type ResponseStatsCount struct {
+	Name   string `json:"name"`
+	Clicks int64  `json:"clicks"`
+}
+
+type ResponseClickStats struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Bucket string `json:"bucket"`
+
+	TotalClicks int64 `json:"total_clicks"`
+	// distinct networks the clicks came from
+	UniqueVisitors int64 `json:"unique_visitors"`
+	// clicks per bucket, including the buckets without clicks
+	Series []ResponseStatsBucket `json:"series"`
+
+	// referrer hosts, `(direct)` for visits without one
+	TopReferrers []ResponseStatsCount `json:"top_referrers"`
+	// user agent families, like `Chrome` or `Bot`
+	TopAgents []ResponseStatsCount `json:"top_agents"`
+	// identifiers of the most clicked links. only for namespaces
+	TopLinks []ResponseStatsCount `json:"top_links,omitempty"`
+}
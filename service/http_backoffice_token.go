@@ -0,0 +1,10 @@
+package service
+
+type ResponseAccessToken struct {
+	// sent as `Authorization: Bearer <access_token>`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// seconds
+	ExpiresIn int64  `json:"expires_in"`
+	ExpiresAt string `json:"expires_at"`
+}
@@ -1,50 +1,178 @@
 package service
 
 import (
-	"encoding/base64"
-	"log/slog"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gbrlsnchs/jwt/v3"
 )
 
+const (
+	// how old the digest of a request can be
+	DefaultDigestMaxAge = 5 * time.Minute
+	// longest nonce a digest can carry
+	maxNonceLength = 128
+)
+
+var (
+	ErrDigestMismatch = errors.New("digest doesn't match the request")
+	ErrDigestExpired  = errors.New("digest is too old, or issued in the future")
+	ErrDigestReplayed = errors.New("digest was already used")
+	// the nonce store couldn't be reached. digests aren't accepted
+	// without checking their nonce, so they can't be replayed meanwhile
+	ErrNonceUnavailable = errors.New("nonce couldn't be checked")
+)
+
 type LinkrJwtVerifier struct {
-	m *jwt.HMACSHA
+	// one per signing key of the client
+	m []jwt.Algorithm
+
+	nonces NonceStore
+	maxAge time.Duration
+	now    func() time.Time
 }
 
-func NewVerifier(b64SignignKey string) (*LinkrJwtVerifier, error) {
-	signignKey, err := base64.StdEncoding.DecodeString(b64SignignKey)
-	slog.Info("signing key", "key", signignKey, "base64", b64SignignKey)
-	if err != nil {
-		return nil, err
+// Creates a verifier accepting digests signed with any of the keys.
+// Nonces of the verified digests are kept in `nonces`
+func NewVerifier(nonces NonceStore, maxAge time.Duration, keys ...SigningKey) (*LinkrJwtVerifier, error) {
+	v := &LinkrJwtVerifier{
+		nonces: nonces,
+		maxAge: maxAge,
+		now:    time.Now,
 	}
 
-	return &LinkrJwtVerifier{
-		m: jwt.NewHS256(signignKey),
-	}, nil
+	for _, key := range keys {
+		m, err := key.verifier()
+		if err != nil {
+			return nil, err
+		}
+
+		v.m = append(v.m, m)
+	}
+
+	return v, nil
 }
 
+// Claims of the digest of a request
+//
+//	{"Payload": {"sub": "<client id>", "iat": <unix time>, "jti": "<nonce>"}, "method": "GET", "path": "/v1/api/links", "query": "limit=10", "body": "<request body>"}
 type LinkrJWToken struct {
 	Payload jwt.Payload
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Query   string `json:"query"`
 	Body    string `json:"body"`
 }
 
-// verifies the payload with the expected payload
-func (j *LinkrJwtVerifier) Verify(digest []byte, body string, subject string) error {
+// request the digest is expected to describe
+type SignedRequest struct {
+	// id of the client making the request
+	Subject string
+	Method  string
+	Path    string
+	// raw query of the request, compared in its canonical form
+	Query string
+	Body  string
+}
+
+// Verifies the digest was signed for the request, recently, and
+// wasn't used before
+func (j *LinkrJwtVerifier) Verify(ctx context.Context, digest []byte, req SignedRequest) error {
 	ltoken := new(LinkrJWToken)
-	ltoken.Body = body
-	ltoken.Payload.Subject = subject
-
-	slog.Info("checking payload", "digest", string(digest), "subject", subject)
-
-	_, err := jwt.Verify(
-		digest,
-		j.m,
-		ltoken,
-		jwt.ValidatePayload(
-			&ltoken.Payload,
-			jwt.SubjectValidator(subject),
-		),
-	)
-
-	return err
+
+	err := jwt.ErrHMACVerification
+	for _, m := range j.m {
+		_, err = jwt.Verify(
+			digest,
+			m,
+			ltoken,
+			// keys only verify digests signed with their algorithm
+			jwt.ValidateHeader,
+			jwt.ValidatePayload(
+				&ltoken.Payload,
+				jwt.SubjectValidator(req.Subject),
+			),
+		)
+
+		// try the next key when the digest wasn't signed with this one
+		if !isSignatureMismatch(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(ltoken.Method, req.Method) || ltoken.Path != req.Path || ltoken.Body != req.Body {
+		return ErrDigestMismatch
+	}
+
+	if !sameQuery(ltoken.Query, req.Query) {
+		return ErrDigestMismatch
+	}
+
+	now := j.now()
+	if ltoken.Payload.IssuedAt == nil {
+		return ErrDigestExpired
+	}
+
+	// tolerates clocks a little ahead of ours
+	age := now.Sub(ltoken.Payload.IssuedAt.Time)
+	if age > j.maxAge || age < -j.maxAge {
+		return ErrDigestExpired
+	}
+
+	nonce := ltoken.Payload.JWTID
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return ErrDigestMismatch
+	}
+
+	// the nonce only needs to be remembered for as long as the digest is fresh
+	fresh, err := j.nonces.Use(ctx, req.Subject+":"+nonce, now, ltoken.Payload.IssuedAt.Time.Add(j.maxAge))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNonceUnavailable, err)
+	}
+
+	if !fresh {
+		return ErrDigestReplayed
+	}
+
+	return nil
+}
+
+// Query sorted by key, and encoded again, so the order of the parameters,
+// or how they are escaped, doesn't change what is signed
+func canonicalQuery(raw string) (string, error) {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return values.Encode(), nil
+}
+
+func sameQuery(signed, requested string) bool {
+	a, err := canonicalQuery(signed)
+	if err != nil {
+		return false
+	}
+
+	b, err := canonicalQuery(requested)
+	if err != nil {
+		return false
+	}
+
+	return a == b
+}
+
+func isSignatureMismatch(err error) bool {
+	return errors.Is(err, jwt.ErrHMACVerification) ||
+		errors.Is(err, jwt.ErrEd25519Verification) ||
+		errors.Is(err, jwt.ErrECDSAVerification) ||
+		errors.Is(err, jwt.ErrAlgValidation)
 }
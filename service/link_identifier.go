@@ -0,0 +1,122 @@
+// Generation of the identifiers of shortened links
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+const (
+	// identifiers generated for a link before giving up on collisions
+	MaxIdentifierAttempts = 5
+	// length of the identifiers of the random and hash strategies
+	GeneratedIdentifierLength = 7
+)
+
+var errIdentifierAttemptsExhausted = errors.New("couldn't generate an identifier that isn't taken")
+
+// picks the identifier generator of the namespace `namespaceId`
+func (a *ApiHandler) idGenerator(ctx context.Context, strategy string, namespaceId int64) (linkr.IdGenerator, error) {
+	switch strategy {
+	case "", linkr.IdStrategyCuid:
+		return linkr.CuidGenerator{}, nil
+	case linkr.IdStrategyBase62:
+		return linkr.NewBase62CounterGenerator(func() (uint64, error) {
+			return a.stores.Links.NextCounterValue(ctx, namespaceId)
+		}), nil
+	case linkr.IdStrategyRandom:
+		return linkr.NewRandomGenerator(linkr.AlphabetUnambiguous, GeneratedIdentifierLength), nil
+	case linkr.IdStrategyWords:
+		return linkr.NewWordPairGenerator(), nil
+	case linkr.IdStrategyHash:
+		return linkr.NewHashGenerator(GeneratedIdentifierLength), nil
+	}
+
+	return nil, fmt.Errorf("unknown id strategy '%s'. only support %v", strategy, linkr.SupportedIdStrategies())
+}
+
+// how far apart the expiry of two links can be for them to be the same,
+// so identical requests made together are handed out the same link
+const linkExpiryTolerance = 5 * time.Second
+
+// checks `other` expires at the same time as the link, and is saved with the
+// same forward mode and forwarded headers, so one can be handed out for the other
+func (l *Link) hasSameSettings(other *Link) bool {
+	forwardMode := func(link *Link) string {
+		if link.ForwardMode.String == "" {
+			return ForwardModeNone
+		}
+		return link.ForwardMode.String
+	}
+
+	if l.ExpiresAt.Valid != other.ExpiresAt.Valid || forwardMode(l) != forwardMode(other) {
+		return false
+	}
+
+	// compares when they expire, rather than `ExpiresIn`, which is relative
+	// to when each was created
+	if l.ExpiresAt.Valid {
+		apart := l.ExpiresAt.Time.Sub(other.ExpiresAt.Time)
+		if apart > linkExpiryTolerance || apart < -linkExpiryTolerance {
+			return false
+		}
+	}
+
+	headers, err := DecodeForwardHeaders(l.SerializedHeaders.String)
+	if err != nil {
+		return false
+	}
+
+	otherHeaders, err := DecodeForwardHeaders(other.SerializedHeaders.String)
+	if err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(headers, otherHeaders)
+}
+
+// Saves a link with an identifier from `gen`, generating another one
+// whenever it collides with an existing link.
+//
+// When `gen` derives identifiers from the url and the collision is with an
+// active link to the same url, that link is returned instead of saving a new one
+func (a *ApiHandler) saveWithGeneratedIdentifier(ctx context.Context, gen linkr.IdGenerator, namespaceId int64, url string, save func(identifier string) error) (string, *Link, error) {
+	for attempt := 0; attempt < MaxIdentifierAttempts; attempt++ {
+		identifier, err := gen.Generate(url, attempt)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// generated identifiers can land on a route
+		if linkr.IsReservedIdentifier(identifier) {
+			continue
+		}
+
+		err = save(identifier)
+		if err == nil {
+			return identifier, nil, nil
+		}
+
+		if !errors.Is(err, ErrRecordExists) {
+			return "", nil, err
+		}
+
+		if linkr.Deduplicates(gen) {
+			existing, err := a.stores.Links.Get(ctx, namespaceId, identifier)
+			if err != nil {
+				return "", nil, err
+			}
+
+			if existing.OriginalUrl == url && !existing.IsExpiredAt(time.Now()) {
+				return identifier, existing, nil
+			}
+		}
+	}
+
+	return "", nil, errIdentifierAttemptsExhausted
+}
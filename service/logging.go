@@ -0,0 +1,163 @@
+// Logging of the service, keeping secrets out of the logs
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// written in place of the secrets
+const redactedValue = "[REDACTED]"
+
+// Keys of the attributes masked when no keys are given to `NewRedactingHandler`
+func DefaultSecretLogKeys() []string {
+	return []string{"key", "signing_key", "secret", "digest", "token", "authorization", "api_key", "password", "headers"}
+}
+
+// Value that's never written to the logs. Tags values as secret
+// whatever the key of their attribute
+type Secret string
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(redactedValue)
+}
+
+// Wraps a handler, masking the attributes with secret keys, at any depth
+// of groups. Keys match regardless of their case, and forwarded header
+// values (`forward_*` keys) are always masked
+type RedactingHandler struct {
+	next       slog.Handler
+	secretKeys map[string]bool
+}
+
+func NewRedactingHandler(next slog.Handler, secretKeys ...string) *RedactingHandler {
+	if len(secretKeys) == 0 {
+		secretKeys = DefaultSecretLogKeys()
+	}
+
+	keys := map[string]bool{}
+	for _, key := range secretKeys {
+		keys[strings.ToLower(key)] = true
+	}
+
+	return &RedactingHandler{next: next, secretKeys: keys}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redact(attr))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redacted = append(redacted, h.redact(attr))
+	}
+
+	return &RedactingHandler{next: h.next.WithAttrs(redacted), secretKeys: h.secretKeys}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name), secretKeys: h.secretKeys}
+}
+
+func (h *RedactingHandler) redact(attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	if h.secretKeys[key] || strings.HasPrefix(key, "forward_") {
+		return slog.String(attr.Key, redactedValue)
+	}
+
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		group := attr.Value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, a := range group {
+			redacted = append(redacted, h.redact(a))
+		}
+
+		return slog.Group(attr.Key, redacted...)
+	}
+
+	return attr
+}
+
+// Parses the level names of `slog`: debug | info | warn | error
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level '%s'. only support [debug info warn error]", s)
+	}
+
+	return level, nil
+}
+
+// Creates the json logger of the service, writing to `w` at
+// the level of `LINKR_LOG_LEVEL` (defaults to `info`)
+func NewLoggerFromEnv(w io.Writer) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if value := os.Getenv("LINKR_LOG_LEVEL"); value != "" {
+		parsed, err := ParseLogLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("LINKR_LOG_LEVEL: %w", err)
+		}
+
+		level = parsed
+	}
+
+	return slog.New(NewRedactingHandler(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))), nil
+}
+
+const (
+	// context value holding the logger of the request
+	CtxLogger contextKey = "CTX_LOGGER"
+
+	HeaderRequestId = "X-Request-Id"
+)
+
+// Middleware attaching a logger carrying the request id to the request.
+// Placed after chi's `middleware.RequestID`
+func MiddlewareRequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := middleware.GetReqID(r.Context())
+		if id != "" {
+			w.Header().Set(HeaderRequestId, id)
+		}
+
+		logger := slog.Default().With("request_id", id, "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), logger)))
+	})
+}
+
+// Logger of the request, or the default logger
+// when the request has none
+func RequestLogger(r *http.Request) *slog.Logger {
+	return loggerFrom(r.Context())
+}
+
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(CtxLogger).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, CtxLogger, logger)
+}
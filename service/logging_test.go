@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewRedactingHandler(slog.NewJSONHandler(&buf, nil)))
+
+	logger.With("Signing_Key", "with-attrs").Info("checking",
+		"digest", "eyJhbGciOi",
+		"forward_x-secret", "2313",
+		"token", Secret("tagged"),
+		"note", Secret("tagged by type"),
+		slog.Group("client", "id", "api_1", "key", "nested"),
+		"subject", "api_1",
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"with-attrs", "eyJhbGciOi", "2313", "tagged", "nested"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected '%s' to be redacted: %s", secret, out)
+		}
+	}
+
+	for _, kept := range []string{`"subject":"api_1"`, `"id":"api_1"`, redactedValue} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("expected '%s' in the logs: %s", kept, out)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	if level, err := ParseLogLevel("debug"); err != nil || level != slog.LevelDebug {
+		t.Errorf("expected the debug level, got %s (%v)", level, err)
+	}
+
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Error("expected unknown levels to be rejected")
+	}
+
+	t.Setenv("LINKR_LOG_LEVEL", "warn")
+	var buf bytes.Buffer
+	logger, err := NewLoggerFromEnv(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Errorf("expected only warnings and above to be logged: %s", buf.String())
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(NewRedactingHandler(slog.NewJSONHandler(&buf, nil))))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	h := middleware.RequestID(MiddlewareRequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, errors.New("database is gone"))
+	})))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/links", nil))
+
+	id := rec.Header().Get(HeaderRequestId)
+	if id == "" {
+		t.Fatal("expected the request id in the response")
+	}
+
+	if !strings.Contains(buf.String(), `"request_id":"`+id+`"`) || !strings.Contains(buf.String(), "database is gone") {
+		t.Errorf("expected the error logged with the request id: %s", buf.String())
+	}
+}
@@ -0,0 +1,342 @@
+// Caching of the lookups the redirects make, so a visit
+// doesn't always wait on the database
+package service
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+const (
+	// namespaces, and links, kept by the cache
+	DefaultLookupCacheSize = 10000
+	// how long a record found is kept
+	DefaultLookupCacheTTL = time.Minute
+	// how long a record that wasn't found is kept
+	DefaultLookupCacheNegativeTTL = 10 * time.Second
+)
+
+type LookupCacheConfig struct {
+	// entries kept of each kind. nothing is cached when 0
+	Size int
+	// how long records found are kept
+	TTL time.Duration
+	// how long records that weren't found are kept. they aren't when 0
+	NegativeTTL time.Duration
+}
+
+// Reads the cache config from the environment:
+//   - LINKR_CACHE_SIZE: entries kept of each kind, 0 disables the cache
+//   - LINKR_CACHE_TTL: how long records found are kept, e.g. 1m
+//   - LINKR_CACHE_NEGATIVE_TTL: how long records not found are kept, e.g. 10s
+func NewLookupCacheConfigFromEnv() (LookupCacheConfig, error) {
+	config := LookupCacheConfig{
+		Size:        DefaultLookupCacheSize,
+		TTL:         DefaultLookupCacheTTL,
+		NegativeTTL: DefaultLookupCacheNegativeTTL,
+	}
+
+	if value := os.Getenv("LINKR_CACHE_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return config, fmt.Errorf("LINKR_CACHE_SIZE: '%s' isn't a number of entries", value)
+		}
+
+		config.Size = size
+	}
+
+	for env, ttl := range map[string]*time.Duration{
+		"LINKR_CACHE_TTL":          &config.TTL,
+		"LINKR_CACHE_NEGATIVE_TTL": &config.NegativeTTL,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := linkr.ConvertStringDurationToSeconds(value)
+		if err != nil {
+			return config, fmt.Errorf("%s: %w", env, err)
+		}
+
+		*ttl = parsed
+	}
+
+	return config, nil
+}
+
+type CacheStats struct {
+	// lookups answered by the cache, records not found included
+	Hits uint64
+	// lookups that went to the store
+	Misses uint64
+	// entries dropped to make room for newer ones
+	Evictions uint64
+	// entries in the cache
+	Entries int
+}
+
+type LookupCacheStats struct {
+	Namespaces CacheStats
+	Links      CacheStats
+}
+
+// key of a link in the cache
+type linkKey struct {
+	namespaceId int64
+	identifier  string
+}
+
+// Keeps the namespaces by their tag, and the links by their namespace
+// and identifier, along with the ones that don't exist.
+//
+// Records changed through the stores it wraps are dropped from the cache.
+// It isn't shared between instances, so records changed by another
+// instance are only seen once they expire
+type LookupCache struct {
+	namespaces *lruCache[string, LinkrNamespace]
+	links      *lruCache[linkKey, Link]
+}
+
+func NewLookupCache(config LookupCacheConfig) *LookupCache {
+	return &LookupCache{
+		namespaces: newLRUCache[string, LinkrNamespace](config.Size, config.TTL, config.NegativeTTL, namespaceIndexes),
+		links:      newLRUCache[linkKey, Link](config.Size, config.TTL, config.NegativeTTL, linkIndexes),
+	}
+}
+
+// indexes a namespace is removed by: its id and its owner
+func namespaceIndexes(_ string, ns LinkrNamespace, missing bool) []string {
+	if missing {
+		return nil
+	}
+
+	indexes := []string{namespaceIndex(ns.Id)}
+	if ns.OwnerId.Valid {
+		indexes = append(indexes, ownerIndex(ns.OwnerId.String))
+	}
+
+	return indexes
+}
+
+// indexes a link is removed by: its id and its namespace, which
+// the links known not to exist are removed by as well
+func linkIndexes(key linkKey, link Link, missing bool) []string {
+	indexes := []string{namespaceIndex(key.namespaceId)}
+	if !missing {
+		indexes = append(indexes, linkIndex(link.Id))
+	}
+
+	return indexes
+}
+
+func namespaceIndex(id int64) string {
+	return fmt.Sprintf("namespace:%d", id)
+}
+
+func linkIndex(id int) string {
+	return fmt.Sprintf("link:%d", id)
+}
+
+func ownerIndex(clientId string) string {
+	return "owner:" + clientId
+}
+
+func (c *LookupCache) Stats() LookupCacheStats {
+	return LookupCacheStats{
+		Namespaces: c.namespaces.stats(),
+		Links:      c.links.stats(),
+	}
+}
+
+// Stores whose namespace and link lookups go through the cache
+func (c *LookupCache) Wrap(stores *Stores) *Stores {
+	return &Stores{
+		Links:      &cachedLinkStore{LinkStore: stores.Links, cache: c},
+		Namespaces: &cachedNamespaceStore{NamespaceStore: stores.Namespaces, cache: c},
+		Clients:    &cachedClientStore{ClientStore: stores.Clients, cache: c},
+		Stats:      stores.Stats,
+	}
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	// the record doesn't exist
+	missing   bool
+	expiresAt time.Time
+	// the entry is listed under, in `indexed`
+	indexes []string
+}
+
+// Least recently used entries, dropped once there are more than `size`,
+// or once they expire
+type lruCache[K comparable, V any] struct {
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	// indexes the entries can be removed by, besides their key. optional
+	index func(key K, value V, missing bool) []string
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[K]*list.Element
+	// keys of the entries, by index
+	indexed map[string]map[K]struct{}
+	// changes whenever entries are removed, so lookups that
+	// started before aren't put in the cache
+	generation uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newLRUCache[K comparable, V any](size int, ttl time.Duration, negativeTTL time.Duration, index func(key K, value V, missing bool) []string) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		index:       index,
+		order:       list.New(),
+		entries:     map[K]*list.Element{},
+		indexed:     map[string]map[K]struct{}{},
+	}
+}
+
+// Looks `key` up. `found` is false when the key isn't cached, in which case
+// the generation to put the looked up record with is returned.
+// `missing` is true when the record is known not to exist
+func (c *lruCache[K, V]) get(key K) (value V, missing bool, found bool, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry[K, V])
+		if c.now().Before(entry.expiresAt) {
+			c.order.MoveToFront(el)
+			c.hits++
+			return entry.value, entry.missing, true, c.generation
+		}
+
+		c.drop(el)
+	}
+
+	c.misses++
+	return value, false, false, c.generation
+}
+
+// Caches the record looked up at `generation`, unless
+// entries were removed since
+func (c *lruCache[K, V]) put(key K, value V, missing bool, generation uint64) {
+	ttl := c.ttl
+	if missing {
+		ttl = c.negativeTTL
+	}
+
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &lruEntry[K, V]{key: key, value: value, missing: missing, expiresAt: c.now().Add(ttl)}
+	if c.index != nil {
+		entry.indexes = c.index(key, value, missing)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		c.unindex(el.Value.(*lruEntry[K, V]))
+		el.Value = entry
+		c.order.MoveToFront(el)
+		c.reindex(entry)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	c.reindex(entry)
+	for c.order.Len() > c.size {
+		c.drop(c.order.Back())
+		c.evictions++
+	}
+}
+
+// removes the entries listed under `index`, known not to exist or not
+func (c *lruCache[K, V]) removeIndexed(index string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key := range c.indexed[index] {
+		c.drop(c.entries[key])
+	}
+}
+
+func (c *lruCache[K, V]) removeKey(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if el, ok := c.entries[key]; ok {
+		c.drop(el)
+	}
+}
+
+// [Must be called holding `mu`]
+// Removes the entry, and lists it under none of its indexes
+func (c *lruCache[K, V]) drop(el *list.Element) {
+	entry := el.Value.(*lruEntry[K, V])
+	c.order.Remove(el)
+	delete(c.entries, entry.key)
+	c.unindex(entry)
+}
+
+// [Must be called holding `mu`]
+// Lists the entry under each of its indexes
+func (c *lruCache[K, V]) reindex(entry *lruEntry[K, V]) {
+	for _, index := range entry.indexes {
+		keys, ok := c.indexed[index]
+		if !ok {
+			keys = map[K]struct{}{}
+			c.indexed[index] = keys
+		}
+
+		keys[entry.key] = struct{}{}
+	}
+}
+
+// [Must be called holding `mu`]
+func (c *lruCache[K, V]) unindex(entry *lruEntry[K, V]) {
+	for _, index := range entry.indexes {
+		delete(c.indexed[index], entry.key)
+		if len(c.indexed[index]) == 0 {
+			delete(c.indexed, index)
+		}
+	}
+}
+
+func (c *lruCache[K, V]) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.order.Len(),
+	}
+}
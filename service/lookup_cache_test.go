@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC)
+	// odd values are removed together
+	c := newLRUCache[string, int](2, time.Minute, 10*time.Second, func(_ string, value int, _ bool) []string {
+		if value%2 == 1 {
+			return []string{"odd"}
+		}
+
+		return nil
+	})
+	c.now = func() time.Time { return now }
+
+	put := func(key string, value int, missing bool) {
+		_, _, _, generation := c.get(key)
+		c.put(key, value, missing, generation)
+	}
+
+	put("a", 1, false)
+	put("b", 2, false)
+
+	// a is used last, so b is the one evicted
+	if value, _, found, _ := c.get("a"); !found || value != 1 {
+		t.Errorf("expected a to be cached, got %d %v", value, found)
+	}
+
+	put("c", 3, false)
+	if _, _, found, _ := c.get("b"); found {
+		t.Error("expected b to be evicted")
+	}
+
+	put("gone", 0, true)
+	if _, missing, found, _ := c.get("gone"); !found || !missing {
+		t.Errorf("expected gone to be cached as missing, got %v %v", missing, found)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, _, found, _ := c.get("gone"); found {
+		t.Error("expected the missing record to expire first")
+	}
+
+	if _, _, found, _ := c.get("c"); !found {
+		t.Error("expected c to be cached until its ttl")
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, found, _ := c.get("c"); found {
+		t.Error("expected c to expire")
+	}
+
+	// the lookup started before c was removed, and is stale
+	_, _, _, generation := c.get("c")
+	c.removeKey("c")
+	c.put("c", 3, false, generation)
+	if _, _, found, _ := c.get("c"); found {
+		t.Error("expected a lookup older than a removal not to be cached")
+	}
+
+	stats := c.stats()
+	if stats.Evictions != 2 || stats.Hits != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	put("a", 1, false)
+	put("b", 2, false)
+	put("d", 5, false)
+	c.removeIndexed("odd")
+	if _, _, found, _ := c.get("d"); found {
+		t.Error("expected d to be removed along with the other odd values")
+	}
+
+	if _, _, found, _ := c.get("b"); !found {
+		t.Error("expected b to be kept")
+	}
+
+	// a was evicted, and d removed, so they aren't listed anymore
+	if len(c.indexed) != 0 {
+		t.Errorf("expected the index to be emptied with its entries, got %v", c.indexed)
+	}
+}
+
+// counts the lookups reaching the stores
+type countingLinkStore struct {
+	LinkStore
+	gets int
+}
+
+func (s *countingLinkStore) Get(ctx context.Context, namespaceId int64, identifier string) (*Link, error) {
+	s.gets++
+	return s.LinkStore.Get(ctx, namespaceId, identifier)
+}
+
+func TestCachedStores(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+	links := &countingLinkStore{LinkStore: stores.Links}
+	stores.Links = links
+
+	cache := NewLookupCache(LookupCacheConfig{Size: 100, TTL: time.Minute, NegativeTTL: time.Minute})
+	cached := cache.Wrap(stores)
+
+	dfNs, err := cached.Namespaces.GetByTag(ctx, linkr.ReservedGlobalChar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cached.Links.Get(ctx, dfNs.Id, "abc"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected the link not to exist, got %v", err)
+	}
+
+	if _, err := cached.Links.Get(ctx, dfNs.Id, "abc"); !errors.Is(err, ErrRecordNotFound) || links.gets != 1 {
+		t.Errorf("expected the missing link to be cached, got %d lookups", links.gets)
+	}
+
+	link := &Link{Tag: "abc", OriginalUrl: "https://dest.example", NamespaceId: int(dfNs.Id)}
+	if err := cached.Links.Create(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		found, err := cached.Links.Get(ctx, dfNs.Id, "abc")
+		if err != nil || found.OriginalUrl != "https://dest.example" {
+			t.Fatalf("expected the created link to be found, got %v %v", found, err)
+		}
+
+		// changing what's returned doesn't change what's cached
+		found.OriginalUrl = "https://changed.example"
+	}
+
+	if links.gets != 2 {
+		t.Errorf("expected the created link to be looked up once, got %d lookups", links.gets)
+	}
+
+	destination := "https://moved.example"
+	if err := cached.Links.Update(ctx, link.Id, LinkUpdate{DestinationUrl: &destination}); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := cached.Links.Get(ctx, dfNs.Id, "abc"); found.OriginalUrl != destination {
+		t.Errorf("expected the updated link, got %s", found.OriginalUrl)
+	}
+
+	if err := cached.Links.Delete(ctx, link.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cached.Links.Get(ctx, dfNs.Id, "abc"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected the deleted link to be gone, got %v", err)
+	}
+
+	if _, err := cached.Namespaces.GetByTag(ctx, "d"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("expected the namespace not to exist, got %v", err)
+	}
+
+	ns := &LinkrNamespace{Tag: "d", OwnerId: sql.NullString{String: "api_owner", Valid: true}}
+	if err := cached.Namespaces.Create(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cached.Namespaces.GetByTag(ctx, "d"); err != nil {
+		t.Errorf("expected the created namespace to be found, got %v", err)
+	}
+
+	if err := cached.Namespaces.Archive(ctx, ns.Id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := cached.Namespaces.GetByTag(ctx, "d"); !found.ArchivedAt.Valid {
+		t.Error("expected the archived namespace")
+	}
+
+	if err := cached.Links.Create(ctx, &Link{Tag: "xyz", OriginalUrl: "https://dest.example", NamespaceId: int(ns.Id)}); err != nil {
+		t.Fatal(err)
+	}
+
+	cached.Links.Get(ctx, ns.Id, "xyz")
+	if _, err := cached.Namespaces.Delete(ctx, ns.Id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cached.Links.Get(ctx, ns.Id, "xyz"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected the links of the deleted namespace to be gone, got %v", err)
+	}
+
+	if _, err := cached.Namespaces.GetByTag(ctx, "d"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected the deleted namespace to be gone, got %v", err)
+	}
+}
+
+func TestRedirectFromCache(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Namespace" (unique_tag) VALUES ('d')`)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('abc', 'https://dest.example', 2)`)
+
+	cache := NewLookupCache(LookupCacheConfig{Size: 100, TTL: time.Minute, NegativeTTL: time.Minute})
+	router := newTestLinkRouter(NewLinkHandler(cache.Wrap(NewSQLStores(db)), dfNs, nil, nil, nil))
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/d/abc", nil))
+		if rec.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("expected a redirect, got %d", rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/d/nope", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected a missing link, got %d", rec.Code)
+		}
+	}
+
+	// deleted behind the cache's back, so it's still served
+	db.MustExec(`DELETE FROM "Link"`)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/d/abc", nil))
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected the link to be served from the cache, got %d", rec.Code)
+	}
+
+	stats := cache.Stats()
+	if stats.Namespaces.Misses != 1 || stats.Namespaces.Hits != 6 {
+		t.Errorf("expected the namespace to be looked up once, got %+v", stats.Namespaces)
+	}
+
+	if stats.Links.Misses != 2 || stats.Links.Hits != 5 {
+		t.Errorf("expected each link to be looked up once, got %+v", stats.Links)
+	}
+}
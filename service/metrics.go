@@ -0,0 +1,213 @@
+// Metrics of the service, exported for prometheus
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// outcomes of the redirects
+const (
+	// the visitor was sent to the destination of the link
+	RedirectHit = "hit"
+	// the link, or its namespace, doesn't exist
+	RedirectMiss = "miss"
+	// the link expired, or its namespace was archived
+	RedirectExpired = "expired"
+	// the link couldn't be looked up, or its destination built
+	RedirectError = "error"
+)
+
+// label of the requests that didn't match a route
+const unmatchedRoute = "unmatched"
+
+// address the metrics are served on, away from the port of the service.
+// loopback, so they aren't reachable from outside the host
+const DefaultMetricsAddr = "127.0.0.1:9090"
+
+// Counters, and histograms, of the requests, the redirects, the
+// authentications and the queries, along with the stats of the cache
+// and the click pipeline, read when they're scraped.
+//
+// A nil `*Metrics` records nothing, so handlers can do without
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	redirects       *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linkr_http_requests_total",
+			Help: "Requests handled, by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "linkr_http_request_duration_seconds",
+			Help:    "Time taken to respond to the requests, by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linkr_redirects_total",
+			Help: "Visits of the shortened links, by outcome (hit, miss, expired, error).",
+		}, []string{"outcome"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linkr_auth_failures_total",
+			Help: "Requests to the api that couldn't be authenticated, by reason.",
+		}, []string{"reason"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "linkr_db_query_duration_seconds",
+			Help: "Time taken by the queries of the stores, by store and operation.",
+			// queries of sqlite take well under a millisecond
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 9),
+		}, []string{"store", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.redirects,
+		m.authFailures,
+		m.queryDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Serves the metrics in the prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counting the requests, and timing them, by the pattern
+// of the route they matched, so ids in the path don't add labels
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// the pattern is only complete once the routers are done. requests
+		// a middleware responded to are left with the pattern it's mounted at
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// exports the stats of the lookup cache, read when scraped
+func (m *Metrics) ObserveCache(cache *LookupCache) {
+	m.registry.MustRegister(&lookupCacheCollector{cache: cache})
+}
+
+// exports the stats of the click pipeline, read when scraped
+func (m *Metrics) ObserveClicks(clicks *ClickRecorder) {
+	m.registry.MustRegister(&clickRecorderCollector{clicks: clicks})
+}
+
+func (m *Metrics) redirect(outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.redirects.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) authFailure(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// times the query `operation` of `store`, started at `start`
+func (m *Metrics) query(store string, operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.queryDuration.WithLabelValues(store, operation).Observe(time.Since(start).Seconds())
+}
+
+var (
+	cacheHitsDesc = prometheus.NewDesc("linkr_lookup_cache_hits_total",
+		"Lookups answered by the cache, records not found included.", []string{"cache"}, nil)
+	cacheMissesDesc = prometheus.NewDesc("linkr_lookup_cache_misses_total",
+		"Lookups that went to the stores.", []string{"cache"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc("linkr_lookup_cache_evictions_total",
+		"Entries dropped to make room for newer ones.", []string{"cache"}, nil)
+	cacheEntriesDesc = prometheus.NewDesc("linkr_lookup_cache_entries",
+		"Entries in the cache.", []string{"cache"}, nil)
+)
+
+// reads the stats of the cache once per scrape
+type lookupCacheCollector struct {
+	cache *LookupCache
+}
+
+func (c *lookupCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheEntriesDesc
+}
+
+func (c *lookupCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cache.Stats()
+	for name, s := range map[string]CacheStats{"namespaces": stats.Namespaces, "links": stats.Links} {
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(s.Hits), name)
+		ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(s.Misses), name)
+		ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(s.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(s.Entries), name)
+	}
+}
+
+var (
+	clickQueueDepthDesc = prometheus.NewDesc("linkr_click_queue_depth",
+		"Clicks waiting in the buffer to be written.", nil, nil)
+	clicksDesc = prometheus.NewDesc("linkr_clicks_total",
+		"Clicks of the pipeline, by state (recorded, written, dropped, failed).", []string{"state"}, nil)
+)
+
+// reads the stats of the click pipeline once per scrape
+type clickRecorderCollector struct {
+	clicks *ClickRecorder
+}
+
+func (c *clickRecorderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clickQueueDepthDesc
+	ch <- clicksDesc
+}
+
+func (c *clickRecorderCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.clicks.Stats()
+	ch <- prometheus.MustNewConstMetric(clickQueueDepthDesc, prometheus.GaugeValue, float64(stats.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(clicksDesc, prometheus.CounterValue, float64(stats.Recorded), "recorded")
+	ch <- prometheus.MustNewConstMetric(clicksDesc, prometheus.CounterValue, float64(stats.Written), "written")
+	ch <- prometheus.MustNewConstMetric(clicksDesc, prometheus.CounterValue, float64(stats.Dropped), "dropped")
+	ch <- prometheus.MustNewConstMetric(clicksDesc, prometheus.CounterValue, float64(stats.Failed), "failed")
+}
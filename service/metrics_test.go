@@ -0,0 +1,89 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMetrics(t *testing.T) {
+	db, dfNs := newTestDB(t)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES ('abc', 'https://dest.example', ?)`, dfNs.Id)
+	db.MustExec(`INSERT INTO "Link" (identifier, destination_url, namespace_id, expires_in, expires_at) VALUES ('old', 'https://dest.example', ?, 60, ?)`, dfNs.Id, time.Now().Add(-time.Hour))
+
+	metrics := NewMetrics()
+	cache := NewLookupCache(LookupCacheConfig{Size: 100, TTL: time.Minute, NegativeTTL: time.Minute})
+	stores := cache.Wrap(metrics.Wrap(NewSQLStores(db)))
+	metrics.ObserveCache(cache)
+
+	// not written in the background, so the clicks stay queued
+	clicks := newClickRecorder(stores.Stats, 10, 10, time.Hour, []byte("salt"), nil)
+	metrics.ObserveClicks(clicks)
+
+	links := NewLinkHandler(stores, dfNs, clicks, metrics, nil)
+	cc := NewCommandCenter(stores.Clients, NewMemoryNonceStore(), DefaultDigestMaxAge, NewTokenIssuer([]byte("secret"), DefaultAccessTokenTTL), nil, metrics)
+
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Handle("/metrics", metrics.Handler())
+	r.Route("/v1/api", func(r chi.Router) {
+		r.Use(cc.MiddlewareGated)
+		r.Get("/links", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	r.Get("/{id}", links.HandleRedirectShortenedLink)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/abc", "/abc", "/nope", "/old"} {
+		serve(httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	serve(httptest.NewRequest(http.MethodGet, "/v1/api/links", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/api/links", nil)
+	req.Header.Set("Authorization", "Bearer nonsense")
+	serve(req)
+
+	serve(httptest.NewRequest(http.MethodGet, "/some/where", nil))
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the metrics, got %d", rec.Code)
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		`linkr_http_requests_total{method="GET",route="/{id}",status="307"} 2`,
+		`linkr_http_requests_total{method="GET",route="/{id}",status="404"} 1`,
+		`linkr_http_requests_total{method="GET",route="/{id}",status="410"} 1`,
+		// rejected before reaching their route
+		`linkr_http_requests_total{method="GET",route="/v1/api/*",status="401"} 2`,
+		`linkr_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`linkr_http_request_duration_seconds_count{method="GET",route="/{id}"} 4`,
+		`linkr_redirects_total{outcome="hit"} 2`,
+		`linkr_redirects_total{outcome="miss"} 1`,
+		`linkr_redirects_total{outcome="expired"} 1`,
+		`linkr_auth_failures_total{reason="missing_key"} 1`,
+		`linkr_auth_failures_total{reason="invalid_token"} 1`,
+		// the second visit of abc is answered by the cache
+		`linkr_db_query_duration_seconds_count{operation="get",store="links"} 3`,
+		`linkr_lookup_cache_hits_total{cache="links"} 1`,
+		`linkr_lookup_cache_misses_total{cache="links"} 3`,
+		`linkr_lookup_cache_entries{cache="links"} 3`,
+		`linkr_clicks_total{state="recorded"} 2`,
+		`linkr_click_queue_depth 2`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected the metrics to have %s", line)
+		}
+	}
+}
@@ -5,24 +5,45 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	linkr "iam-kevin/linkr/pkg"
-
-	"github.com/jmoiron/sqlx"
 )
 
+// largest body of a signed request
+const MaxSignedBodySize = 1 << 20
+
 type CommandCenter struct {
-	db *sqlx.DB
+	clients ClientStore
+
+	// nonces of the verified digests
+	nonces NonceStore
+	// how old the digest of a request can be
+	digestMaxAge time.Duration
+
+	// issues the access tokens
+	tokens *TokenIssuer
+
+	// notes when the clients authenticate. optional
+	usage *ClientUsageTracker
+
+	// counts the failed authentications. optional
+	metrics *Metrics
 }
 
-func NewCommandCenter(db *sqlx.DB) *CommandCenter {
+func NewCommandCenter(clients ClientStore, nonces NonceStore, digestMaxAge time.Duration, tokens *TokenIssuer, usage *ClientUsageTracker, metrics *Metrics) *CommandCenter {
 	return &CommandCenter{
-		db: db,
+		clients:      clients,
+		nonces:       nonces,
+		digestMaxAge: digestMaxAge,
+		tokens:       tokens,
+		usage:        usage,
+		metrics:      metrics,
 	}
 }
 
@@ -32,98 +53,242 @@ type contextKey string
 const (
 	// context value holding reference to Linkr Client
 	CtxLinkrClient contextKey = "CTX_LINKR_CLIENT"
+	// context value holding how the client authenticated
+	CtxAuthScheme contextKey = "CTX_AUTH_SCHEME"
+)
+
+const (
+	// requests signed with the key of the client
+	AuthSchemeDigest = "digest"
+	// requests carrying an access token
+	AuthSchemeBearer = "bearer"
+)
+
+// reasons the authentication of a request fails
+const (
+	AuthFailureMissingKey     = "missing_key"
+	AuthFailureMissingDigest  = "missing_digest"
+	AuthFailureInvalidKey     = "invalid_key"
+	AuthFailureUnknownClient  = "unknown_client"
+	AuthFailureBodyTooLarge   = "body_too_large"
+	AuthFailureUnreadableBody = "unreadable_body"
+	AuthFailureDigestExpired  = "digest_expired"
+	AuthFailureDigestReplayed = "digest_replayed"
+	AuthFailureInvalidDigest  = "invalid_digest"
+	AuthFailureTokenExpired   = "token_expired"
+	AuthFailureInvalidToken   = "invalid_token"
+	AuthFailureClientDisabled = "client_disabled"
+	AuthFailureLegacyKey      = "legacy_key"
 )
 
-// Middleware that checks if the user if authenticated
+// authentication that failed for `reason`, responded as `err`
+type authFailure struct {
+	reason string
+	err    *ApiError
+}
+
+func failAuth(reason string, err *ApiError) error {
+	return &authFailure{reason: reason, err: err}
+}
+
+func (f *authFailure) Error() string {
+	return f.err.Error()
+}
+
+func (f *authFailure) Unwrap() error {
+	return f.err
+}
+
+// Middleware that checks if the user if authenticated, either with
+// a signed request or with an access token (`Authorization: Bearer`)
 func (cc *CommandCenter) MiddlewareGated(next http.Handler) http.Handler {
 	// ...
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// ..
-		apiKey := r.Header.Get(HeaderLinkrApiKey)
-		if apiKey == "" {
-			http.Error(w, "missing api key", http.StatusForbidden)
-			return
-		}
-		digestString := r.Header.Get(HeaderLinkrDigest)
-		if digestString == "" {
-			http.Error(w, "missing request digest", http.StatusBadRequest)
-			return
-		}
+		var client *LinkrClient
+		var err error
 
-		clientKeyByte, err := base64.StdEncoding.DecodeString(string(apiKey))
-		if err != nil {
-			slog.Error(fmt.Sprintf("failed to base64 parse the key, reason: %s", err.Error()))
-			http.Error(w, "invalid authentication", http.StatusForbidden)
-			return
+		scheme := AuthSchemeDigest
+		if token, ok := bearerToken(r); ok {
+			scheme = AuthSchemeBearer
+			client, err = cc.authenticateBearer(r, token)
+		} else {
+			client, err = cc.authenticateDigest(w, r)
 		}
 
-		// check the authentication
-		client := new(LinkrClient)
-		err = cc.db.Get(client, `SELECT * FROM "ApiClient" where id = ?`, string(clientKeyByte))
-		if err != nil {
-			slog.Error(err.Error())
-			http.Error(w, "invalid authentication", http.StatusForbidden)
-			return
+		if client != nil && client.DisabledAt.Valid {
+			err = failAuth(AuthFailureClientDisabled, ErrUnauthenticated("client is disabled"))
 		}
 
-		v, err := NewVerifier(client.SigningKey)
 		if err != nil {
-			slog.Error(fmt.Sprintf("couldn't initialize verifier: %s", err.Error()))
-			http.Error(w, "something went wrong. please try again later", http.StatusInternalServerError)
-			return
-		}
+			failure := new(authFailure)
+			if errors.As(err, &failure) {
+				cc.metrics.authFailure(failure.reason)
+			}
 
-		// clone request body
-		var buf bytes.Buffer
-		io.TeeReader(r.Body, &buf)
-		payload, err := io.ReadAll(&buf)
-		if err != nil {
-			slog.Error(fmt.Sprintf("failed verify payload: %s", err.Error()))
-			http.Error(w, "invalid authentication", http.StatusForbidden)
-			return
-		}
+			apiErr := new(ApiError)
+			if scheme == AuthSchemeBearer && errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+				// tells the client to get another token
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
 
-		digest, err := base64.StdEncoding.DecodeString(digestString)
-		if err != nil {
-			slog.Error(fmt.Sprintf("failed verify payload: %s", err.Error()))
-			http.Error(w, "invalid authentication", http.StatusForbidden)
+			writeError(w, r, err)
 			return
 		}
 
-		// check digest
-		err = v.Verify(digest, string(payload), string(clientKeyByte))
-		if err != nil {
-			slog.Error(fmt.Sprintf("coudn't verify payload. reason: %s", err.Error()))
-			http.Error(w, "failed to verify payload", http.StatusBadRequest)
-			return
+		if cc.usage != nil {
+			cc.usage.Touch(client.Id, time.Now())
 		}
 
 		ctx := context.WithValue(r.Context(), CtxLinkrClient, client)
+		ctx = context.WithValue(ctx, CtxAuthScheme, scheme)
+		ctx = withLogger(ctx, loggerFrom(ctx).With("client_id", client.Id))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authenticates the client with the digest signing the request
+func (cc *CommandCenter) authenticateDigest(w http.ResponseWriter, r *http.Request) (*LinkrClient, error) {
+	apiKey := r.Header.Get(HeaderLinkrApiKey)
+	if apiKey == "" {
+		return nil, failAuth(AuthFailureMissingKey, ErrUnauthenticated("missing api key"))
+	}
+	digestString := r.Header.Get(HeaderLinkrDigest)
+	if digestString == "" {
+		return nil, failAuth(AuthFailureMissingDigest, ErrUnauthenticated("missing request digest"))
+	}
+
+	clientKeyByte, err := base64.StdEncoding.DecodeString(string(apiKey))
+	if err != nil {
+		RequestLogger(r).Error(fmt.Sprintf("failed to base64 parse the key, reason: %s", err.Error()))
+		return nil, failAuth(AuthFailureInvalidKey, ErrUnauthenticated("invalid authentication"))
+	}
+
+	// check the authentication
+	client, err := cc.clients.Get(r.Context(), string(clientKeyByte))
+	if err != nil {
+		RequestLogger(r).Error(err.Error())
+		return nil, failAuth(AuthFailureUnknownClient, ErrUnauthenticated("invalid authentication"))
+	}
+
+	// current key, along with the rotated ones still in their grace
+	keys, err := clientSigningKeys(r.Context(), cc.clients, client, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't retrieve the signing keys of client %s: %w", client.Id, err)
+	}
+
+	if len(keys) == 0 {
+		// only held the legacy shared key, which anyone can sign with
+		RequestLogger(r).Warn("client signs with the legacy shared key, and needs its key rotated", "client_id", client.Id)
+		return nil, failAuth(AuthFailureLegacyKey, ErrUnauthenticated("signing key must be rotated"))
+	}
+
+	// read the body to verify it, then put it back for the handlers
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxSignedBodySize))
+	if err != nil {
+		maxBytesErr := new(http.MaxBytesError)
+		if errors.As(err, &maxBytesErr) {
+			return nil, failAuth(AuthFailureBodyTooLarge, ErrPayloadTooLarge(fmt.Sprintf("request body can't be larger than %d bytes", MaxSignedBodySize)))
+		}
+
+		RequestLogger(r).Error(fmt.Sprintf("failed verify payload: %s", err.Error()))
+		return nil, failAuth(AuthFailureUnreadableBody, ErrBadRequest("couldn't read request body"))
+	}
+	r.Body = io.NopCloser(bytes.NewReader(payload))
+
+	digest, err := base64.StdEncoding.DecodeString(digestString)
+	if err != nil {
+		RequestLogger(r).Error(fmt.Sprintf("failed verify payload: %s", err.Error()))
+		return nil, failAuth(AuthFailureInvalidDigest, ErrUnauthenticated("invalid authentication"))
+	}
+
+	v, err := NewVerifier(cc.nonces, cc.digestMaxAge, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't initialize verifier: %w", err)
+	}
+
+	// check digest
+	err = v.Verify(r.Context(), digest, SignedRequest{
+		Subject: string(clientKeyByte),
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Body:    string(payload),
+	})
+
+	switch {
+	case errors.Is(err, ErrDigestExpired):
+		return nil, failAuth(AuthFailureDigestExpired, ErrUnauthenticated("request digest expired"))
+	case errors.Is(err, ErrDigestReplayed):
+		return nil, failAuth(AuthFailureDigestReplayed, ErrUnauthenticated("request digest was already used"))
+	case errors.Is(err, ErrNonceUnavailable):
+		return nil, fmt.Errorf("couldn't verify the digest of client %s: %w", client.Id, err)
+	case err != nil:
+		RequestLogger(r).Error(fmt.Sprintf("coudn't verify payload. reason: %s", err.Error()))
+		return nil, failAuth(AuthFailureInvalidDigest, ErrUnauthenticated("failed to verify payload"))
+	}
+
+	return client, nil
+}
+
+// authenticates the client with the access token it was issued
+func (cc *CommandCenter) authenticateBearer(r *http.Request, raw string) (*LinkrClient, error) {
+	token, err := cc.tokens.Verify(raw)
+	if errors.Is(err, ErrAccessTokenExpired) {
+		return nil, failAuth(AuthFailureTokenExpired, ErrUnauthenticated("access token expired"))
+	}
+
+	if err != nil {
+		return nil, failAuth(AuthFailureInvalidToken, ErrUnauthenticated("invalid access token"))
+	}
+
+	// the client is retrieved again, so removed clients
+	// can't keep using the tokens they were issued
+	client, err := cc.clients.Get(r.Context(), token.Subject)
+	if err != nil {
+		RequestLogger(r).Error(err.Error())
+		return nil, failAuth(AuthFailureInvalidToken, ErrUnauthenticated("invalid access token"))
+	}
+
+	return client, nil
+}
+
+// token of the `Authorization: Bearer <token>` header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
 // [Must be used under `MiddlewareGated`]
-// Check if the user is of roles `roleTypes`
-func (cc *CommandCenter) MiddlewareWithRoles(roleTypes ...string) func(http.Handler) http.Handler {
-	for _, roleType := range roleTypes {
-		if !linkr.IsRole(roleType) {
-			panic(fmt.Sprintf("no such role type '%s'. only support %v", roleType, linkr.SupportedListOfRoles()))
+// Check if the scope of the user allows all of the `actions`
+func (cc *CommandCenter) MiddlewareRequire(actions ...string) func(http.Handler) http.Handler {
+	for _, action := range actions {
+		if !linkr.IsAction(action) {
+			panic(fmt.Sprintf("no such action '%s'. only support %v", action, linkr.SupportedActions()))
 		}
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			client := r.Context().Value(CtxLinkrClient).(*LinkrClient)
+			client, _ := r.Context().Value(CtxLinkrClient).(*LinkrClient)
 
 			if client == nil {
-				slog.Error("user entity is not attached as part of the request")
-				http.Error(w, "something went wrong. please try again later", http.StatusInternalServerError)
+				writeError(w, r, errors.New("user entity is not attached as part of the request"))
 				return
 			}
 
-			if !includes(roleTypes, client.Role) {
-				http.Error(w, "operation not allowed", http.StatusForbidden)
+			granted, err := client.Actions()
+			if err != nil {
+				RequestLogger(r).Error(fmt.Sprintf("client has an invalid scope: %s", err.Error()))
+				writeError(w, r, ErrForbidden("operation not allowed"))
+				return
+			}
+
+			if !linkr.ScopeAllows(granted, actions...) {
+				writeError(w, r, ErrForbidden("operation not allowed"))
 				return
 			}
 
@@ -146,8 +311,25 @@ type LinkrClient struct {
 	Id          string         `db:"id"`
 	Username    string         `db:"username"`
 	Description sql.NullString `db:"description"`
-	Role        string         `db:"scope"`
-	SigningKey  string         `db:"signing_key"`
-	CreatedAt   time.Time      `db:"created_at"`
-	UpdatedAt   time.Time      `db:"updated_at"`
+	// comma separated actions and roles. see `linkr.ParseScope`
+	Scope      string `db:"scope"`
+	SigningKey string `db:"signing_key"`
+	// algorithm the requests are signed with. see `SupportedSigningAlgorithms`
+	Algorithm string `db:"algorithm"`
+	// when the client was disabled. disabled clients can't authenticate
+	DisabledAt sql.NullTime `db:"disabled_at"`
+	// last time the client authenticated. see `ClientUsageTracker`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	// comma separated hosts the links of the client can point to.
+	// any host is allowed when not set
+	AllowHosts sql.NullString `db:"allow_hosts"`
+	// comma separated hosts the links of the client can't point to
+	DenyHosts sql.NullString `db:"deny_hosts"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+}
+
+// actions the scope of the client allows
+func (c *LinkrClient) Actions() ([]string, error) {
+	return linkr.ParseScope(c.Scope)
 }
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/lucsky/cuid"
+)
+
+// signs the request as the client, with its base64 encoded `key`
+func signTestRequest(t *testing.T, r *http.Request, clientId string, key string, body string) {
+	t.Helper()
+
+	token := LinkrJWToken{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: body}
+	token.Payload.IssuedAt = jwt.NumericDate(time.Now())
+	token.Payload.JWTID = cuid.New()
+
+	signTestToken(t, r, clientId, key, token)
+}
+
+func signTestToken(t *testing.T, r *http.Request, clientId string, key string, token LinkrJWToken) {
+	t.Helper()
+
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signTestTokenWith(t, r, clientId, jwt.NewHS256(secret), token)
+}
+
+// signs the request as the client, with the algorithm holding its private key
+func signTestTokenWith(t *testing.T, r *http.Request, clientId string, alg jwt.Algorithm, token LinkrJWToken) {
+	t.Helper()
+
+	if token.Payload.IssuedAt == nil {
+		token.Payload.IssuedAt = jwt.NumericDate(time.Now())
+		token.Payload.JWTID = cuid.New()
+		token.Method = r.Method
+		token.Path = r.URL.Path
+		token.Query = r.URL.RawQuery
+	}
+
+	token.Payload.Subject = clientId
+	digest, err := jwt.Sign(token, alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set(HeaderLinkrApiKey, base64.StdEncoding.EncodeToString([]byte(clientId)))
+	r.Header.Set(HeaderLinkrDigest, base64.StdEncoding.EncodeToString(digest))
+}
+
+// status of a request to a route behind `MiddlewareGated`, signed with `key`
+func gatedStatus(t *testing.T, cc *CommandCenter, clientId string, key string) int {
+	t.Helper()
+
+	h := cc.MiddlewareGated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/links", nil)
+	signTestRequest(t, req, clientId, key, "")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestMiddlewareGatedVerifiesRequest(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	key, _ := generateSigningKey()
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_1', 'bot', 'admin', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, key)
+
+	cc := NewCommandCenter(NewSQLClientStore(db), NewMemoryNonceStore(), time.Minute, NewTokenIssuer([]byte("secret"), DefaultAccessTokenTTL), nil, nil)
+
+	// echoes the body the handler receives
+	h := cc.MiddlewareGated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"redirect_url": "https://examp.le"}`
+	signed := func(method, path, body string, token LinkrJWToken) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		signTestToken(t, req, "api_1", key, token)
+		return req
+	}
+
+	fresh := func() LinkrJWToken {
+		token := LinkrJWToken{Method: http.MethodPost, Path: "/v1/api/create", Body: body}
+		token.Payload.IssuedAt = jwt.NumericDate(time.Now())
+		token.Payload.JWTID = cuid.New()
+		return token
+	}
+
+	token := fresh()
+	rec := serve(signed(http.MethodPost, "/v1/api/create", body, token))
+	if rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Fatalf("expected the body to reach the handler, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(signed(http.MethodPost, "/v1/api/create", body, token)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a replayed digest to be rejected, got %d", rec.Code)
+	}
+
+	stale := fresh()
+	stale.Payload.IssuedAt = jwt.NumericDate(time.Now().Add(-2 * time.Minute))
+	if rec := serve(signed(http.MethodPost, "/v1/api/create", body, stale)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a stale digest to be rejected, got %d", rec.Code)
+	}
+
+	noNonce := fresh()
+	noNonce.Payload.JWTID = ""
+	if rec := serve(signed(http.MethodPost, "/v1/api/create", body, noNonce)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a digest without nonce to be rejected, got %d", rec.Code)
+	}
+
+	for name, req := range map[string]*http.Request{
+		"other body":   signed(http.MethodPost, "/v1/api/create", `{"redirect_url": "https://evil.example"}`, fresh()),
+		"other path":   signed(http.MethodPost, "/v1/api/client/create", body, fresh()),
+		"other method": signed(http.MethodPatch, "/v1/api/create", body, fresh()),
+	} {
+		if rec := serve(req); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected the digest to be rejected, got %d", name, rec.Code)
+		}
+	}
+
+	listed := func(query string) LinkrJWToken {
+		token := fresh()
+		token.Method, token.Path, token.Body, token.Query = http.MethodGet, "/v1/api/links", "", query
+		return token
+	}
+
+	if rec := serve(signed(http.MethodGet, "/v1/api/links?namespace=d&limit=10", "", listed("limit=10&namespace=d"))); rec.Code != http.StatusOK {
+		t.Errorf("expected the order of the query parameters not to matter, got %d", rec.Code)
+	}
+
+	for name, req := range map[string]*http.Request{
+		"other query":    signed(http.MethodGet, "/v1/api/links?namespace=e", "", listed("namespace=d")),
+		"added query":    signed(http.MethodGet, "/v1/api/links?namespace=d", "", listed("")),
+		"repeated value": signed(http.MethodGet, "/v1/api/links?namespace=d&namespace=e", "", listed("namespace=d")),
+	} {
+		if rec := serve(req); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected the digest to be rejected, got %d", name, rec.Code)
+		}
+	}
+
+	undigested := httptest.NewRequest(http.MethodPost, "/v1/api/create", strings.NewReader(body))
+	undigested.Header.Set(HeaderLinkrApiKey, base64.StdEncoding.EncodeToString([]byte("api_1")))
+	if rec := serve(undigested); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request without digest to be rejected, got %d", rec.Code)
+	}
+
+	large := strings.Repeat("a", MaxSignedBodySize+1)
+	if rec := serve(signed(http.MethodPost, "/v1/api/create", large, fresh())); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected bodies over the limit to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestLegacySigningKeyIsRefused(t *testing.T) {
+	db, _ := newTestDB(t)
+	db.MustExec(`INSERT INTO "ApiClient" (id, username, scope, signing_key, created_at, updated_at) VALUES ('api_old', 'old', 'admin', ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, legacySigningKey)
+
+	clients := NewSQLClientStore(db)
+	cc := NewCommandCenter(clients, NewMemoryNonceStore(), DefaultDigestMaxAge, NewTokenIssuer([]byte("secret"), DefaultAccessTokenTTL), nil, nil)
+
+	if got := gatedStatus(t, cc, "api_old", legacySigningKey); got != http.StatusUnauthorized {
+		t.Errorf("expected the legacy key to be refused, got %d", got)
+	}
+
+	rotated, err := RotateLegacySigningKeys(context.Background(), clients)
+	if err != nil || len(rotated) != 1 || rotated[0] != "api_old" {
+		t.Fatalf("expected the client to be given a new key, got %v %v", rotated, err)
+	}
+
+	client, _ := clients.Get(context.Background(), "api_old")
+	if client.SigningKey == legacySigningKey {
+		t.Fatal("expected the legacy key to be replaced")
+	}
+
+	// it isn't kept through a grace window either
+	if got := gatedStatus(t, cc, "api_old", legacySigningKey); got != http.StatusUnauthorized {
+		t.Errorf("expected the legacy key to be refused after the rotation, got %d", got)
+	}
+
+	if got := gatedStatus(t, cc, "api_old", client.SigningKey); got != http.StatusOK {
+		t.Errorf("expected the new key to be accepted, got %d", got)
+	}
+
+	if rotated, _ := RotateLegacySigningKeys(context.Background(), clients); len(rotated) != 0 {
+		t.Errorf("expected nothing left to rotate, got %v", rotated)
+	}
+}
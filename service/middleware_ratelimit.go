@@ -0,0 +1,209 @@
+// Rate limiting of the requests made to the api
+package service
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// Number of requests allowed within a window.
+// Zero requests means there's no limit
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Parses limits written as `<requests>/<window>`, like `100/1m`
+func ParseRateLimit(s string) (RateLimit, error) {
+	requests, window, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit '%s' must look like 100/1m", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("requests of rate limit '%s' must be a positive number", s)
+	}
+
+	w, err := linkr.ConvertStringDurationToSeconds(window)
+	if err != nil {
+		return RateLimit{}, fmt.Errorf("window of rate limit '%s': %w", s, err)
+	}
+
+	return RateLimit{Requests: n, Window: w}, nil
+}
+
+// Limits of the requests made to the api
+type RateLimits struct {
+	// requests of an ip address, checked before authentication
+	PerIP RateLimit
+	// requests of an authenticated client
+	PerClient RateLimit
+	// replaces `PerClient` for the clients whose scope is
+	// exactly the actions of the role
+	PerRole map[string]RateLimit
+}
+
+// limit of the requests of `client`
+func (l *RateLimits) forClient(client *LinkrClient) RateLimit {
+	actions, err := client.Actions()
+	if err != nil {
+		return l.PerClient
+	}
+
+	role, ok := linkr.RoleOfScope(actions)
+	if !ok {
+		return l.PerClient
+	}
+
+	if limit, ok := l.PerRole[role]; ok {
+		return limit
+	}
+
+	return l.PerClient
+}
+
+// Creates the limits from the environment
+//
+//   - LINKR_RATELIMIT_IP: limit per ip address. defaults to `60/1m`
+//   - LINKR_RATELIMIT_CLIENT: limit per client. defaults to `300/1m`
+//   - LINKR_RATELIMIT_<ROLE>: limit per client of the role, like
+//     LINKR_RATELIMIT_READ_ONLY
+//
+// Limits are written as `<requests>/<window>`. `0/1m` disables the limit
+func NewRateLimitsFromEnv() (*RateLimits, error) {
+	limits := &RateLimits{
+		PerIP:     RateLimit{Requests: 60, Window: time.Minute},
+		PerClient: RateLimit{Requests: 300, Window: time.Minute},
+		PerRole:   map[string]RateLimit{},
+	}
+
+	envs := map[string]*RateLimit{
+		"LINKR_RATELIMIT_IP":     &limits.PerIP,
+		"LINKR_RATELIMIT_CLIENT": &limits.PerClient,
+	}
+
+	for env, limit := range envs {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env, err)
+		}
+
+		*limit = parsed
+	}
+
+	for _, role := range linkr.SupportedListOfRoles() {
+		env := "LINKR_RATELIMIT_" + strings.ToUpper(strings.ReplaceAll(role, "-", "_"))
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", env, err)
+		}
+
+		limits.PerRole[role] = parsed
+	}
+
+	return limits, nil
+}
+
+// Limits the requests made to the api, counting them in `store`
+type RateLimiter struct {
+	store  RateLimitStore
+	limits *RateLimits
+	// proxies the address of the client is taken from. optional
+	proxies *TrustedProxies
+}
+
+func NewRateLimiter(store RateLimitStore, limits *RateLimits, proxies *TrustedProxies) *RateLimiter {
+	return &RateLimiter{
+		store:   store,
+		limits:  limits,
+		proxies: proxies,
+	}
+}
+
+// Middleware limiting the requests of each ip address.
+// Placed before authentication, so keys can't be guessed at will
+func (rl *RateLimiter) MiddlewareByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.allow(w, r, "ip:"+rl.proxies.clientIP(r), rl.limits.PerIP) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// [Must be used under `MiddlewareGated`]
+// Middleware limiting the requests of each client, following their role
+func (rl *RateLimiter) MiddlewareByClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _ := r.Context().Value(CtxLinkrClient).(*LinkrClient)
+		if client == nil {
+			writeError(w, r, fmt.Errorf("user entity is not attached as part of the request"))
+			return
+		}
+
+		if !rl.allow(w, r, "client:"+client.Id, rl.limits.forClient(client)) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Counts the request against the limit of `key`, describing the limit in
+// the headers. Responds with `429 Too Many Requests` when it's exceeded
+func (rl *RateLimiter) allow(w http.ResponseWriter, r *http.Request, key string, limit RateLimit) bool {
+	if limit.Requests <= 0 {
+		return true
+	}
+
+	count, resetAt, err := rl.store.Increment(r.Context(), key, limit.Window)
+	if err != nil {
+		// an unreachable store shouldn't take the api down with it
+		RequestLogger(r).Error(fmt.Sprintf("couldn't count request against the rate limit: %s", err.Error()))
+		return true
+	}
+
+	reset := int(math.Ceil(time.Until(resetAt).Seconds()))
+	if reset < 0 {
+		reset = 0
+	}
+
+	h := w.Header()
+	h.Set(HeaderRateLimitLimit, strconv.Itoa(limit.Requests))
+	h.Set(HeaderRateLimitRemaining, strconv.Itoa(max(limit.Requests-count, 0)))
+	h.Set(HeaderRateLimitReset, strconv.Itoa(reset))
+
+	if count > limit.Requests {
+		h.Set(HeaderRetryAfter, strconv.Itoa(reset))
+		writeError(w, r, ErrRateLimited(fmt.Sprintf("rate limit exceeded. retry in %d seconds", reset)))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RateLimit
+		err  bool
+	}{
+		{"100/1m", RateLimit{Requests: 100, Window: time.Minute}, false},
+		{"0/1s", RateLimit{Requests: 0, Window: time.Second}, false},
+		{"100", RateLimit{}, true},
+		{"many/1m", RateLimit{}, true},
+		{"100/soon", RateLimit{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestNewRateLimitsFromEnv(t *testing.T) {
+	t.Setenv("LINKR_RATELIMIT_IP", "10/1s")
+	t.Setenv("LINKR_RATELIMIT_READ_ONLY", "1000/1m")
+
+	limits, err := NewRateLimitsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limits.PerIP != (RateLimit{Requests: 10, Window: time.Second}) {
+		t.Errorf("unexpected ip limit %+v", limits.PerIP)
+	}
+
+	if got := limits.forClient(&LinkrClient{Scope: linkr.RoleReadOnly}); got.Requests != 1000 {
+		t.Errorf("expected the limit of read-only clients, got %+v", got)
+	}
+
+	if got := limits.forClient(&LinkrClient{Scope: linkr.RoleAdmin}); got != limits.PerClient {
+		t.Errorf("expected the client limit for roles without one, got %+v", got)
+	}
+
+	t.Setenv("LINKR_RATELIMIT_ADMIN", "lots")
+	if _, err := NewRateLimitsFromEnv(); err == nil {
+		t.Error("expected invalid limits to be rejected")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), &RateLimits{
+		PerIP:     RateLimit{Requests: 3, Window: time.Minute},
+		PerClient: RateLimit{Requests: 1, Window: time.Minute},
+		PerRole:   map[string]RateLimit{linkr.RoleAdmin: {Requests: 2, Window: time.Minute}},
+	}, nil)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	byIP := limiter.MiddlewareByIP(ok)
+	byClient := limiter.MiddlewareByClient(ok)
+
+	request := func(h http.Handler, addr string, client *LinkrClient) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/links", nil)
+		req.RemoteAddr = addr
+		if client != nil {
+			req = req.WithContext(context.WithValue(req.Context(), CtxLinkrClient, client))
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, remaining := range []string{"2", "1", "0"} {
+		rec := request(byIP, "203.0.113.7:1000", nil)
+		if rec.Code != http.StatusOK || rec.Header().Get(HeaderRateLimitRemaining) != remaining || rec.Header().Get(HeaderRateLimitLimit) != "3" {
+			t.Fatalf("request %d: unexpected %d with headers %v", i, rec.Code, rec.Header())
+		}
+	}
+
+	rec := request(byIP, "203.0.113.7:1000", nil)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(HeaderRetryAfter) == "" {
+		t.Fatalf("expected the 4th request to be limited, got %d with headers %v", rec.Code, rec.Header())
+	}
+
+	if code := decodeError(t, rec).Code; code != ErrCodeRateLimited {
+		t.Errorf("unexpected error code %s", code)
+	}
+
+	if rec := request(byIP, "198.51.100.1:1000", nil); rec.Code != http.StatusOK {
+		t.Errorf("expected other addresses to have their own limit, got %d", rec.Code)
+	}
+
+	writer := &LinkrClient{Id: "writer", Scope: linkr.RoleWriteOnly}
+	admin := &LinkrClient{Id: "admin", Scope: linkr.RoleAdmin}
+
+	codes := []int{}
+	for _, client := range []*LinkrClient{writer, writer, admin, admin, admin} {
+		codes = append(codes, request(byClient, "203.0.113.7:1000", client).Code)
+	}
+
+	want := []int{200, 429, 200, 200, 429}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("expected %v following the limits of the roles, got %v", want, codes)
+		}
+	}
+}
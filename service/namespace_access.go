@@ -0,0 +1,169 @@
+// Decides what clients can do in each namespace
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+// client attached to the request by `MiddlewareGated`
+func requestClient(r *http.Request) (*LinkrClient, error) {
+	client, _ := r.Context().Value(CtxLinkrClient).(*LinkrClient)
+	if client == nil {
+		return nil, errors.New("user entity is not attached as part of the request")
+	}
+
+	return client, nil
+}
+
+// check if the client manages every namespace, rather than the ones
+// it owns or is a member of
+func managesAllNamespaces(client *LinkrClient) bool {
+	actions, err := client.Actions()
+	return err == nil && linkr.ScopeAllows(actions, linkr.ActionNamespacesManage)
+}
+
+// Role of the client in the namespace. Clients managing all namespaces,
+// and the owner, are admins of it. Empty when the client has no access
+func (a *ApiHandler) namespaceRole(ctx context.Context, ns *LinkrNamespace, client *LinkrClient) (string, error) {
+	if managesAllNamespaces(client) || ns.OwnerId.String == client.Id {
+		return linkr.RoleAdmin, nil
+	}
+
+	member, err := a.stores.Namespaces.Member(ctx, ns.Id, client.Id)
+	if errors.Is(err, ErrRecordNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("couldn't retrieve the role of client %s in namespace %d: %w", client.Id, ns.Id, err)
+	}
+
+	return member.Role, nil
+}
+
+// Checks the client can take `action` in the namespace.
+//
+// Along with its scope allowing the action, the client must either
+// manage all namespaces, own the namespace, or be a member whose role
+// in the namespace bundles the action. Anyone can use the global namespace
+func (a *ApiHandler) authorizeNamespace(r *http.Request, ns *LinkrNamespace, action string) error {
+	client, err := requestClient(r)
+	if err != nil {
+		return err
+	}
+
+	actions, err := client.Actions()
+	if err != nil || !linkr.ScopeAllows(actions, action) {
+		return ErrForbidden("operation not allowed")
+	}
+
+	if ns.Tag == linkr.ReservedGlobalChar {
+		return nil
+	}
+
+	role, err := a.namespaceRole(r.Context(), ns, client)
+	if err != nil {
+		return err
+	}
+
+	if bundled, ok := linkr.RoleActions(role); ok && linkr.ScopeAllows(bundled, action) {
+		return nil
+	}
+
+	return ErrForbidden(fmt.Sprintf("operation not allowed in namespace '%s'", ns.Tag))
+}
+
+// Checks the client can manage the namespace and its members, which
+// only its owner, its admins and clients managing all namespaces can
+func (a *ApiHandler) authorizeNamespaceAdmin(r *http.Request, ns *LinkrNamespace) error {
+	client, err := requestClient(r)
+	if err != nil {
+		return err
+	}
+
+	role, err := a.namespaceRole(r.Context(), ns, client)
+	if err != nil {
+		return err
+	}
+
+	if role != linkr.RoleAdmin {
+		return ErrForbidden(fmt.Sprintf("only the admins of namespace '%s' can manage it", ns.Tag))
+	}
+
+	return nil
+}
+
+// Checks the client can see the namespace. Anyone can see the global
+// namespace, otherwise the client needs a role in it
+func (a *ApiHandler) authorizeNamespaceMember(r *http.Request, ns *LinkrNamespace) error {
+	client, err := requestClient(r)
+	if err != nil {
+		return err
+	}
+
+	if ns.Tag == linkr.ReservedGlobalChar {
+		return nil
+	}
+
+	role, err := a.namespaceRole(r.Context(), ns, client)
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		// the namespace isn't revealed to clients outside of it
+		return ErrNotFound("namespace not found")
+	}
+
+	return nil
+}
+
+// Checks the client can take `action` on the link, in its namespace.
+// Links of namespaces the client has no role in are reported as not found
+func (a *ApiHandler) authorizeLink(r *http.Request, link *NamespacedLink, action string) error {
+	ns, err := a.stores.Namespaces.Get(r.Context(), int64(link.NamespaceId))
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve the namespace of link %d: %w", link.Id, err)
+	}
+
+	client, err := requestClient(r)
+	if err != nil {
+		return err
+	}
+
+	if ns.Tag != linkr.ReservedGlobalChar {
+		role, err := a.namespaceRole(r.Context(), ns, client)
+		if err != nil {
+			return err
+		}
+
+		if role == "" {
+			// the link isn't revealed to clients outside of its namespace
+			return ErrNotFound("link not found")
+		}
+	}
+
+	return a.authorizeNamespace(r, ns, action)
+}
+
+// Namespaces the client can take `action` in. Without an action,
+// the namespaces the client has any role in
+func namespaceAccess(client *LinkrClient, action string) NamespaceAccess {
+	if managesAllNamespaces(client) {
+		return NamespaceAccess{All: true}
+	}
+
+	access := NamespaceAccess{ClientId: client.Id}
+	for _, role := range linkr.SupportedListOfRoles() {
+		if bundled, _ := linkr.RoleActions(role); action == "" || linkr.ScopeAllows(bundled, action) {
+			access.Roles = append(access.Roles, role)
+		}
+	}
+
+	return access
+}
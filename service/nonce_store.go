@@ -0,0 +1,81 @@
+// Remembers the nonces of the signed requests, to reject replays
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Nonces used by the signed requests, until they expire
+type NonceStore interface {
+	// Marks `nonce` as used until `expiresAt`.
+	// Returns false when it was already used and hasn't expired
+	Use(ctx context.Context, nonce string, now time.Time, expiresAt time.Time) (bool, error)
+}
+
+// Keeps the nonces in memory. Nonces aren't shared
+// between instances, nor kept across restarts
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	// expired nonces are removed every so many uses
+	sweepEvery int
+	uses       int
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces:     map[string]time.Time{},
+		sweepEvery: 1000,
+	}
+}
+
+func (m *MemoryNonceStore) Use(ctx context.Context, nonce string, now time.Time, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uses++
+	if m.uses >= m.sweepEvery {
+		m.uses = 0
+		for n, exp := range m.nonces {
+			if !now.Before(exp) {
+				delete(m.nonces, n)
+			}
+		}
+	}
+
+	if exp, ok := m.nonces[nonce]; ok && now.Before(exp) {
+		return false, nil
+	}
+
+	m.nonces[nonce] = expiresAt
+	return true, nil
+}
+
+// Keeps the nonces in redis, or anything speaking its protocol,
+// so a request signed once can't be replayed against another instance
+type RedisNonceStore struct {
+	client redis.StringCmdable
+	// prepended to the keys of the nonces
+	prefix string
+}
+
+func NewRedisNonceStore(client redis.StringCmdable, prefix string) *RedisNonceStore {
+	return &RedisNonceStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *RedisNonceStore) Use(ctx context.Context, nonce string, now time.Time, expiresAt time.Time) (bool, error) {
+	ttl := expiresAt.Sub(now)
+	if ttl < time.Millisecond {
+		// a key without expiry would be kept forever
+		ttl = time.Millisecond
+	}
+
+	return s.client.SetNX(ctx, s.prefix+nonce, 1, ttl).Result()
+}
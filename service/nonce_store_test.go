@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryNonceStore(t *testing.T) {
+	now := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryNonceStore()
+	store.sweepEvery = 1
+
+	ctx := context.Background()
+	if fresh, _ := store.Use(ctx, "a", now, now.Add(time.Minute)); !fresh {
+		t.Fatal("expected the first use of the nonce to be accepted")
+	}
+
+	if fresh, _ := store.Use(ctx, "a", now.Add(time.Second), now.Add(time.Minute)); fresh {
+		t.Error("expected the nonce not to be used twice")
+	}
+
+	if fresh, _ := store.Use(ctx, "b", now.Add(time.Minute), now.Add(2*time.Minute)); !fresh {
+		t.Error("expected other nonces to be accepted")
+	}
+
+	if _, ok := store.nonces["a"]; ok {
+		t.Error("expected expired nonces to be swept")
+	}
+}
+
+func TestRedisNonceStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	// two instances sharing the nonces
+	first := NewRedisNonceStore(client, "test:")
+	second := NewRedisNonceStore(client, "test:")
+
+	ctx := context.Background()
+	now := time.Now()
+	if fresh, err := first.Use(ctx, "api_1:a", now, now.Add(time.Minute)); err != nil || !fresh {
+		t.Fatalf("expected the first use of the nonce to be accepted, got %v %v", fresh, err)
+	}
+
+	if fresh, _ := second.Use(ctx, "api_1:a", now, now.Add(time.Minute)); fresh {
+		t.Error("expected the nonce not to be used again on another instance")
+	}
+
+	if ttl := mr.TTL("test:api_1:a"); ttl != time.Minute {
+		t.Errorf("expected the nonce to be kept until it expires, got %s", ttl)
+	}
+
+	mr.FastForward(time.Minute)
+	if fresh, _ := second.Use(ctx, "api_1:a", now, now.Add(time.Minute)); !fresh {
+		t.Error("expected expired nonces to be forgotten")
+	}
+
+	mr.Close()
+	if _, err := first.Use(ctx, "api_1:b", now, now.Add(time.Minute)); err == nil {
+		t.Error("expected an error when redis is unreachable")
+	}
+}
@@ -0,0 +1,109 @@
+// Counters behind the rate limits
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Counts the requests of a key within fixed windows
+type RateLimitStore interface {
+	// Counts a request of `key` in its current window, opening a window of
+	// length `window` when there's none. Returns the requests counted in
+	// the window and when it ends
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+type rateLimitWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// Keeps the counters in memory. Limits aren't shared
+// between instances, nor kept across restarts
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	windows map[string]*rateLimitWindow
+	// ended windows are removed every so many increments
+	sweepEvery int
+	increments int
+
+	now func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows:    map[string]*rateLimitWindow{},
+		sweepEvery: 1000,
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryRateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	m.increments++
+	if m.increments >= m.sweepEvery {
+		m.increments = 0
+		for k, w := range m.windows {
+			if !now.Before(w.resetAt) {
+				delete(m.windows, k)
+			}
+		}
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = &rateLimitWindow{resetAt: now.Add(window)}
+		m.windows[key] = w
+	}
+
+	w.count++
+	return w.count, w.resetAt, nil
+}
+
+// increments the counter, starting its expiry on the first request of the window
+var rateLimitScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {count, redis.call('PTTL', KEYS[1])}
+`)
+
+// Keeps the counters in redis, or anything speaking its protocol,
+// so the limits are shared between instances
+type RedisRateLimitStore struct {
+	client redis.Scripter
+	// prepended to the keys of the counters
+	prefix string
+}
+
+func NewRedisRateLimitStore(client redis.Scripter, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *RedisRateLimitStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	res, err := rateLimitScript.Run(ctx, s.client, []string{s.prefix + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	ttl := time.Duration(res[1]) * time.Millisecond
+	if ttl < 0 {
+		// counter has no expiry, which only happens if it was
+		// created outside of this store
+		ttl = window
+	}
+
+	return int(res[0]), time.Now().Add(ttl), nil
+}
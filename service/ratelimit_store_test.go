@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryRateLimitStoreWindows(t *testing.T) {
+	now := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	store.sweepEvery = 1
+
+	ctx := context.Background()
+	store.Increment(ctx, "a", time.Minute)
+	if n, resetAt, _ := store.Increment(ctx, "a", time.Minute); n != 2 || !resetAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected 2 requests in the window, got %d ending %s", n, resetAt)
+	}
+
+	now = now.Add(time.Minute)
+	if n, _, _ := store.Increment(ctx, "a", time.Minute); n != 1 {
+		t.Errorf("expected the count to restart in the next window, got %d", n)
+	}
+
+	store.Increment(ctx, "b", time.Second)
+	now = now.Add(time.Second)
+	store.Increment(ctx, "c", time.Second)
+	if _, ok := store.windows["b"]; ok {
+		t.Error("expected ended windows to be swept")
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test:")
+
+	ctx := context.Background()
+	for want := 1; want <= 3; want++ {
+		n, resetAt, err := store.Increment(ctx, "client:a", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != want || time.Until(resetAt) > time.Minute || time.Until(resetAt) < 50*time.Second {
+			t.Fatalf("expected %d requests ending within the minute, got %d ending %s", want, n, resetAt)
+		}
+	}
+
+	if ttl := mr.TTL("test:client:a"); ttl != time.Minute {
+		t.Errorf("expected the window to expire with its first request, got %s", ttl)
+	}
+
+	mr.FastForward(time.Minute)
+	if n, _, _ := store.Increment(ctx, "client:a", time.Minute); n != 1 {
+		t.Errorf("expected the count to restart in the next window, got %d", n)
+	}
+
+	mr.Close()
+	if _, _, err := store.Increment(ctx, "client:a", time.Minute); err == nil {
+		t.Error("expected an error when redis is unreachable")
+	}
+}
@@ -0,0 +1,85 @@
+// Differences between the sql of sqlite (and libsql) and postgres.
+// Queries are written for sqlite, with `?` placeholders, and adapted
+// to the driver they run on
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	"iam-kevin/linkr/migrations"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func isPostgres(driverName string) bool {
+	return migrations.Dialect(driverName) == migrations.DialectPostgres
+}
+
+// `expr` as a timestamp that compares in time order. sqlite keeps
+// timestamps as text, in the format of whoever wrote them
+func sqlTime(driverName, expr string) string {
+	if isPostgres(driverName) {
+		return expr
+	}
+
+	return "datetime(" + expr + ")"
+}
+
+// `expr` as utc `YYYY-MM-DD HH:MM:SS` text (see `time.DateTime`)
+func sqlTimeText(driverName, expr string) string {
+	if isPostgres(driverName) {
+		return "to_char(" + expr + " AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')"
+	}
+
+	return "datetime(" + expr + ")"
+}
+
+// Database rebinding the `?` placeholders of the queries
+// to the placeholders of its driver
+type sqlDB struct {
+	*sqlx.DB
+}
+
+func (db sqlDB) time(expr string) string {
+	return sqlTime(db.DriverName(), expr)
+}
+
+func (db sqlDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.DB.GetContext(ctx, dest, db.Rebind(query), args...)
+}
+
+func (db sqlDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.DB.SelectContext(ctx, dest, db.Rebind(query), args...)
+}
+
+func (db sqlDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.Rebind(query), args...)
+}
+
+func (db sqlDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (sqlTx, error) {
+	tx, err := db.DB.BeginTxx(ctx, opts)
+	return sqlTx{tx}, err
+}
+
+// Transaction rebinding the `?` placeholders of the queries
+// to the placeholders of its driver
+type sqlTx struct {
+	*sqlx.Tx
+}
+
+func (tx sqlTx) time(expr string) string {
+	return sqlTime(tx.DriverName(), expr)
+}
+
+func (tx sqlTx) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return tx.Tx.GetContext(ctx, dest, tx.Rebind(query), args...)
+}
+
+func (tx sqlTx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return tx.Tx.SelectContext(ctx, dest, tx.Rebind(query), args...)
+}
+
+func (tx sqlTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.Rebind(query), args...)
+}
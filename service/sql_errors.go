@@ -0,0 +1,22 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// checks if the error is caused by a violated unique constraint
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if pqErr := new(pq.Error); errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	// sqlite and libsql share the message
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,224 @@
+// Storage of the links, namespaces, clients and clicks, keeping the
+// queries out of the handlers
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+var (
+	// the record doesn't exist
+	ErrRecordNotFound = errors.New("record not found")
+	// the record collides with an existing one
+	ErrRecordExists = errors.New("record already exists")
+	// the record can't be removed while others depend on it
+	ErrRecordInUse = errors.New("record is in use")
+)
+
+// Stores backing the handlers
+type Stores struct {
+	Links      LinkStore
+	Namespaces NamespaceStore
+	Clients    ClientStore
+	Stats      StatsStore
+}
+
+// Namespaces a client can access. Empty access reaches the global namespace only
+type NamespaceAccess struct {
+	// every namespace can be accessed, the rest is left out
+	All bool
+	// namespaces owned by the client, or where it's a member
+	ClientId string
+	// roles the membership of the client must have
+	Roles []string
+}
+
+// checks the access reaches the namespace, where the client has `role`
+func (access NamespaceAccess) allows(ns *LinkrNamespace, role string) bool {
+	if access.All || ns.Tag == linkr.ReservedGlobalChar {
+		return true
+	}
+
+	if access.ClientId == "" {
+		return false
+	}
+
+	return ns.OwnerId.String == access.ClientId || (role != "" && includes(access.Roles, role))
+}
+
+type LinkFilter struct {
+	Access NamespaceAccess
+
+	// tag of the namespace the links belong to
+	Namespace     string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// active | expired, at `Now`
+	Status string
+	Now    time.Time
+	// start of the destination url
+	DestinationPrefix string
+	// links older than the link, for pagination
+	BeforeId int
+	Limit    int
+}
+
+// changes of a link. nil fields are left as they are
+type LinkUpdate struct {
+	DestinationUrl *string
+	ForwardMode    *string
+
+	// replaces the expiry with `ExpiresIn` and `ExpiresAt`,
+	// which is nil for links that never expire
+	SetExpiry bool
+	ExpiresIn int64
+	ExpiresAt *time.Time
+}
+
+type LinkStore interface {
+	// link `identifier` in the namespace `namespaceId`
+	Get(ctx context.Context, namespaceId int64, identifier string) (*Link, error)
+	// link `identifier` in the namespace tagged `namespace`
+	GetByTag(ctx context.Context, namespace string, identifier string) (*NamespacedLink, error)
+	// links matching the filter, newest first
+	List(ctx context.Context, filter LinkFilter) ([]NamespacedLink, error)
+	// saves the link, setting its id and creation time.
+	// `ErrRecordExists` when the identifier is taken in the namespace
+	Create(ctx context.Context, link *Link) error
+	Update(ctx context.Context, id int, update LinkUpdate) error
+	// deletes the link, along with its clicks
+	Delete(ctx context.Context, id int) error
+	// the `candidates` taken in the namespace
+	TakenIdentifiers(ctx context.Context, namespaceId int64, candidates []string) ([]string, error)
+	// increments the identifier counter of the namespace
+	NextCounterValue(ctx context.Context, namespaceId int64) (uint64, error)
+}
+
+type NamespaceFilter struct {
+	Access NamespaceAccess
+
+	IncludeArchived bool
+}
+
+// changes of a namespace. nil fields are left as they are,
+// while empty values are cleared
+type NamespaceUpdate struct {
+	Description *string
+	ExpiredUrl  *string
+	IdStrategy  *string
+	// comma separated hosts
+	AllowHosts *string
+	DenyHosts  *string
+}
+
+// client granted access to a namespace it doesn't own
+type NamespaceMember struct {
+	NamespaceId int64     `db:"namespace_id"`
+	ClientId    string    `db:"client_id"`
+	Role        string    `db:"role"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type NamespaceStore interface {
+	Get(ctx context.Context, id int64) (*LinkrNamespace, error)
+	GetByTag(ctx context.Context, tag string) (*LinkrNamespace, error)
+	// namespaces matching the filter, by their tag
+	List(ctx context.Context, filter NamespaceFilter) ([]LinkrNamespace, error)
+	// saves the namespace, setting its id.
+	// `ErrRecordExists` when the tag is taken
+	Create(ctx context.Context, ns *LinkrNamespace) error
+	Update(ctx context.Context, id int64, update NamespaceUpdate) error
+	// archives the namespace, keeping when it was first archived
+	Archive(ctx context.Context, id int64, at time.Time) error
+	// Deletes the namespace, along with its links, their clicks and its members.
+	// Responds with the number of links of the namespace. Unless `cascade`,
+	// namespaces with links are kept and `ErrRecordInUse` is responded
+	Delete(ctx context.Context, id int64, cascade bool) (int, error)
+
+	Member(ctx context.Context, namespaceId int64, clientId string) (*NamespaceMember, error)
+	// members of the namespace, oldest first
+	Members(ctx context.Context, namespaceId int64) ([]NamespaceMember, error)
+	// saves the member, or changes the role of an existing one
+	PutMember(ctx context.Context, member *NamespaceMember) error
+	DeleteMember(ctx context.Context, namespaceId int64, clientId string) error
+}
+
+type ClientFilter struct {
+	// only disabled clients when true, only active ones when false
+	Disabled *bool
+}
+
+// changes of a client. nil fields are left as they are
+type ClientUpdate struct {
+	// cleared when empty
+	Description *string
+	Scope       *string
+	// comma separated hosts, cleared when empty
+	AllowHosts *string
+	DenyHosts  *string
+}
+
+type ClientStore interface {
+	Get(ctx context.Context, id string) (*LinkrClient, error)
+	// clients matching the filter, oldest first
+	List(ctx context.Context, filter ClientFilter) ([]LinkrClient, error)
+	// saves the client, setting its creation time.
+	// `ErrRecordExists` when the username is taken
+	Create(ctx context.Context, client *LinkrClient) error
+	Update(ctx context.Context, id string, update ClientUpdate) error
+	// disables the client at `at`, or enables it when nil
+	SetDisabled(ctx context.Context, id string, at *time.Time) error
+	// Deletes the client, along with its previous keys and memberships.
+	// The namespaces it owns are left without an owner
+	Delete(ctx context.Context, id string) error
+
+	// rotated keys of the client still valid at `now`, newest first
+	PreviousKeys(ctx context.Context, id string, now time.Time) ([]SigningKey, error)
+	// Replaces the key of the client. The current key keeps working
+	// until `previousExpiresAt`, unless it's nil
+	RotateKey(ctx context.Context, id string, key string, now time.Time, previousExpiresAt *time.Time) error
+	// notes when the clients were last used. uses are never moved back
+	TouchLastUsed(ctx context.Context, uses map[string]time.Time) error
+}
+
+// clicks of a link, or of every link of a namespace, within a range
+type StatsFilter struct {
+	// one of them is set
+	LinkId      int
+	NamespaceId int64
+
+	From time.Time
+	To   time.Time
+}
+
+// clicks of a referrer host, user agent family or link
+type ClickCount struct {
+	Name   string `db:"name"`
+	Clicks int64  `db:"clicks"`
+}
+
+type StatsStore interface {
+	// saves the clicks, all of them or none
+	AddClicks(ctx context.Context, clicks []ClickEvent) error
+	// Rolls up to `limit` clicks made until `until`, following the last
+	// ones rolled up, into the counts the stats are served from.
+	// Returns how many were, and `errRollupRaced` when someone else
+	// rolled them up meanwhile
+	RollupClicks(ctx context.Context, until time.Time, limit int) (int, error)
+
+	// clicks by the hour they were made in, for the hours starting from `From`, until `To`
+	HourlyClicks(ctx context.Context, filter StatsFilter) (map[time.Time]int64, error)
+	// distinct visitors of the days from `From` to `To`, both included
+	UniqueVisitors(ctx context.Context, filter StatsFilter) (int64, error)
+	// most clicked referrer hosts of the days from `From` to `To`, both included
+	TopReferrers(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error)
+	// most clicked user agent families of the days from `From` to `To`, both included
+	TopAgents(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error)
+	// identifiers of the most clicked links of the namespace, for the hours
+	// starting from `From`, until `To`
+	TopLinks(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error)
+}
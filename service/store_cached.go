@@ -0,0 +1,141 @@
+// Stores answering the lookups of the redirects from a `LookupCache`,
+// and dropping the records they change from it
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type cachedLinkStore struct {
+	LinkStore
+	cache *LookupCache
+}
+
+func (s *cachedLinkStore) Get(ctx context.Context, namespaceId int64, identifier string) (*Link, error) {
+	key := linkKey{namespaceId: namespaceId, identifier: identifier}
+
+	cached, missing, found, generation := s.cache.links.get(key)
+	if found {
+		if missing {
+			return nil, ErrRecordNotFound
+		}
+
+		return &cached, nil
+	}
+
+	link, err := s.LinkStore.Get(ctx, namespaceId, identifier)
+	if errors.Is(err, ErrRecordNotFound) {
+		s.cache.links.put(key, Link{}, true, generation)
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.links.put(key, *link, false, generation)
+	return link, nil
+}
+
+func (s *cachedLinkStore) Create(ctx context.Context, link *Link) error {
+	err := s.LinkStore.Create(ctx, link)
+
+	// the identifier might be cached as missing
+	s.cache.links.removeKey(linkKey{namespaceId: int64(link.NamespaceId), identifier: link.Tag})
+	return err
+}
+
+func (s *cachedLinkStore) Update(ctx context.Context, id int, update LinkUpdate) error {
+	err := s.LinkStore.Update(ctx, id, update)
+	s.cache.removeLink(id)
+	return err
+}
+
+func (s *cachedLinkStore) Delete(ctx context.Context, id int) error {
+	err := s.LinkStore.Delete(ctx, id)
+	s.cache.removeLink(id)
+	return err
+}
+
+type cachedNamespaceStore struct {
+	NamespaceStore
+	cache *LookupCache
+}
+
+func (s *cachedNamespaceStore) GetByTag(ctx context.Context, tag string) (*LinkrNamespace, error) {
+	cached, missing, found, generation := s.cache.namespaces.get(tag)
+	if found {
+		if missing {
+			return nil, ErrRecordNotFound
+		}
+
+		return &cached, nil
+	}
+
+	ns, err := s.NamespaceStore.GetByTag(ctx, tag)
+	if errors.Is(err, ErrRecordNotFound) {
+		s.cache.namespaces.put(tag, LinkrNamespace{}, true, generation)
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.namespaces.put(tag, *ns, false, generation)
+	return ns, nil
+}
+
+func (s *cachedNamespaceStore) Create(ctx context.Context, ns *LinkrNamespace) error {
+	err := s.NamespaceStore.Create(ctx, ns)
+
+	// the tag might be cached as missing
+	s.cache.namespaces.removeKey(ns.Tag)
+	return err
+}
+
+func (s *cachedNamespaceStore) Update(ctx context.Context, id int64, update NamespaceUpdate) error {
+	err := s.NamespaceStore.Update(ctx, id, update)
+	s.cache.removeNamespace(id)
+	return err
+}
+
+func (s *cachedNamespaceStore) Archive(ctx context.Context, id int64, at time.Time) error {
+	err := s.NamespaceStore.Archive(ctx, id, at)
+	s.cache.removeNamespace(id)
+	return err
+}
+
+func (s *cachedNamespaceStore) Delete(ctx context.Context, id int64, cascade bool) (int, error) {
+	links, err := s.NamespaceStore.Delete(ctx, id, cascade)
+	s.cache.removeNamespace(id)
+
+	// along with the links deleted with it
+	s.cache.links.removeIndexed(namespaceIndex(id))
+	return links, err
+}
+
+type cachedClientStore struct {
+	ClientStore
+	cache *LookupCache
+}
+
+func (s *cachedClientStore) Delete(ctx context.Context, id string) error {
+	err := s.ClientStore.Delete(ctx, id)
+
+	// the namespaces it owned are left without an owner
+	s.cache.namespaces.removeIndexed(ownerIndex(id))
+	return err
+}
+
+// drops the link `id` from the cache
+func (c *LookupCache) removeLink(id int) {
+	c.links.removeIndexed(linkIndex(id))
+}
+
+// drops the namespace `id` from the cache
+func (c *LookupCache) removeNamespace(id int64) {
+	c.namespaces.removeIndexed(namespaceIndex(id))
+}
@@ -0,0 +1,789 @@
+// Stores keeping the records in memory, for the tests of the handlers
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+// records shared by the memory stores, so deleting a record
+// reaches the ones depending on it
+type memoryRecords struct {
+	mu sync.Mutex
+
+	links      map[int]Link
+	namespaces map[int64]LinkrNamespace
+	members    map[int64]map[string]NamespaceMember
+	counters   map[int64]uint64
+	clients    map[string]LinkrClient
+	keys       map[string][]clientKey
+
+	// recorded clicks, in the order they were added
+	clicks []rollupClick
+	// counts of the clicks rolled up
+	rollups *clickCounts
+	// the last click rolled up, when any was
+	rolledUp *rollupClick
+
+	lastLinkId      int
+	lastNamespaceId int64
+	lastClickId     int
+}
+
+type clientKey struct {
+	SigningKey
+	expiresAt time.Time
+}
+
+// Stores keeping the records in memory. Like the migrations,
+// they start with the global namespace
+func NewMemoryStores() *Stores {
+	records := &memoryRecords{
+		links:      map[int]Link{},
+		namespaces: map[int64]LinkrNamespace{},
+		members:    map[int64]map[string]NamespaceMember{},
+		counters:   map[int64]uint64{},
+		clients:    map[string]LinkrClient{},
+		keys:       map[string][]clientKey{},
+		rollups:    newClickCounts(),
+	}
+
+	records.lastNamespaceId++
+	records.namespaces[records.lastNamespaceId] = LinkrNamespace{Id: records.lastNamespaceId, Tag: linkr.ReservedGlobalChar}
+
+	return &Stores{
+		Links:      &MemoryLinkStore{records},
+		Namespaces: &MemoryNamespaceStore{records},
+		Clients:    &MemoryClientStore{records},
+		Stats:      &MemoryStatsStore{records},
+	}
+}
+
+// role of the client in the namespace. must be called holding the lock
+func (m *memoryRecords) roleOf(namespaceId int64, clientId string) string {
+	return m.members[namespaceId][clientId].Role
+}
+
+// drops the clicks of the link, and their counts. must be called holding the lock
+func (m *memoryRecords) dropClicks(linkId int) {
+	kept := m.clicks[:0]
+	for _, click := range m.clicks {
+		if click.LinkId != linkId {
+			kept = append(kept, click)
+		}
+	}
+	m.clicks = kept
+
+	for _, counts := range []map[rollupKey]int64{m.rollups.hourly, m.rollups.referrers, m.rollups.agents} {
+		for k := range counts {
+			if k.linkId == linkId {
+				delete(counts, k)
+			}
+		}
+	}
+
+	for k := range m.rollups.visitors {
+		if k.linkId == linkId {
+			delete(m.rollups.visitors, k)
+		}
+	}
+}
+
+// checks the access reaches the namespace. must be called holding the lock
+func (m *memoryRecords) accessible(access NamespaceAccess, ns *LinkrNamespace) bool {
+	return access.allows(ns, m.roleOf(ns.Id, access.ClientId))
+}
+
+type MemoryLinkStore struct {
+	records *memoryRecords
+}
+
+func (s *MemoryLinkStore) Get(ctx context.Context, namespaceId int64, identifier string) (*Link, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, link := range s.records.links {
+		if int64(link.NamespaceId) == namespaceId && link.Tag == identifier {
+			return &link, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (s *MemoryLinkStore) GetByTag(ctx context.Context, namespace string, identifier string) (*NamespacedLink, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, link := range s.records.links {
+		ns := s.records.namespaces[int64(link.NamespaceId)]
+		if ns.Tag == namespace && link.Tag == identifier {
+			return &NamespacedLink{Link: link, NamespaceTag: ns.Tag}, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (s *MemoryLinkStore) List(ctx context.Context, filter LinkFilter) ([]NamespacedLink, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	links := []NamespacedLink{}
+	for _, link := range s.records.links {
+		ns := s.records.namespaces[int64(link.NamespaceId)]
+
+		switch {
+		case !s.records.accessible(filter.Access, &ns),
+			filter.Namespace != "" && ns.Tag != filter.Namespace,
+			filter.CreatedAfter != nil && link.CreatedAt.Before(*filter.CreatedAfter),
+			filter.CreatedBefore != nil && !link.CreatedAt.Before(*filter.CreatedBefore),
+			filter.Status == "active" && link.IsExpiredAt(filter.Now),
+			filter.Status == "expired" && !link.IsExpiredAt(filter.Now),
+			!strings.HasPrefix(link.OriginalUrl, filter.DestinationPrefix),
+			filter.BeforeId > 0 && link.Id >= filter.BeforeId:
+			continue
+		}
+
+		links = append(links, NamespacedLink{Link: link, NamespaceTag: ns.Tag})
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].Id > links[j].Id })
+	if filter.Limit > 0 && len(links) > filter.Limit {
+		links = links[:filter.Limit]
+	}
+
+	return links, nil
+}
+
+func (s *MemoryLinkStore) Create(ctx context.Context, link *Link) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, existing := range s.records.links {
+		if existing.NamespaceId == link.NamespaceId && existing.Tag == link.Tag {
+			return ErrRecordExists
+		}
+	}
+
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now().UTC()
+	}
+
+	s.records.lastLinkId++
+	link.Id = s.records.lastLinkId
+	s.records.links[link.Id] = *link
+	return nil
+}
+
+func (s *MemoryLinkStore) Update(ctx context.Context, id int, update LinkUpdate) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	link, ok := s.records.links[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	if update.DestinationUrl != nil {
+		link.OriginalUrl = *update.DestinationUrl
+	}
+
+	if update.SetExpiry {
+		link.ExpiresIn = sql.NullInt32{Int32: int32(update.ExpiresIn), Valid: true}
+		link.ExpiresAt = sql.NullTime{}
+		if update.ExpiresAt != nil {
+			link.ExpiresAt = sql.NullTime{Time: *update.ExpiresAt, Valid: true}
+		}
+	}
+
+	if update.ForwardMode != nil {
+		link.ForwardMode = sql.NullString{String: *update.ForwardMode, Valid: true}
+	}
+
+	s.records.links[id] = link
+	return nil
+}
+
+func (s *MemoryLinkStore) Delete(ctx context.Context, id int) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	if _, ok := s.records.links[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(s.records.links, id)
+	s.records.dropClicks(id)
+	return nil
+}
+
+func (s *MemoryLinkStore) TakenIdentifiers(ctx context.Context, namespaceId int64, candidates []string) ([]string, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	taken := []string{}
+	for _, link := range s.records.links {
+		if int64(link.NamespaceId) == namespaceId && includes(candidates, link.Tag) {
+			taken = append(taken, link.Tag)
+		}
+	}
+
+	return taken, nil
+}
+
+func (s *MemoryLinkStore) NextCounterValue(ctx context.Context, namespaceId int64) (uint64, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	s.records.counters[namespaceId]++
+	return s.records.counters[namespaceId], nil
+}
+
+type MemoryNamespaceStore struct {
+	records *memoryRecords
+}
+
+func (s *MemoryNamespaceStore) Get(ctx context.Context, id int64) (*LinkrNamespace, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	ns, ok := s.records.namespaces[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &ns, nil
+}
+
+func (s *MemoryNamespaceStore) GetByTag(ctx context.Context, tag string) (*LinkrNamespace, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, ns := range s.records.namespaces {
+		if ns.Tag == tag {
+			return &ns, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (s *MemoryNamespaceStore) List(ctx context.Context, filter NamespaceFilter) ([]LinkrNamespace, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	namespaces := []LinkrNamespace{}
+	for _, ns := range s.records.namespaces {
+		if !s.records.accessible(filter.Access, &ns) || (ns.ArchivedAt.Valid && !filter.IncludeArchived) {
+			continue
+		}
+
+		namespaces = append(namespaces, ns)
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Tag < namespaces[j].Tag })
+	return namespaces, nil
+}
+
+func (s *MemoryNamespaceStore) Create(ctx context.Context, ns *LinkrNamespace) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, existing := range s.records.namespaces {
+		if existing.Tag == ns.Tag {
+			return ErrRecordExists
+		}
+	}
+
+	s.records.lastNamespaceId++
+	ns.Id = s.records.lastNamespaceId
+	s.records.namespaces[ns.Id] = *ns
+	return nil
+}
+
+func (s *MemoryNamespaceStore) Update(ctx context.Context, id int64, update NamespaceUpdate) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	ns, ok := s.records.namespaces[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	nullable(&ns.Description, update.Description)
+	nullable(&ns.ExpiredUrl, update.ExpiredUrl)
+	nullable(&ns.IdStrategy, update.IdStrategy)
+	nullable(&ns.AllowHosts, update.AllowHosts)
+	nullable(&ns.DenyHosts, update.DenyHosts)
+
+	s.records.namespaces[id] = ns
+	return nil
+}
+
+func (s *MemoryNamespaceStore) Archive(ctx context.Context, id int64, at time.Time) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	ns, ok := s.records.namespaces[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	if !ns.ArchivedAt.Valid {
+		ns.ArchivedAt = sql.NullTime{Time: at.UTC(), Valid: true}
+		s.records.namespaces[id] = ns
+	}
+
+	return nil
+}
+
+func (s *MemoryNamespaceStore) Delete(ctx context.Context, id int64, cascade bool) (int, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	if _, ok := s.records.namespaces[id]; !ok {
+		return 0, ErrRecordNotFound
+	}
+
+	links := []int{}
+	for _, link := range s.records.links {
+		if int64(link.NamespaceId) == id {
+			links = append(links, link.Id)
+		}
+	}
+
+	if len(links) > 0 && !cascade {
+		return len(links), ErrRecordInUse
+	}
+
+	for _, linkId := range links {
+		delete(s.records.links, linkId)
+		s.records.dropClicks(linkId)
+	}
+
+	delete(s.records.counters, id)
+	delete(s.records.members, id)
+	delete(s.records.namespaces, id)
+	return len(links), nil
+}
+
+func (s *MemoryNamespaceStore) Member(ctx context.Context, namespaceId int64, clientId string) (*NamespaceMember, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	member, ok := s.records.members[namespaceId][clientId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &member, nil
+}
+
+func (s *MemoryNamespaceStore) Members(ctx context.Context, namespaceId int64) ([]NamespaceMember, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	members := []NamespaceMember{}
+	for _, member := range s.records.members[namespaceId] {
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].CreatedAt.Equal(members[j].CreatedAt) {
+			return members[i].CreatedAt.Before(members[j].CreatedAt)
+		}
+
+		return members[i].ClientId < members[j].ClientId
+	})
+
+	return members, nil
+}
+
+func (s *MemoryNamespaceStore) PutMember(ctx context.Context, member *NamespaceMember) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	if s.records.members[member.NamespaceId] == nil {
+		s.records.members[member.NamespaceId] = map[string]NamespaceMember{}
+	}
+
+	// existing members keep when they joined
+	if existing, ok := s.records.members[member.NamespaceId][member.ClientId]; ok {
+		member.CreatedAt = existing.CreatedAt
+	} else if member.CreatedAt.IsZero() {
+		member.CreatedAt = time.Now().UTC()
+	}
+
+	s.records.members[member.NamespaceId][member.ClientId] = *member
+	return nil
+}
+
+func (s *MemoryNamespaceStore) DeleteMember(ctx context.Context, namespaceId int64, clientId string) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	if _, ok := s.records.members[namespaceId][clientId]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(s.records.members[namespaceId], clientId)
+	return nil
+}
+
+type MemoryClientStore struct {
+	records *memoryRecords
+}
+
+func (s *MemoryClientStore) Get(ctx context.Context, id string) (*LinkrClient, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	client, ok := s.records.clients[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &client, nil
+}
+
+func (s *MemoryClientStore) List(ctx context.Context, filter ClientFilter) ([]LinkrClient, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	clients := []LinkrClient{}
+	for _, client := range s.records.clients {
+		if filter.Disabled != nil && client.DisabledAt.Valid != *filter.Disabled {
+			continue
+		}
+
+		clients = append(clients, client)
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].CreatedAt.Equal(clients[j].CreatedAt) {
+			return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+		}
+
+		return clients[i].Id < clients[j].Id
+	})
+
+	return clients, nil
+}
+
+func (s *MemoryClientStore) Create(ctx context.Context, client *LinkrClient) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, existing := range s.records.clients {
+		if existing.Id == client.Id || existing.Username == client.Username {
+			return ErrRecordExists
+		}
+	}
+
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now().UTC()
+	}
+
+	client.UpdatedAt = client.CreatedAt
+	s.records.clients[client.Id] = *client
+	return nil
+}
+
+func (s *MemoryClientStore) Update(ctx context.Context, id string, update ClientUpdate) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	client, ok := s.records.clients[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	nullable(&client.Description, update.Description)
+	nullable(&client.AllowHosts, update.AllowHosts)
+	nullable(&client.DenyHosts, update.DenyHosts)
+
+	if update.Scope != nil {
+		client.Scope = *update.Scope
+	}
+
+	client.UpdatedAt = time.Now().UTC()
+	s.records.clients[id] = client
+	return nil
+}
+
+func (s *MemoryClientStore) SetDisabled(ctx context.Context, id string, at *time.Time) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	client, ok := s.records.clients[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	client.DisabledAt = sql.NullTime{}
+	if at != nil {
+		client.DisabledAt = sql.NullTime{Time: *at, Valid: true}
+	}
+
+	client.UpdatedAt = time.Now().UTC()
+	s.records.clients[id] = client
+	return nil
+}
+
+func (s *MemoryClientStore) Delete(ctx context.Context, id string) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	if _, ok := s.records.clients[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	for nsId, ns := range s.records.namespaces {
+		if ns.OwnerId.String == id {
+			ns.OwnerId = sql.NullString{}
+			s.records.namespaces[nsId] = ns
+		}
+
+		delete(s.records.members[nsId], id)
+	}
+
+	delete(s.records.keys, id)
+	delete(s.records.clients, id)
+	return nil
+}
+
+func (s *MemoryClientStore) PreviousKeys(ctx context.Context, id string, now time.Time) ([]SigningKey, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	previous := append([]clientKey{}, s.records.keys[id]...)
+	sort.Slice(previous, func(i, j int) bool { return previous[i].expiresAt.After(previous[j].expiresAt) })
+
+	keys := []SigningKey{}
+	for _, key := range previous {
+		if key.expiresAt.After(now) {
+			keys = append(keys, key.SigningKey)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *MemoryClientStore) RotateKey(ctx context.Context, id string, key string, now time.Time, previousExpiresAt *time.Time) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	client, ok := s.records.clients[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	// keys past their grace are of no use
+	kept := []clientKey{}
+	for _, previous := range s.records.keys[id] {
+		if previous.expiresAt.After(now) {
+			kept = append(kept, previous)
+		}
+	}
+
+	if previousExpiresAt != nil {
+		kept = append(kept, clientKey{SigningKey{Algorithm: client.Algorithm, Key: client.SigningKey}, *previousExpiresAt})
+	}
+
+	s.records.keys[id] = kept
+
+	client.SigningKey = key
+	client.UpdatedAt = now
+	s.records.clients[id] = client
+	return nil
+}
+
+func (s *MemoryClientStore) TouchLastUsed(ctx context.Context, uses map[string]time.Time) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for clientId, at := range uses {
+		client, ok := s.records.clients[clientId]
+		if !ok || (client.LastUsedAt.Valid && !client.LastUsedAt.Time.Before(at)) {
+			continue
+		}
+
+		client.LastUsedAt = sql.NullTime{Time: at, Valid: true}
+		s.records.clients[clientId] = client
+	}
+
+	return nil
+}
+
+// sets `field` to `value`, unless it's nil. empty values are stored as null
+type MemoryStatsStore struct {
+	records *memoryRecords
+}
+
+// checks the filter matches the count of the link
+func (f StatsFilter) matches(k rollupKey) bool {
+	if f.LinkId != 0 {
+		return k.linkId == f.LinkId
+	}
+
+	return k.namespaceId == f.NamespaceId
+}
+
+func (s *MemoryStatsStore) AddClicks(ctx context.Context, clicks []ClickEvent) error {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	for _, ev := range clicks {
+		s.records.lastClickId++
+		s.records.clicks = append(s.records.clicks, rollupClick{
+			Id:          s.records.lastClickId,
+			LinkId:      ev.LinkId,
+			NamespaceId: ev.NamespaceId,
+			ClickedAt:   ev.ClickedAt.UTC(),
+			Referrer:    sql.NullString{String: ev.Referrer, Valid: ev.Referrer != ""},
+			UserAgent:   sql.NullString{String: ev.UserAgent, Valid: ev.UserAgent != ""},
+			IpHash:      sql.NullString{String: ev.IpHash, Valid: ev.IpHash != ""},
+		})
+	}
+
+	return nil
+}
+
+func (s *MemoryStatsStore) RollupClicks(ctx context.Context, until time.Time, limit int) (int, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	// clicks are taken in the order of (clicked_at, id), from the last one rolled up
+	after := func(a, b rollupClick) bool {
+		return a.ClickedAt.After(b.ClickedAt) || (a.ClickedAt.Equal(b.ClickedAt) && a.Id > b.Id)
+	}
+
+	pending := []rollupClick{}
+	for _, click := range s.records.clicks {
+		if click.ClickedAt.After(until) || (s.records.rolledUp != nil && !after(click, *s.records.rolledUp)) {
+			continue
+		}
+
+		pending = append(pending, click)
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return after(pending[j], pending[i]) })
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	for _, click := range pending {
+		s.records.rollups.add(click)
+	}
+
+	s.records.rolledUp = &pending[len(pending)-1]
+	return len(pending), nil
+}
+
+func (s *MemoryStatsStore) HourlyClicks(ctx context.Context, filter StatsFilter) (map[time.Time]int64, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	counts := map[time.Time]int64{}
+	for k, n := range s.records.rollups.hourly {
+		if filter.matches(k) && !k.bucket.Before(filter.From) && k.bucket.Before(filter.To) {
+			counts[k.bucket] += n
+		}
+	}
+
+	return counts, nil
+}
+
+func (s *MemoryStatsStore) UniqueVisitors(ctx context.Context, filter StatsFilter) (int64, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	fromDay, toDay := truncateDay(filter.From), truncateDay(filter.To)
+
+	visitors := map[string]bool{}
+	for k := range s.records.rollups.visitors {
+		if filter.matches(k) && !k.bucket.Before(fromDay) && !k.bucket.After(toDay) {
+			visitors[k.value] = true
+		}
+	}
+
+	return int64(len(visitors)), nil
+}
+
+func (s *MemoryStatsStore) TopReferrers(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	return s.topDaily(s.records.rollups.referrers, filter, limit), nil
+}
+
+func (s *MemoryStatsStore) TopAgents(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	return s.topDaily(s.records.rollups.agents, filter, limit), nil
+}
+
+// most clicked values of the daily `counts`
+func (s *MemoryStatsStore) topDaily(counts map[rollupKey]int64, filter StatsFilter, limit int) []ClickCount {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	fromDay, toDay := truncateDay(filter.From), truncateDay(filter.To)
+
+	clicks := map[string]int64{}
+	for k, n := range counts {
+		if filter.matches(k) && !k.bucket.Before(fromDay) && !k.bucket.After(toDay) {
+			clicks[k.value] += n
+		}
+	}
+
+	return topClickCounts(clicks, limit)
+}
+
+func (s *MemoryStatsStore) TopLinks(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+
+	clicks := map[string]int64{}
+	for k, n := range s.records.rollups.hourly {
+		if k.namespaceId == filter.NamespaceId && !k.bucket.Before(filter.From) && k.bucket.Before(filter.To) {
+			clicks[s.records.links[k.linkId].Tag] += n
+		}
+	}
+
+	return topClickCounts(clicks, limit), nil
+}
+
+// the most clicked of `clicks`, by their name when they're clicked as much
+func topClickCounts(clicks map[string]int64, limit int) []ClickCount {
+	counts := []ClickCount{}
+	for name, n := range clicks {
+		counts = append(counts, ClickCount{Name: name, Clicks: n})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Clicks != counts[j].Clicks {
+			return counts[i].Clicks > counts[j].Clicks
+		}
+
+		return counts[i].Name < counts[j].Name
+	})
+
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+
+	return counts
+}
+
+func nullable(field *sql.NullString, value *string) {
+	if value != nil {
+		*field = sql.NullString{String: *value, Valid: *value != ""}
+	}
+}
@@ -0,0 +1,849 @@
+// Stores keeping the records in sqlite, libsql or postgres
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	linkColumns      = []string{"id", "identifier", "destination_url", "namespace_id", "expires_in", "expires_at", "headers", "forward_mode", "created_at"}
+	namespaceColumns = []string{"id", "unique_tag", `"desc"`, "expired_url", "id_strategy", "allow_hosts", "deny_hosts", "owner_id", "archived_at"}
+	clientColumns    = []string{"id", "username", "description", "scope", "signing_key", "algorithm", "disabled_at", "last_used_at", "allow_hosts", "deny_hosts", "created_at", "updated_at"}
+)
+
+// lists the columns, prefixed by the alias of their table
+func columnsOf(alias string, columns []string) string {
+	prefixed := make([]string, len(columns))
+	for i, column := range columns {
+		prefixed[i] = alias + "." + column
+	}
+
+	return strings.Join(prefixed, ", ")
+}
+
+// turns the errors of the driver into the errors of the stores
+func sqlError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w: %w", ErrRecordExists, err)
+	}
+
+	return err
+}
+
+// responds `ErrRecordNotFound` when the statement changed no row
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return sqlError(err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Condition limiting `n` to the namespaces of the access, along with
+// its arguments. Empty when every namespace can be accessed
+func accessCondition(access NamespaceAccess) (string, []interface{}) {
+	if access.All {
+		return "", nil
+	}
+
+	condition := `n.unique_tag = ?`
+	args := []interface{}{linkr.ReservedGlobalChar}
+
+	if access.ClientId != "" {
+		condition += ` OR n.owner_id = ?`
+		args = append(args, access.ClientId)
+
+		if len(access.Roles) > 0 {
+			condition += ` OR EXISTS (
+				SELECT 1 FROM "NamespaceMember" m WHERE m.namespace_id = n.id AND m.client_id = ? AND m.role IN (?` + strings.Repeat(", ?", len(access.Roles)-1) + `)
+			)`
+			args = append(args, access.ClientId)
+			for _, role := range access.Roles {
+				args = append(args, role)
+			}
+		}
+	}
+
+	return "(" + condition + ")", args
+}
+
+// Stores keeping the records in `db`, in the dialect of its driver
+func NewSQLStores(db *sqlx.DB) *Stores {
+	return &Stores{
+		Links:      NewSQLLinkStore(db),
+		Namespaces: NewSQLNamespaceStore(db),
+		Clients:    NewSQLClientStore(db),
+		Stats:      NewSQLStatsStore(db),
+	}
+}
+
+type SQLLinkStore struct {
+	db sqlDB
+}
+
+func NewSQLLinkStore(db *sqlx.DB) *SQLLinkStore {
+	return &SQLLinkStore{db: sqlDB{db}}
+}
+
+var selectNamespacedLink = `SELECT ` + columnsOf("l", linkColumns) + `, n.unique_tag FROM "Link" l JOIN "Namespace" n ON n.id = l.namespace_id`
+
+func (s *SQLLinkStore) Get(ctx context.Context, namespaceId int64, identifier string) (*Link, error) {
+	link := new(Link)
+	err := s.db.GetContext(ctx, link, `SELECT `+columnsOf("l", linkColumns)+` FROM "Link" l WHERE l.identifier = ? AND l.namespace_id = ?`, identifier, namespaceId)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	return link, nil
+}
+
+func (s *SQLLinkStore) GetByTag(ctx context.Context, namespace string, identifier string) (*NamespacedLink, error) {
+	link := new(NamespacedLink)
+	err := s.db.GetContext(ctx, link, selectNamespacedLink+` WHERE n.unique_tag = ? AND l.identifier = ?`, namespace, identifier)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	return link, nil
+}
+
+func (s *SQLLinkStore) List(ctx context.Context, filter LinkFilter) ([]NamespacedLink, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if condition, conditionArgs := accessCondition(filter.Access); condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	if filter.Namespace != "" {
+		conditions = append(conditions, `n.unique_tag = ?`)
+		args = append(args, filter.Namespace)
+	}
+
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, s.db.time("l.created_at")+` >= `+s.db.time("?"))
+		args = append(args, filter.CreatedAfter.UTC())
+	}
+
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, s.db.time("l.created_at")+` < `+s.db.time("?"))
+		args = append(args, filter.CreatedBefore.UTC())
+	}
+
+	switch filter.Status {
+	case "active":
+		conditions = append(conditions, `(l.expires_at IS NULL OR `+s.db.time("l.expires_at")+` > `+s.db.time("?")+`)`)
+		args = append(args, filter.Now.UTC())
+	case "expired":
+		conditions = append(conditions, `(l.expires_at IS NOT NULL AND `+s.db.time("l.expires_at")+` <= `+s.db.time("?")+`)`)
+		args = append(args, filter.Now.UTC())
+	}
+
+	if filter.DestinationPrefix != "" {
+		conditions = append(conditions, `l.destination_url LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(filter.DestinationPrefix)+"%")
+	}
+
+	if filter.BeforeId > 0 {
+		conditions = append(conditions, `l.id < ?`)
+		args = append(args, filter.BeforeId)
+	}
+
+	stmt := selectNamespacedLink
+	if len(conditions) > 0 {
+		stmt += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	stmt += ` ORDER BY l.id DESC`
+	if filter.Limit > 0 {
+		stmt += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	links := []NamespacedLink{}
+	if err := s.db.SelectContext(ctx, &links, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (s *SQLLinkStore) Create(ctx context.Context, link *Link) error {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now().UTC()
+	}
+
+	// postgres drivers don't support `LastInsertId`
+	err := s.db.GetContext(ctx, &link.Id, `
+		INSERT INTO "Link"
+			(identifier, destination_url, namespace_id, expires_in, expires_at, headers, forward_mode, created_at)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+	`, link.Tag, link.OriginalUrl, link.NamespaceId, link.ExpiresIn, link.ExpiresAt, link.SerializedHeaders, link.ForwardMode, link.CreatedAt)
+
+	return sqlError(err)
+}
+
+func (s *SQLLinkStore) Update(ctx context.Context, id int, update LinkUpdate) error {
+	updates := []string{}
+	args := []interface{}{}
+
+	if update.DestinationUrl != nil {
+		updates = append(updates, `destination_url = ?`)
+		args = append(args, *update.DestinationUrl)
+	}
+
+	if update.SetExpiry {
+		updates = append(updates, `expires_in = ?`, `expires_at = ?`)
+		args = append(args, update.ExpiresIn, update.ExpiresAt)
+	}
+
+	if update.ForwardMode != nil {
+		updates = append(updates, `forward_mode = ?`)
+		args = append(args, *update.ForwardMode)
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	args = append(args, id)
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE "Link" SET `+strings.Join(updates, ", ")+` WHERE id = ?`, args...))
+}
+
+func (s *SQLLinkStore) Delete(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// clicks are removed by link, as the tables
+	// might not cascade the deleted link
+	for _, table := range []string{"ClickHourly", "ClickVisitorDaily", "ClickReferrerDaily", "ClickAgentDaily", "Click"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "`+table+`" WHERE link_id = ?`, id); err != nil {
+			return fmt.Errorf("couldn't delete link %d from %s: %w", id, table, err)
+		}
+	}
+
+	if err := affectedOne(tx.ExecContext(ctx, `DELETE FROM "Link" WHERE id = ?`, id)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLLinkStore) TakenIdentifiers(ctx context.Context, namespaceId int64, candidates []string) ([]string, error) {
+	taken := []string{}
+	if len(candidates) == 0 {
+		return taken, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT identifier FROM "Link" WHERE namespace_id = ? AND identifier IN (?)`, namespaceId, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.SelectContext(ctx, &taken, query, args...); err != nil {
+		return nil, err
+	}
+
+	return taken, nil
+}
+
+func (s *SQLLinkStore) NextCounterValue(ctx context.Context, namespaceId int64) (uint64, error) {
+	var value uint64
+	err := s.db.GetContext(ctx, &value, `
+		INSERT INTO "IdCounter" (namespace_id, value) VALUES (?, 1)
+			ON CONFLICT (namespace_id) DO UPDATE SET value = "IdCounter".value + 1
+			RETURNING value
+	`, namespaceId)
+
+	return value, err
+}
+
+type SQLNamespaceStore struct {
+	db sqlDB
+}
+
+func NewSQLNamespaceStore(db *sqlx.DB) *SQLNamespaceStore {
+	return &SQLNamespaceStore{db: sqlDB{db}}
+}
+
+var selectNamespace = `SELECT ` + columnsOf("n", namespaceColumns) + ` FROM "Namespace" n`
+
+func (s *SQLNamespaceStore) Get(ctx context.Context, id int64) (*LinkrNamespace, error) {
+	ns := new(LinkrNamespace)
+	if err := s.db.GetContext(ctx, ns, selectNamespace+` WHERE n.id = ?`, id); err != nil {
+		return nil, sqlError(err)
+	}
+
+	return ns, nil
+}
+
+func (s *SQLNamespaceStore) GetByTag(ctx context.Context, tag string) (*LinkrNamespace, error) {
+	ns := new(LinkrNamespace)
+	if err := s.db.GetContext(ctx, ns, selectNamespace+` WHERE n.unique_tag = ?`, tag); err != nil {
+		return nil, sqlError(err)
+	}
+
+	return ns, nil
+}
+
+func (s *SQLNamespaceStore) List(ctx context.Context, filter NamespaceFilter) ([]LinkrNamespace, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if condition, conditionArgs := accessCondition(filter.Access); condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	if !filter.IncludeArchived {
+		conditions = append(conditions, `n.archived_at IS NULL`)
+	}
+
+	stmt := selectNamespace
+	if len(conditions) > 0 {
+		stmt += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	namespaces := []LinkrNamespace{}
+	if err := s.db.SelectContext(ctx, &namespaces, stmt+` ORDER BY n.unique_tag`, args...); err != nil {
+		return nil, err
+	}
+
+	return namespaces, nil
+}
+
+func (s *SQLNamespaceStore) Create(ctx context.Context, ns *LinkrNamespace) error {
+	err := s.db.GetContext(ctx, &ns.Id, `
+		INSERT INTO "Namespace"
+			(unique_tag, "desc", expired_url, id_strategy, allow_hosts, deny_hosts, owner_id, archived_at)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id
+	`, ns.Tag, ns.Description, ns.ExpiredUrl, ns.IdStrategy, ns.AllowHosts, ns.DenyHosts, ns.OwnerId, ns.ArchivedAt)
+
+	return sqlError(err)
+}
+
+func (s *SQLNamespaceStore) Update(ctx context.Context, id int64, update NamespaceUpdate) error {
+	updates := []string{}
+	args := []interface{}{}
+
+	for column, value := range map[string]*string{
+		`"desc"`:      update.Description,
+		`expired_url`: update.ExpiredUrl,
+		`id_strategy`: update.IdStrategy,
+		`allow_hosts`: update.AllowHosts,
+		`deny_hosts`:  update.DenyHosts,
+	} {
+		if value == nil {
+			continue
+		}
+
+		// empty values are stored as null
+		var stored *string
+		if *value != "" {
+			stored = value
+		}
+
+		updates = append(updates, column+` = ?`)
+		args = append(args, stored)
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	args = append(args, id)
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE "Namespace" SET `+strings.Join(updates, ", ")+` WHERE id = ?`, args...))
+}
+
+func (s *SQLNamespaceStore) Archive(ctx context.Context, id int64, at time.Time) error {
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE "Namespace" SET archived_at = COALESCE(archived_at, ?) WHERE id = ?`, at.UTC(), id))
+}
+
+func (s *SQLNamespaceStore) Delete(ctx context.Context, id int64, cascade bool) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	links := 0
+	if err := tx.GetContext(ctx, &links, `SELECT COUNT(*) FROM "Link" WHERE namespace_id = ?`, id); err != nil {
+		return 0, fmt.Errorf("couldn't count the links of namespace %d: %w", id, err)
+	}
+
+	if links > 0 && !cascade {
+		return links, ErrRecordInUse
+	}
+
+	// clicks are removed by namespace, as the tables
+	// might not cascade the deleted links
+	for _, table := range []string{"ClickHourly", "ClickVisitorDaily", "ClickReferrerDaily", "ClickAgentDaily", "Click", "Link", "IdCounter", "NamespaceMember"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "`+table+`" WHERE namespace_id = ?`, id); err != nil {
+			return links, fmt.Errorf("couldn't delete namespace %d from %s: %w", id, table, err)
+		}
+	}
+
+	if err := affectedOne(tx.ExecContext(ctx, `DELETE FROM "Namespace" WHERE id = ?`, id)); err != nil {
+		return links, err
+	}
+
+	return links, tx.Commit()
+}
+
+func (s *SQLNamespaceStore) Member(ctx context.Context, namespaceId int64, clientId string) (*NamespaceMember, error) {
+	member := new(NamespaceMember)
+	err := s.db.GetContext(ctx, member, `SELECT namespace_id, client_id, role, created_at FROM "NamespaceMember" WHERE namespace_id = ? AND client_id = ?`, namespaceId, clientId)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	return member, nil
+}
+
+func (s *SQLNamespaceStore) Members(ctx context.Context, namespaceId int64) ([]NamespaceMember, error) {
+	members := []NamespaceMember{}
+	err := s.db.SelectContext(ctx, &members, `SELECT namespace_id, client_id, role, created_at FROM "NamespaceMember" WHERE namespace_id = ? ORDER BY created_at, client_id`, namespaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (s *SQLNamespaceStore) PutMember(ctx context.Context, member *NamespaceMember) error {
+	if member.CreatedAt.IsZero() {
+		member.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO "NamespaceMember" (namespace_id, client_id, role, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (namespace_id, client_id) DO UPDATE SET role = excluded.role
+	`, member.NamespaceId, member.ClientId, member.Role, member.CreatedAt)
+
+	return sqlError(err)
+}
+
+func (s *SQLNamespaceStore) DeleteMember(ctx context.Context, namespaceId int64, clientId string) error {
+	return affectedOne(s.db.ExecContext(ctx, `DELETE FROM "NamespaceMember" WHERE namespace_id = ? AND client_id = ?`, namespaceId, clientId))
+}
+
+type SQLClientStore struct {
+	db sqlDB
+}
+
+func NewSQLClientStore(db *sqlx.DB) *SQLClientStore {
+	return &SQLClientStore{db: sqlDB{db}}
+}
+
+var selectClient = `SELECT ` + columnsOf("c", clientColumns) + ` FROM "ApiClient" c`
+
+func (s *SQLClientStore) Get(ctx context.Context, id string) (*LinkrClient, error) {
+	client := new(LinkrClient)
+	if err := s.db.GetContext(ctx, client, selectClient+` WHERE c.id = ?`, id); err != nil {
+		return nil, sqlError(err)
+	}
+
+	return client, nil
+}
+
+func (s *SQLClientStore) List(ctx context.Context, filter ClientFilter) ([]LinkrClient, error) {
+	stmt := selectClient
+	if filter.Disabled != nil {
+		if *filter.Disabled {
+			stmt += ` WHERE c.disabled_at IS NOT NULL`
+		} else {
+			stmt += ` WHERE c.disabled_at IS NULL`
+		}
+	}
+
+	clients := []LinkrClient{}
+	if err := s.db.SelectContext(ctx, &clients, stmt+` ORDER BY c.created_at, c.id`); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (s *SQLClientStore) Create(ctx context.Context, client *LinkrClient) error {
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now().UTC()
+	}
+
+	client.UpdatedAt = client.CreatedAt
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO "ApiClient"
+			(id, username, description, scope, signing_key, algorithm, allow_hosts, deny_hosts, created_at, updated_at)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, client.Id, client.Username, client.Description, client.Scope, client.SigningKey, client.Algorithm, client.AllowHosts, client.DenyHosts, client.CreatedAt, client.UpdatedAt)
+
+	return sqlError(err)
+}
+
+func (s *SQLClientStore) Update(ctx context.Context, id string, update ClientUpdate) error {
+	updates := []string{}
+	args := []interface{}{}
+
+	for column, value := range map[string]*string{
+		`description`: update.Description,
+		`allow_hosts`: update.AllowHosts,
+		`deny_hosts`:  update.DenyHosts,
+	} {
+		if value == nil {
+			continue
+		}
+
+		// empty values are stored as null
+		var stored *string
+		if *value != "" {
+			stored = value
+		}
+
+		updates = append(updates, column+` = ?`)
+		args = append(args, stored)
+	}
+
+	if update.Scope != nil {
+		updates = append(updates, `scope = ?`)
+		args = append(args, *update.Scope)
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	updates = append(updates, `updated_at = ?`)
+	args = append(args, time.Now().UTC(), id)
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE "ApiClient" SET `+strings.Join(updates, ", ")+` WHERE id = ?`, args...))
+}
+
+func (s *SQLClientStore) SetDisabled(ctx context.Context, id string, at *time.Time) error {
+	return affectedOne(s.db.ExecContext(ctx, `UPDATE "ApiClient" SET disabled_at = ?, updated_at = ? WHERE id = ?`, at, time.Now().UTC(), id))
+}
+
+func (s *SQLClientStore) Delete(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// done here, as foreign keys might not be enforced
+	for _, stmt := range []string{
+		`DELETE FROM "ClientKey" WHERE client_id = ?`,
+		`DELETE FROM "NamespaceMember" WHERE client_id = ?`,
+		`UPDATE "Namespace" SET owner_id = NULL WHERE owner_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return err
+		}
+	}
+
+	if err := affectedOne(tx.ExecContext(ctx, `DELETE FROM "ApiClient" WHERE id = ?`, id)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLClientStore) PreviousKeys(ctx context.Context, id string, now time.Time) ([]SigningKey, error) {
+	keys := []SigningKey{}
+	err := s.db.SelectContext(ctx, &keys, `
+		SELECT algorithm, signing_key FROM "ClientKey"
+			WHERE client_id = ? AND `+s.db.time("expires_at")+` > `+s.db.time("?")+`
+			ORDER BY `+s.db.time("expires_at")+` DESC
+	`, id, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *SQLClientStore) RotateKey(ctx context.Context, id string, key string, now time.Time, previousExpiresAt *time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	client := new(LinkrClient)
+	if err := tx.GetContext(ctx, client, selectClient+` WHERE c.id = ?`, id); err != nil {
+		return sqlError(err)
+	}
+
+	// keys past their grace are of no use
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "ClientKey" WHERE client_id = ? AND `+tx.time("expires_at")+` <= `+tx.time("?"), id, now.UTC()); err != nil {
+		return fmt.Errorf("couldn't remove expired keys: %w", err)
+	}
+
+	if previousExpiresAt != nil {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "ClientKey" (client_id, algorithm, signing_key, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`, id, client.Algorithm, client.SigningKey, now.UTC(), previousExpiresAt.UTC())
+		if err != nil {
+			return fmt.Errorf("couldn't keep the previous key: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE "ApiClient" SET signing_key = ?, updated_at = ? WHERE id = ?`, key, now.UTC(), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLClientStore) TouchLastUsed(ctx context.Context, uses map[string]time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for clientId, at := range uses {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE "ApiClient" SET last_used_at = ?
+				WHERE id = ? AND (last_used_at IS NULL OR `+tx.time("last_used_at")+` < `+tx.time("?")+`)
+		`, at, clientId, at)
+		if err != nil {
+			return fmt.Errorf("couldn't write the last use of client %s: %w", clientId, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+type SQLStatsStore struct {
+	db sqlDB
+}
+
+func NewSQLStatsStore(db *sqlx.DB) *SQLStatsStore {
+	return &SQLStatsStore{db: sqlDB{db}}
+}
+
+// column of the rollup tables the filter matches, and its value
+func (f StatsFilter) column() (string, interface{}) {
+	if f.LinkId != 0 {
+		return "link_id", f.LinkId
+	}
+
+	return "namespace_id", f.NamespaceId
+}
+
+func (s *SQLStatsStore) AddClicks(ctx context.Context, clicks []ClickEvent) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PreparexContext(ctx, tx.Rebind(`INSERT INTO "Click" (link_id, namespace_id, clicked_at, referrer, user_agent, ip_hash) VALUES (?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, ev := range clicks {
+		_, err := stmt.ExecContext(ctx, ev.LinkId, ev.NamespaceId, ev.ClickedAt, nullIfEmpty(ev.Referrer), nullIfEmpty(ev.UserAgent), nullIfEmpty(ev.IpHash))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStatsStore) RollupClicks(ctx context.Context, until time.Time, limit int) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO "RollupState" (name, last_click_id) VALUES (?, 0) ON CONFLICT (name) DO NOTHING`, clickRollupName)
+	if err != nil {
+		return 0, err
+	}
+
+	state := struct {
+		LastClickId   int          `db:"last_click_id"`
+		RolledUpUntil sql.NullTime `db:"rolled_up_until"`
+	}{}
+	if err := tx.GetContext(ctx, &state, `SELECT last_click_id, rolled_up_until FROM "RollupState" WHERE name = ?`, clickRollupName); err != nil {
+		return 0, err
+	}
+
+	// clicks are taken in the order of (clicked_at, id), from the last one rolled up
+	clickedAt := tx.time("clicked_at")
+	param := tx.time("?")
+
+	stmt := `SELECT id, link_id, namespace_id, clicked_at, referrer, user_agent, ip_hash FROM "Click" WHERE ` + clickedAt + ` <= ` + param
+	args := []interface{}{until.UTC()}
+	if state.RolledUpUntil.Valid {
+		stmt += ` AND (` + clickedAt + ` > ` + param + ` OR (` + clickedAt + ` = ` + param + ` AND id > ?))`
+		args = append(args, state.RolledUpUntil.Time, state.RolledUpUntil.Time, state.LastClickId)
+	}
+
+	clicks := []rollupClick{}
+	err = tx.SelectContext(ctx, &clicks, stmt+` ORDER BY `+clickedAt+`, id LIMIT ?`, append(args, limit)...)
+	if err != nil || len(clicks) == 0 {
+		return 0, err
+	}
+
+	counts := newClickCounts()
+	for _, click := range clicks {
+		counts.add(click)
+	}
+
+	for k, n := range counts.hourly {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO "ClickHourly" (link_id, namespace_id, bucket_start, clicks) VALUES (?, ?, ?, ?)
+				ON CONFLICT (link_id, bucket_start) DO UPDATE SET clicks = "ClickHourly".clicks + excluded.clicks
+		`, k.linkId, k.namespaceId, k.bucket, n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for k, n := range counts.referrers {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO "ClickReferrerDaily" (link_id, namespace_id, day, referrer_host, clicks) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (link_id, day, referrer_host) DO UPDATE SET clicks = "ClickReferrerDaily".clicks + excluded.clicks
+		`, k.linkId, k.namespaceId, k.bucket, k.value, n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for k, n := range counts.agents {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO "ClickAgentDaily" (link_id, namespace_id, day, agent_family, clicks) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (link_id, day, agent_family) DO UPDATE SET clicks = "ClickAgentDaily".clicks + excluded.clicks
+		`, k.linkId, k.namespaceId, k.bucket, k.value, n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for k := range counts.visitors {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO "ClickVisitorDaily" (link_id, namespace_id, day, ip_hash) VALUES (?, ?, ?, ?)
+				ON CONFLICT (link_id, day, ip_hash) DO NOTHING
+		`, k.linkId, k.namespaceId, k.bucket, k.value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// only move the watermark from where it was read, in case another
+	// instance rolled the clicks up meanwhile. the id of the last click
+	// changes with every batch
+	last := clicks[len(clicks)-1]
+	res, err := tx.ExecContext(ctx, `
+		UPDATE "RollupState" SET last_click_id = ?, rolled_up_until = ? WHERE name = ? AND last_click_id = ?
+	`, last.Id, last.ClickedAt.UTC(), clickRollupName, state.LastClickId)
+	if err != nil {
+		return 0, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return 0, errRollupRaced
+	}
+
+	return len(clicks), tx.Commit()
+}
+
+func (s *SQLStatsStore) HourlyClicks(ctx context.Context, filter StatsFilter) (map[time.Time]int64, error) {
+	column, id := filter.column()
+
+	hours := []struct {
+		Hour   string `db:"hour"`
+		Clicks int64  `db:"clicks"`
+	}{}
+	err := s.db.SelectContext(ctx, &hours, `
+		SELECT `+sqlTimeText(s.db.DriverName(), "bucket_start")+` AS hour, SUM(clicks) AS clicks FROM "ClickHourly"
+			WHERE `+column+` = ? AND `+s.db.time("bucket_start")+` >= `+s.db.time("?")+` AND `+s.db.time("bucket_start")+` < `+s.db.time("?")+`
+			GROUP BY `+s.db.time("bucket_start")+`
+	`, id, filter.From.UTC(), filter.To.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[time.Time]int64{}
+	for _, h := range hours {
+		at, err := time.Parse(time.DateTime, h.Hour)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected bucket '%s': %w", h.Hour, err)
+		}
+
+		counts[at] += h.Clicks
+	}
+
+	return counts, nil
+}
+
+func (s *SQLStatsStore) UniqueVisitors(ctx context.Context, filter StatsFilter) (int64, error) {
+	column, id := filter.column()
+
+	var visitors int64
+	err := s.db.GetContext(ctx, &visitors, `
+		SELECT COUNT(DISTINCT ip_hash) FROM "ClickVisitorDaily"
+			WHERE `+column+` = ? AND `+s.db.time("day")+` >= `+s.db.time("?")+` AND `+s.db.time("day")+` <= `+s.db.time("?")+`
+	`, id, truncateDay(filter.From), truncateDay(filter.To))
+	return visitors, err
+}
+
+func (s *SQLStatsStore) TopReferrers(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	return s.topDaily(ctx, "ClickReferrerDaily", "referrer_host", filter, limit)
+}
+
+func (s *SQLStatsStore) TopAgents(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	return s.topDaily(ctx, "ClickAgentDaily", "agent_family", filter, limit)
+}
+
+// most clicked values of `valueColumn`, in the daily rollup `table`
+func (s *SQLStatsStore) topDaily(ctx context.Context, table string, valueColumn string, filter StatsFilter, limit int) ([]ClickCount, error) {
+	column, id := filter.column()
+
+	counts := []ClickCount{}
+	err := s.db.SelectContext(ctx, &counts, `
+		SELECT `+valueColumn+` AS name, SUM(clicks) AS clicks FROM "`+table+`"
+			WHERE `+column+` = ? AND `+s.db.time("day")+` >= `+s.db.time("?")+` AND `+s.db.time("day")+` <= `+s.db.time("?")+`
+			GROUP BY `+valueColumn+` ORDER BY clicks DESC, name LIMIT ?
+	`, id, truncateDay(filter.From), truncateDay(filter.To), limit)
+	return counts, err
+}
+
+func (s *SQLStatsStore) TopLinks(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	counts := []ClickCount{}
+	err := s.db.SelectContext(ctx, &counts, `
+		SELECT l.identifier AS name, SUM(h.clicks) AS clicks
+			FROM "ClickHourly" h JOIN "Link" l ON l.id = h.link_id
+			WHERE h.namespace_id = ? AND `+s.db.time("h.bucket_start")+` >= `+s.db.time("?")+` AND `+s.db.time("h.bucket_start")+` < `+s.db.time("?")+`
+			GROUP BY l.id, l.identifier ORDER BY clicks DESC, name LIMIT ?
+	`, filter.NamespaceId, filter.From.UTC(), filter.To.UTC(), limit)
+	return counts, err
+}
@@ -0,0 +1,543 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	linkr "iam-kevin/linkr/pkg"
+)
+
+// runs `test` against every store implementation
+func forEachStores(t *testing.T, test func(t *testing.T, stores *Stores)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, _ := newTestDB(t)
+		test(t, NewSQLStores(db))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStores())
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		db, _ := newPostgresTestDB(t)
+		test(t, NewSQLStores(db))
+	})
+}
+
+// creates the clients `ids` the namespaces of the tests refer to
+func createTestClients(t *testing.T, stores *Stores, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		client := &LinkrClient{Id: id, Username: id, Scope: linkr.RoleAdmin, SigningKey: "key", Algorithm: SigningAlgHS256}
+		if err := stores.Clients.Create(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLinkStore(t *testing.T) {
+	forEachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+
+		global, err := stores.Namespaces.GetByTag(ctx, linkr.ReservedGlobalChar)
+		if err != nil {
+			t.Fatalf("the global namespace should be seeded: %s", err)
+		}
+
+		link := &Link{Tag: "abc", OriginalUrl: "https://dest.example", NamespaceId: int(global.Id)}
+		if err := stores.Links.Create(ctx, link); err != nil {
+			t.Fatal(err)
+		}
+
+		if link.Id == 0 || link.CreatedAt.IsZero() {
+			t.Errorf("expected the id and creation time to be set, got %d and %s", link.Id, link.CreatedAt)
+		}
+
+		err = stores.Links.Create(ctx, &Link{Tag: "abc", OriginalUrl: "https://other.example", NamespaceId: int(global.Id)})
+		if !errors.Is(err, ErrRecordExists) {
+			t.Errorf("expected a taken identifier to be refused, got %v", err)
+		}
+
+		found, err := stores.Links.GetByTag(ctx, linkr.ReservedGlobalChar, "abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.OriginalUrl != "https://dest.example" || found.NamespaceTag != linkr.ReservedGlobalChar {
+			t.Errorf("found the wrong link: %+v", found)
+		}
+
+		destination, forward := "https://moved.example", ForwardModeQuery
+		expiresAt := time.Date(2024, 5, 9, 2, 9, 42, 0, time.UTC)
+		err = stores.Links.Update(ctx, link.Id, LinkUpdate{DestinationUrl: &destination, ForwardMode: &forward, SetExpiry: true, ExpiresIn: 60, ExpiresAt: &expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updated, err := stores.Links.Get(ctx, global.Id, "abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated.OriginalUrl != destination || updated.ForwardMode.String != forward || !updated.ExpiresAt.Time.Equal(expiresAt) {
+			t.Errorf("link wasn't updated: %+v", updated)
+		}
+
+		taken, err := stores.Links.TakenIdentifiers(ctx, global.Id, []string{"abc", "abd"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(taken, ",") != "abc" {
+			t.Errorf("expected only abc to be taken, got %v", taken)
+		}
+
+		for want := uint64(1); want <= 2; want++ {
+			value, err := stores.Links.NextCounterValue(ctx, global.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if value != want {
+				t.Errorf("expected the counter at %d, got %d", want, value)
+			}
+		}
+
+		if err := stores.Links.Delete(ctx, link.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stores.Links.Get(ctx, global.Id, "abc"); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected the deleted link to be gone, got %v", err)
+		}
+
+		if err := stores.Links.Delete(ctx, link.Id); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected deleting a missing link to fail, got %v", err)
+		}
+	})
+}
+
+func TestSQLLinkStoreDeletesClicks(t *testing.T) {
+	ctx := context.Background()
+	db, dfNs := newTestDB(t)
+
+	// databases where the clicks don't cascade
+	db.MustExec(`PRAGMA foreign_keys = OFF`)
+
+	insert := `INSERT INTO "Link" (identifier, destination_url, namespace_id) VALUES (?, 'https://dest.example', ?)`
+	db.MustExec(insert, "abc", dfNs.Id)
+	db.MustExec(insert, "kept", dfNs.Id)
+
+	at := time.Date(2024, 5, 6, 10, 15, 0, 0, time.UTC)
+	for _, linkId := range []int{1, 1, 2} {
+		db.MustExec(`INSERT INTO "Click" (link_id, namespace_id, clicked_at, ip_hash) VALUES (?, ?, ?, 'aaaa')`, linkId, dfNs.Id, at)
+	}
+
+	if _, err := NewClickRollup(NewSQLStatsStore(db), time.Hour, 10).RollupPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewSQLStores(db).Links.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"Click", "ClickHourly", "ClickVisitorDaily", "ClickReferrerDaily", "ClickAgentDaily"} {
+		left, kept := 0, 0
+		db.Get(&left, `SELECT COUNT(*) FROM "`+table+`" WHERE link_id = 1`)
+		db.Get(&kept, `SELECT COUNT(*) FROM "`+table+`" WHERE link_id = 2`)
+		if left != 0 || kept == 0 {
+			t.Errorf("%s: expected only the rows of the deleted link to be removed, got %d left and %d kept", table, left, kept)
+		}
+	}
+}
+
+func TestStatsStore(t *testing.T) {
+	forEachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+
+		ns := &LinkrNamespace{Tag: "d"}
+		if err := stores.Namespaces.Create(ctx, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		one := &Link{Tag: "one", OriginalUrl: "https://dest.example", NamespaceId: int(ns.Id)}
+		two := &Link{Tag: "two", OriginalUrl: "https://dest.example", NamespaceId: int(ns.Id)}
+		for _, link := range []*Link{one, two} {
+			if err := stores.Links.Create(ctx, link); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+		err := stores.Stats.AddClicks(ctx, []ClickEvent{
+			{LinkId: one.Id, NamespaceId: ns.Id, ClickedAt: day.Add(time.Hour), Referrer: "https://www.news.example/post", UserAgent: "Mozilla/5.0 Firefox/126.0", IpHash: "aaaa"},
+			{LinkId: one.Id, NamespaceId: ns.Id, ClickedAt: day.Add(time.Hour + 10*time.Minute), UserAgent: "curl/8.4.0", IpHash: "aaaa"},
+			{LinkId: one.Id, NamespaceId: ns.Id, ClickedAt: day.Add(26 * time.Hour), IpHash: "bbbb"},
+			{LinkId: two.Id, NamespaceId: ns.Id, ClickedAt: day.Add(2 * time.Hour), IpHash: "cccc"},
+			// not rolled up yet
+			{LinkId: two.Id, NamespaceId: ns.Id, ClickedAt: day.Add(72 * time.Hour), IpHash: "dddd"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		until := day.Add(48 * time.Hour)
+		for _, want := range []int{3, 1, 0} {
+			if n, err := stores.Stats.RollupClicks(ctx, until, 3); err != nil || n != want {
+				t.Fatalf("expected %d clicks rolled up, got %d (%v)", want, n, err)
+			}
+		}
+
+		link := StatsFilter{LinkId: one.Id, From: day, To: day.Add(48 * time.Hour)}
+		hours, err := stores.Stats.HourlyClicks(ctx, link)
+		if err != nil || len(hours) != 2 || hours[day.Add(time.Hour)] != 2 || hours[day.Add(26*time.Hour)] != 1 {
+			t.Errorf("unexpected hourly clicks %v (%v)", hours, err)
+		}
+
+		if visitors, err := stores.Stats.UniqueVisitors(ctx, link); err != nil || visitors != 2 {
+			t.Errorf("expected 2 visitors, got %d (%v)", visitors, err)
+		}
+
+		referrers, err := stores.Stats.TopReferrers(ctx, link, 10)
+		if err != nil || len(referrers) != 2 || referrers[0] != (ClickCount{Name: directReferrer, Clicks: 2}) || referrers[1].Name != "news.example" {
+			t.Errorf("unexpected referrers %+v (%v)", referrers, err)
+		}
+
+		if agents, err := stores.Stats.TopAgents(ctx, link, 1); err != nil || len(agents) != 1 {
+			t.Errorf("expected the agents cut to the limit, got %+v (%v)", agents, err)
+		}
+
+		namespace := StatsFilter{NamespaceId: ns.Id, From: day, To: day.Add(2 * time.Hour)}
+		if visitors, err := stores.Stats.UniqueVisitors(ctx, namespace); err != nil || visitors != 2 {
+			t.Errorf("expected 2 visitors of the days of the range, got %d (%v)", visitors, err)
+		}
+
+		top, err := stores.Stats.TopLinks(ctx, namespace, 10)
+		if err != nil || len(top) != 1 || top[0] != (ClickCount{Name: "one", Clicks: 2}) {
+			t.Errorf("expected only the clicks of the hours of the range, got %+v (%v)", top, err)
+		}
+
+		if err := stores.Links.Delete(ctx, one.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		if hours, err := stores.Stats.HourlyClicks(ctx, link); err != nil || len(hours) != 0 {
+			t.Errorf("expected the clicks deleted with the link, got %v (%v)", hours, err)
+		}
+	})
+}
+
+func TestLinkStoreList(t *testing.T) {
+	forEachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+
+		createTestClients(t, stores, "owner")
+
+		global, _ := stores.Namespaces.GetByTag(ctx, linkr.ReservedGlobalChar)
+		owned := &LinkrNamespace{Tag: "owned", OwnerId: sql.NullString{String: "owner", Valid: true}}
+		private := &LinkrNamespace{Tag: "private"}
+		for _, ns := range []*LinkrNamespace{owned, private} {
+			if err := stores.Namespaces.Create(ctx, ns); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		past := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+		for _, link := range []*Link{
+			{Tag: "a", OriginalUrl: "https://a.example/1", NamespaceId: int(global.Id), CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Tag: "b", OriginalUrl: "https://a.example/2", NamespaceId: int(global.Id), CreatedAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), ExpiresAt: past},
+			{Tag: "c", OriginalUrl: "https://b.example/1", NamespaceId: int(owned.Id), CreatedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+			{Tag: "d", OriginalUrl: "https://b.example/2", NamespaceId: int(private.Id), CreatedAt: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		} {
+			if err := stores.Links.Create(ctx, link); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		after := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+		tests := []struct {
+			name   string
+			filter LinkFilter
+			want   string
+		}{
+			{"everything", LinkFilter{Access: NamespaceAccess{All: true}}, "d,c,b,a"},
+			{"global only", LinkFilter{}, "b,a"},
+			{"owned", LinkFilter{Access: NamespaceAccess{ClientId: "owner"}}, "c,b,a"},
+			{"namespace", LinkFilter{Access: NamespaceAccess{All: true}, Namespace: "owned"}, "c"},
+			{"created after", LinkFilter{Access: NamespaceAccess{All: true}, CreatedAfter: &after}, "d,c,b"},
+			{"active", LinkFilter{Access: NamespaceAccess{All: true}, Status: "active", Now: now}, "d,c,a"},
+			{"expired", LinkFilter{Access: NamespaceAccess{All: true}, Status: "expired", Now: now}, "b"},
+			{"destination", LinkFilter{Access: NamespaceAccess{All: true}, DestinationPrefix: "https://a."}, "b,a"},
+			{"page", LinkFilter{Access: NamespaceAccess{All: true}, BeforeId: 4, Limit: 2}, "c,b"},
+		}
+
+		for _, tt := range tests {
+			links, err := stores.Links.List(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identifiers := []string{}
+			for _, link := range links {
+				identifiers = append(identifiers, link.Tag)
+			}
+
+			if got := strings.Join(identifiers, ","); got != tt.want {
+				t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+			}
+		}
+	})
+}
+
+func TestNamespaceStore(t *testing.T) {
+	forEachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+
+		createTestClients(t, stores, "owner", "member")
+
+		ns := &LinkrNamespace{Tag: "team", OwnerId: sql.NullString{String: "owner", Valid: true}}
+		if err := stores.Namespaces.Create(ctx, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Namespaces.Create(ctx, &LinkrNamespace{Tag: "team"}); !errors.Is(err, ErrRecordExists) {
+			t.Errorf("expected a taken tag to be refused, got %v", err)
+		}
+
+		description, empty := "links of the team", ""
+		if err := stores.Namespaces.Update(ctx, ns.Id, NamespaceUpdate{Description: &description, ExpiredUrl: &empty}); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := stores.Namespaces.Get(ctx, ns.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Description.String != description || found.ExpiredUrl.Valid {
+			t.Errorf("namespace wasn't updated: %+v", found)
+		}
+
+		if err := stores.Namespaces.PutMember(ctx, &NamespaceMember{NamespaceId: ns.Id, ClientId: "member", Role: linkr.RoleReadOnly}); err != nil {
+			t.Fatal(err)
+		}
+
+		joined, err := stores.Namespaces.Member(ctx, ns.Id, "member")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Namespaces.PutMember(ctx, &NamespaceMember{NamespaceId: ns.Id, ClientId: "member", Role: linkr.RoleReadWrite}); err != nil {
+			t.Fatal(err)
+		}
+
+		members, err := stores.Namespaces.Members(ctx, ns.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(members) != 1 || members[0].Role != linkr.RoleReadWrite || !members[0].CreatedAt.Equal(joined.CreatedAt) {
+			t.Errorf("expected the member to change role, keeping when it joined: %+v", members)
+		}
+
+		visible := func(access NamespaceAccess, includeArchived bool) string {
+			namespaces, err := stores.Namespaces.List(ctx, NamespaceFilter{Access: access, IncludeArchived: includeArchived})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags := []string{}
+			for _, ns := range namespaces {
+				tags = append(tags, ns.Tag)
+			}
+
+			return strings.Join(tags, ",")
+		}
+
+		if got := visible(NamespaceAccess{ClientId: "member", Roles: []string{linkr.RoleReadWrite}}, false); got != "-,team" {
+			t.Errorf("expected the member to see the namespace, got %s", got)
+		}
+
+		if got := visible(NamespaceAccess{ClientId: "member", Roles: []string{linkr.RoleAdmin}}, false); got != "-" {
+			t.Errorf("expected the namespace hidden without the role, got %s", got)
+		}
+
+		archivedAt := time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC)
+		for _, at := range []time.Time{archivedAt, archivedAt.Add(time.Hour)} {
+			if err := stores.Namespaces.Archive(ctx, ns.Id, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if found, _ := stores.Namespaces.Get(ctx, ns.Id); !found.ArchivedAt.Time.Equal(archivedAt) {
+			t.Errorf("expected the namespace to keep when it was first archived, got %s", found.ArchivedAt.Time)
+		}
+
+		if got := visible(NamespaceAccess{All: true}, false); got != "-" {
+			t.Errorf("expected archived namespaces left out, got %s", got)
+		}
+
+		if got := visible(NamespaceAccess{All: true}, true); got != "-,team" {
+			t.Errorf("expected archived namespaces included, got %s", got)
+		}
+
+		if err := stores.Links.Create(ctx, &Link{Tag: "abc", OriginalUrl: "https://dest.example", NamespaceId: int(ns.Id)}); err != nil {
+			t.Fatal(err)
+		}
+
+		if links, err := stores.Namespaces.Delete(ctx, ns.Id, false); !errors.Is(err, ErrRecordInUse) || links != 1 {
+			t.Errorf("expected a namespace with links to be kept, got %d and %v", links, err)
+		}
+
+		if _, err := stores.Namespaces.Delete(ctx, ns.Id, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stores.Links.GetByTag(ctx, "team", "abc"); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected the links deleted with the namespace, got %v", err)
+		}
+
+		if _, err := stores.Namespaces.Member(ctx, ns.Id, "member"); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected the members deleted with the namespace, got %v", err)
+		}
+	})
+}
+
+func TestClientStore(t *testing.T) {
+	forEachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+
+		client := &LinkrClient{Id: "api_1", Username: "bot-a", Scope: linkr.RoleWriteOnly, SigningKey: "first", Algorithm: SigningAlgHS256}
+		if err := stores.Clients.Create(ctx, client); err != nil {
+			t.Fatal(err)
+		}
+
+		err := stores.Clients.Create(ctx, &LinkrClient{Id: "api_2", Username: "bot-a", Scope: linkr.RoleWriteOnly, SigningKey: "other", Algorithm: SigningAlgHS256})
+		if !errors.Is(err, ErrRecordExists) {
+			t.Errorf("expected a taken username to be refused, got %v", err)
+		}
+
+		ns := &LinkrNamespace{Tag: "team", OwnerId: sql.NullString{String: client.Id, Valid: true}}
+		if err := stores.Namespaces.Create(ctx, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Namespaces.PutMember(ctx, &NamespaceMember{NamespaceId: ns.Id, ClientId: client.Id, Role: linkr.RoleReadOnly}); err != nil {
+			t.Fatal(err)
+		}
+
+		disabledAt := time.Date(2024, 5, 9, 0, 0, 0, 0, time.UTC)
+		if err := stores.Clients.SetDisabled(ctx, client.Id, &disabledAt); err != nil {
+			t.Fatal(err)
+		}
+
+		disabled, active := true, false
+		if clients, _ := stores.Clients.List(ctx, ClientFilter{Disabled: &disabled}); len(clients) != 1 {
+			t.Errorf("expected the client listed as disabled, got %d clients", len(clients))
+		}
+
+		if clients, _ := stores.Clients.List(ctx, ClientFilter{Disabled: &active}); len(clients) != 0 {
+			t.Errorf("expected no active clients, got %d", len(clients))
+		}
+
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		grace := now.Add(time.Hour)
+		if err := stores.Clients.RotateKey(ctx, client.Id, "second", now, &grace); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Clients.RotateKey(ctx, client.Id, "third", now, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, err := stores.Clients.Get(ctx, client.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := clientSigningKeys(ctx, stores.Clients, rotated, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 2 || keys[0].Key != "third" || keys[1].Key != "first" {
+			t.Errorf("expected the current key, then the key within its grace, got %+v", keys)
+		}
+
+		if keys, _ := stores.Clients.PreviousKeys(ctx, client.Id, grace); len(keys) != 0 {
+			t.Errorf("expected the previous key to stop working after its grace, got %+v", keys)
+		}
+
+		usedAt := now.Add(time.Minute)
+		for _, at := range []time.Time{usedAt, now} {
+			if err := stores.Clients.TouchLastUsed(ctx, map[string]time.Time{client.Id: at}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if found, _ := stores.Clients.Get(ctx, client.Id); !found.LastUsedAt.Time.Equal(usedAt) {
+			t.Errorf("expected the last use not to move back, got %s", found.LastUsedAt.Time)
+		}
+
+		if err := stores.Clients.Delete(ctx, client.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		if found, _ := stores.Namespaces.Get(ctx, ns.Id); found.OwnerId.Valid {
+			t.Errorf("expected the namespace left without an owner, got %s", found.OwnerId.String)
+		}
+
+		if _, err := stores.Namespaces.Member(ctx, ns.Id, client.Id); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected the memberships deleted with the client, got %v", err)
+		}
+
+		if _, err := stores.Clients.Get(ctx, client.Id); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("expected the client to be gone, got %v", err)
+		}
+	})
+}
+
+// the handlers only need the stores, so they run without a database
+func TestLinkApiWithMemoryStores(t *testing.T) {
+	stores := NewMemoryStores()
+	dfNs, err := stores.Namespaces.GetByTag(context.Background(), linkr.ReservedGlobalChar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := newTestApiHandler(nil, dfNs)
+	a := NewApiHandler(stores, test.shortner, dfNs, test.policy, DefaultKeyRotationGrace)
+
+	rec := httptest.NewRecorder()
+	a.HandleCreateLink(rec, withTestClient(httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"redirect_url": "https://dest.example", "identifier": "abc"}`)), testAdmin))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the link to be created, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	api := newTestLinkApi(a)
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/links/-/abc", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the link to be found, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/links/-/abc", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the link to be deleted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/links/-/abc", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected the deleted link to be gone, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,213 @@
+// Stores timing the queries of the stores they wrap, for the metrics
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// Stores whose queries are timed. Wrapped by the cache, only
+// the lookups that reach the database are timed
+func (m *Metrics) Wrap(stores *Stores) *Stores {
+	return &Stores{
+		Links:      &timedLinkStore{next: stores.Links, metrics: m},
+		Namespaces: &timedNamespaceStore{next: stores.Namespaces, metrics: m},
+		Clients:    &timedClientStore{next: stores.Clients, metrics: m},
+		Stats:      &timedStatsStore{next: stores.Stats, metrics: m},
+	}
+}
+
+type timedLinkStore struct {
+	next    LinkStore
+	metrics *Metrics
+}
+
+func (s *timedLinkStore) Get(ctx context.Context, namespaceId int64, identifier string) (*Link, error) {
+	defer s.metrics.query("links", "get", time.Now())
+	return s.next.Get(ctx, namespaceId, identifier)
+}
+
+func (s *timedLinkStore) GetByTag(ctx context.Context, namespace string, identifier string) (*NamespacedLink, error) {
+	defer s.metrics.query("links", "get_by_tag", time.Now())
+	return s.next.GetByTag(ctx, namespace, identifier)
+}
+
+func (s *timedLinkStore) List(ctx context.Context, filter LinkFilter) ([]NamespacedLink, error) {
+	defer s.metrics.query("links", "list", time.Now())
+	return s.next.List(ctx, filter)
+}
+
+func (s *timedLinkStore) Create(ctx context.Context, link *Link) error {
+	defer s.metrics.query("links", "create", time.Now())
+	return s.next.Create(ctx, link)
+}
+
+func (s *timedLinkStore) Update(ctx context.Context, id int, update LinkUpdate) error {
+	defer s.metrics.query("links", "update", time.Now())
+	return s.next.Update(ctx, id, update)
+}
+
+func (s *timedLinkStore) Delete(ctx context.Context, id int) error {
+	defer s.metrics.query("links", "delete", time.Now())
+	return s.next.Delete(ctx, id)
+}
+
+func (s *timedLinkStore) TakenIdentifiers(ctx context.Context, namespaceId int64, candidates []string) ([]string, error) {
+	defer s.metrics.query("links", "taken_identifiers", time.Now())
+	return s.next.TakenIdentifiers(ctx, namespaceId, candidates)
+}
+
+func (s *timedLinkStore) NextCounterValue(ctx context.Context, namespaceId int64) (uint64, error) {
+	defer s.metrics.query("links", "next_counter_value", time.Now())
+	return s.next.NextCounterValue(ctx, namespaceId)
+}
+
+type timedNamespaceStore struct {
+	next    NamespaceStore
+	metrics *Metrics
+}
+
+func (s *timedNamespaceStore) Get(ctx context.Context, id int64) (*LinkrNamespace, error) {
+	defer s.metrics.query("namespaces", "get", time.Now())
+	return s.next.Get(ctx, id)
+}
+
+func (s *timedNamespaceStore) GetByTag(ctx context.Context, tag string) (*LinkrNamespace, error) {
+	defer s.metrics.query("namespaces", "get_by_tag", time.Now())
+	return s.next.GetByTag(ctx, tag)
+}
+
+func (s *timedNamespaceStore) List(ctx context.Context, filter NamespaceFilter) ([]LinkrNamespace, error) {
+	defer s.metrics.query("namespaces", "list", time.Now())
+	return s.next.List(ctx, filter)
+}
+
+func (s *timedNamespaceStore) Create(ctx context.Context, ns *LinkrNamespace) error {
+	defer s.metrics.query("namespaces", "create", time.Now())
+	return s.next.Create(ctx, ns)
+}
+
+func (s *timedNamespaceStore) Update(ctx context.Context, id int64, update NamespaceUpdate) error {
+	defer s.metrics.query("namespaces", "update", time.Now())
+	return s.next.Update(ctx, id, update)
+}
+
+func (s *timedNamespaceStore) Archive(ctx context.Context, id int64, at time.Time) error {
+	defer s.metrics.query("namespaces", "archive", time.Now())
+	return s.next.Archive(ctx, id, at)
+}
+
+func (s *timedNamespaceStore) Delete(ctx context.Context, id int64, cascade bool) (int, error) {
+	defer s.metrics.query("namespaces", "delete", time.Now())
+	return s.next.Delete(ctx, id, cascade)
+}
+
+func (s *timedNamespaceStore) Member(ctx context.Context, namespaceId int64, clientId string) (*NamespaceMember, error) {
+	defer s.metrics.query("namespaces", "member", time.Now())
+	return s.next.Member(ctx, namespaceId, clientId)
+}
+
+func (s *timedNamespaceStore) Members(ctx context.Context, namespaceId int64) ([]NamespaceMember, error) {
+	defer s.metrics.query("namespaces", "members", time.Now())
+	return s.next.Members(ctx, namespaceId)
+}
+
+func (s *timedNamespaceStore) PutMember(ctx context.Context, member *NamespaceMember) error {
+	defer s.metrics.query("namespaces", "put_member", time.Now())
+	return s.next.PutMember(ctx, member)
+}
+
+func (s *timedNamespaceStore) DeleteMember(ctx context.Context, namespaceId int64, clientId string) error {
+	defer s.metrics.query("namespaces", "delete_member", time.Now())
+	return s.next.DeleteMember(ctx, namespaceId, clientId)
+}
+
+type timedClientStore struct {
+	next    ClientStore
+	metrics *Metrics
+}
+
+func (s *timedClientStore) Get(ctx context.Context, id string) (*LinkrClient, error) {
+	defer s.metrics.query("clients", "get", time.Now())
+	return s.next.Get(ctx, id)
+}
+
+func (s *timedClientStore) List(ctx context.Context, filter ClientFilter) ([]LinkrClient, error) {
+	defer s.metrics.query("clients", "list", time.Now())
+	return s.next.List(ctx, filter)
+}
+
+func (s *timedClientStore) Create(ctx context.Context, client *LinkrClient) error {
+	defer s.metrics.query("clients", "create", time.Now())
+	return s.next.Create(ctx, client)
+}
+
+func (s *timedClientStore) Update(ctx context.Context, id string, update ClientUpdate) error {
+	defer s.metrics.query("clients", "update", time.Now())
+	return s.next.Update(ctx, id, update)
+}
+
+func (s *timedClientStore) SetDisabled(ctx context.Context, id string, at *time.Time) error {
+	defer s.metrics.query("clients", "set_disabled", time.Now())
+	return s.next.SetDisabled(ctx, id, at)
+}
+
+func (s *timedClientStore) Delete(ctx context.Context, id string) error {
+	defer s.metrics.query("clients", "delete", time.Now())
+	return s.next.Delete(ctx, id)
+}
+
+func (s *timedClientStore) PreviousKeys(ctx context.Context, id string, now time.Time) ([]SigningKey, error) {
+	defer s.metrics.query("clients", "previous_keys", time.Now())
+	return s.next.PreviousKeys(ctx, id, now)
+}
+
+func (s *timedClientStore) RotateKey(ctx context.Context, id string, key string, now time.Time, previousExpiresAt *time.Time) error {
+	defer s.metrics.query("clients", "rotate_key", time.Now())
+	return s.next.RotateKey(ctx, id, key, now, previousExpiresAt)
+}
+
+func (s *timedClientStore) TouchLastUsed(ctx context.Context, uses map[string]time.Time) error {
+	defer s.metrics.query("clients", "touch_last_used", time.Now())
+	return s.next.TouchLastUsed(ctx, uses)
+}
+
+type timedStatsStore struct {
+	next    StatsStore
+	metrics *Metrics
+}
+
+func (s *timedStatsStore) AddClicks(ctx context.Context, clicks []ClickEvent) error {
+	defer s.metrics.query("stats", "add_clicks", time.Now())
+	return s.next.AddClicks(ctx, clicks)
+}
+
+func (s *timedStatsStore) RollupClicks(ctx context.Context, until time.Time, limit int) (int, error) {
+	defer s.metrics.query("stats", "rollup_clicks", time.Now())
+	return s.next.RollupClicks(ctx, until, limit)
+}
+
+func (s *timedStatsStore) HourlyClicks(ctx context.Context, filter StatsFilter) (map[time.Time]int64, error) {
+	defer s.metrics.query("stats", "hourly_clicks", time.Now())
+	return s.next.HourlyClicks(ctx, filter)
+}
+
+func (s *timedStatsStore) UniqueVisitors(ctx context.Context, filter StatsFilter) (int64, error) {
+	defer s.metrics.query("stats", "unique_visitors", time.Now())
+	return s.next.UniqueVisitors(ctx, filter)
+}
+
+func (s *timedStatsStore) TopReferrers(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	defer s.metrics.query("stats", "top_referrers", time.Now())
+	return s.next.TopReferrers(ctx, filter, limit)
+}
+
+func (s *timedStatsStore) TopAgents(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	defer s.metrics.query("stats", "top_agents", time.Now())
+	return s.next.TopAgents(ctx, filter, limit)
+}
+
+func (s *timedStatsStore) TopLinks(ctx context.Context, filter StatsFilter, limit int) ([]ClickCount, error) {
+	defer s.metrics.query("stats", "top_links", time.Now())
+	return s.next.TopLinks(ctx, filter, limit)
+}
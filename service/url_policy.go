@@ -0,0 +1,232 @@
+// Checks on the destinations links redirect to
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Policy deciding which urls links can redirect to
+type URLPolicy struct {
+	// schemes destinations can use
+	AllowedSchemes []string
+	// destinations can't point to these hosts, or their subdomains
+	DenyHosts []string
+	// when defined, destinations can only point to these hosts, or their subdomains
+	AllowHosts []string
+	// host serving the shortened links. destinations pointing
+	// back to it would redirect in a loop
+	BaseHost string
+	// reject destinations that are, or resolve to,
+	// private, loopback or link-local addresses
+	BlockPrivate bool
+
+	// allow lists of the namespace and client, each satisfied along with `AllowHosts`
+	scopedAllowHosts [][]string
+
+	// resolves the addresses of a host
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// Creates the policy from the environment
+//
+//   - LINKR_URL_ALLOWED_SCHEMES: comma separated. defaults to `http,https`
+//   - LINKR_URL_DENY_HOSTS: comma separated
+//   - LINKR_URL_ALLOW_HOSTS: comma separated
+//   - LINKR_URL_BLOCK_PRIVATE: `false` to allow private addresses
+func NewURLPolicyFromEnv(baseUrl string) (*URLPolicy, error) {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+
+	schemes := splitList(os.Getenv("LINKR_URL_ALLOWED_SCHEMES"))
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+
+	return &URLPolicy{
+		AllowedSchemes: schemes,
+		DenyHosts:      splitList(os.Getenv("LINKR_URL_DENY_HOSTS")),
+		AllowHosts:     splitList(os.Getenv("LINKR_URL_ALLOW_HOSTS")),
+		BaseHost:       strings.ToLower(base.Hostname()),
+		BlockPrivate:   os.Getenv("LINKR_URL_BLOCK_PRIVATE") != "false",
+	}, nil
+}
+
+// Restricts the policy with the host lists of the namespace.
+// Denied hosts add up, while both allow lists must be satisfied
+func (p *URLPolicy) ForNamespace(ns *LinkrNamespace) *URLPolicy {
+	return p.restrict(ns.AllowHosts.String, ns.DenyHosts.String)
+}
+
+// Restricts the policy with the host lists of the client,
+// the same way as `ForNamespace`
+func (p *URLPolicy) ForClient(client *LinkrClient) *URLPolicy {
+	return p.restrict(client.AllowHosts.String, client.DenyHosts.String)
+}
+
+// copies the policy, adding the comma separated host lists
+func (p *URLPolicy) restrict(allowHosts string, denyHosts string) *URLPolicy {
+	scoped := *p
+	scoped.DenyHosts = append(append([]string{}, p.DenyHosts...), splitList(denyHosts)...)
+
+	if allow := splitList(allowHosts); len(allow) > 0 {
+		scoped.scopedAllowHosts = append(append([][]string{}, p.scopedAllowHosts...), allow)
+	}
+
+	return &scoped
+}
+
+// Normalizes the destination `raw`, checking it against the policy.
+// Destinations that can't be used are reported as a validation error of `field`
+func (p *URLPolicy) Check(ctx context.Context, field string, raw string) (string, error) {
+	reject := func(message string) (string, error) {
+		return "", ErrValidation(FieldError{Field: field, Message: message})
+	}
+
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return reject("must be a valid url")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "" || u.Host == "" {
+		return reject("must be an absolute url, like https://examp.le")
+	}
+
+	if !includes(p.AllowedSchemes, u.Scheme) {
+		return reject(fmt.Sprintf("scheme must be one of %v", p.AllowedSchemes))
+	}
+
+	// hides the real host, as in https://examp.le@evil.com
+	if u.User != nil {
+		return reject("can't contain credentials")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	u.Host = host
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// ipv6 literal
+		u.Host = "[" + host + "]"
+	}
+
+	if p.BaseHost != "" && host == p.BaseHost {
+		return reject("can't point back to linkr")
+	}
+
+	if matchesHost(host, p.DenyHosts) {
+		return reject(fmt.Sprintf("host '%s' is not allowed", host))
+	}
+
+	for _, allow := range append([][]string{p.AllowHosts}, p.scopedAllowHosts...) {
+		if len(allow) > 0 && !matchesHost(host, allow) {
+			return reject(fmt.Sprintf("host '%s' is not allowed", host))
+		}
+	}
+
+	if p.BlockPrivate {
+		ips, err := p.resolve(ctx, host)
+		if err != nil {
+			return reject(fmt.Sprintf("host '%s' couldn't be resolved", host))
+		}
+
+		for _, ip := range ips {
+			if isPrivateIP(ip) {
+				return reject("can't point to a private address")
+			}
+		}
+	}
+
+	return u.String(), nil
+}
+
+func (p *URLPolicy) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	lookupIP := p.lookupIP
+	if lookupIP == nil {
+		lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return lookupIP(ctx, host)
+}
+
+// shared address space of carrier-grade NATs (RFC 6598)
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() ||
+		cgnatRange.Contains(ip)
+}
+
+// checks if `host` is one of `hosts`, or their subdomain
+func matchesHost(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks the hosts of a list given to the api, like `examp.le`
+func validateHostList(field string, hosts []string) []FieldError {
+	fields := []FieldError{}
+	for _, host := range hosts {
+		if strings.TrimSpace(host) == "" || strings.ContainsAny(host, "/:@,? ") {
+			fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf("'%s' must be a host, like examp.le", host)})
+		}
+	}
+
+	return fields
+}
+
+// joins the hosts of a list given to the api, as they're stored
+func joinHostList(hosts []string) string {
+	return strings.Join(splitList(strings.Join(hosts, ",")), ",")
+}
+
+// host list given to the api as it's stored. null when empty
+func storedHostList(hosts []string) sql.NullString {
+	joined := joinHostList(hosts)
+	return sql.NullString{String: joined, Valid: joined != ""}
+}
+
+// splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
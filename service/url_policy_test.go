@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestURLPolicyCheck(t *testing.T) {
+	policy := &URLPolicy{
+		AllowedSchemes: []string{"http", "https"},
+		DenyHosts:      []string{"evil.example"},
+		BaseHost:       "examp.le",
+		BlockPrivate:   true,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			switch host {
+			case "intranet.example":
+				return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.12")}, nil
+			case "nxdomain.example":
+				return nil, errors.New("no such host")
+			}
+
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		},
+	}
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"  HTTPS://WWW.Example.COM.:443/Path?q=1#top ", "https://www.example.com/Path?q=1#top"},
+		{"http://example.com:8080/", "http://example.com:8080/"},
+		{"http://[2606:2800:220:1:248:1893:25c8:1946]:80/", "http://[2606:2800:220:1:248:1893:25c8:1946]/"},
+		{"", ""},
+		{"javascript:alert(1)", ""},
+		{"/relative/path", ""},
+		{"example.com/no-scheme", ""},
+		{"ftp://example.com/file", ""},
+		{"https://www.example.com@evil.example/", ""},
+		{"https://examp.le/v00qDJvyc", ""},
+		{"https://EVIL.example/", ""},
+		{"https://login.evil.example/", ""},
+		{"https://notevil.example/", "https://notevil.example/"},
+		{"http://127.0.0.1/admin", ""},
+		{"http://169.254.169.254/latest/meta-data", ""},
+		{"http://[::1]/", ""},
+		{"http://100.64.1.1/", ""},
+		{"https://intranet.example/", ""},
+		{"https://nxdomain.example/", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := policy.Check(context.Background(), "redirect_url", tt.raw)
+		if tt.want == "" {
+			apiErr := new(ApiError)
+			if !errors.As(err, &apiErr) || apiErr.Code != ErrCodeValidationFailed || apiErr.Fields[0].Field != "redirect_url" {
+				t.Errorf("expected '%s' to be rejected, got '%s' %v", tt.raw, got, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expected '%s' to be accepted: %s", tt.raw, err)
+		} else if got != tt.want {
+			t.Errorf("'%s' normalized to '%s', want '%s'", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestURLPolicyForNamespace(t *testing.T) {
+	policy := &URLPolicy{
+		AllowedSchemes: []string{"https"},
+		AllowHosts:     []string{"example.com", "example.org"},
+	}
+
+	ns := &LinkrNamespace{
+		AllowHosts: sql.NullString{String: "docs.example.com, example.org", Valid: true},
+		DenyHosts:  sql.NullString{String: "private.example.org", Valid: true},
+	}
+
+	tests := map[string]bool{
+		"https://docs.example.com/":    true,
+		"https://example.org/":         true,
+		"https://www.example.com/":     false,
+		"https://private.example.org/": false,
+		"https://example.net/":         false,
+	}
+
+	scoped := policy.ForNamespace(ns)
+	for raw, allowed := range tests {
+		_, err := scoped.Check(context.Background(), "redirect_url", raw)
+		if allowed != (err == nil) {
+			t.Errorf("'%s' allowed = %v, want %v (%v)", raw, err == nil, allowed, err)
+		}
+	}
+
+	// namespace rules don't leak into the policy
+	if _, err := policy.Check(context.Background(), "redirect_url", "https://www.example.com/"); err != nil {
+		t.Errorf("expected global policy to be unchanged: %s", err)
+	}
+}